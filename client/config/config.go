@@ -5,6 +5,8 @@ import (
     "flag"
     "fmt"
     "os"
+    "strings"
+    "time"
 )
 
 type Config struct {
@@ -12,9 +14,61 @@ type Config struct {
     Port      int    `json:"port"`
     Subdomain string `json:"subdomain"`
     UseHTTPS  bool   `json:"use_https"`
+    Token     string `json:"token"`
 }
 
-func Load() (*Config, *string, *int, error) {
+// arguments of "mole <mode> <port> [flags]"
+type Args struct {
+    Mode       string
+    LocalPort  int
+    Subdomain  *string
+    RemotePort int
+    Takeover   bool
+    
+    // what the server does with plain http while it serves https,
+    // "redirect", "both" or "http-only", empty keeps its default
+    HTTPPolicy string
+    
+    // heartbeat pings to the server, a zero interval disables them
+    PingInterval time.Duration
+    PingTimeout  time.Duration
+    
+    // how long in-flight requests get to finish on shutdown
+    DrainTimeout time.Duration
+    
+    // address of the request inspector for http tunnels, empty disables it
+    InspectAddr string
+    
+    // har file every http exchange is written to, empty disables it. it
+    // is rotated past RecordMaxSize bytes, Redact lists the rules that
+    // blank out secrets first.
+    RecordPath    string
+    RecordMaxSize int64
+    Redact        []string
+    
+    // address prometheus metrics are served on, empty disables them
+    MetricsAddr string
+    
+    // "mole replay [id]": the captured request to send again, with headers
+    // given as "Key: value" and a body, "@file" reading it from a file
+    ReplayID      string
+    ReplayHeaders []string
+    ReplayBody    *string
+}
+
+// a flag that can be repeated, collecting every value
+type listFlag []string
+
+func (l *listFlag) String() string {
+    return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(value string) error {
+    *l = append(*l, value)
+    return nil
+}
+
+func Load() (*Config, *Args, error) {
     cfg := &Config{}
     
     // load from config file first
@@ -23,26 +77,84 @@ func Load() (*Config, *string, *int, error) {
     }
     
     // parse command line arguments
-    var subdomain *string
-    var localPort *int
+    args := &Args{}
     
-    if len(os.Args) >= 3 && os.Args[1] == "http" {
+    if len(os.Args) >= 3 && (os.Args[1] == "http" || os.Args[1] == "tcp" || os.Args[1] == "udp") {
+        args.Mode = os.Args[1]
+        
         // extract port from "mole http 8000"
         localPortValue := 0
-        if len(os.Args) >= 3 {
-            if _, err := fmt.Sscanf(os.Args[2], "%d", &localPortValue); err == nil {
-                localPort = &localPortValue
-            }
+        if _, err := fmt.Sscanf(os.Args[2], "%d", &localPortValue); err == nil {
+            args.LocalPort = localPortValue
         }
         
         // check for flags
         flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
         subdomainFlag := flag.String("d", "", "subdomain to use")
+        remotePortFlag := flag.Int("r", 0, "public port to request for tcp and udp tunnels")
+        tokenFlag := flag.String("token", "", "api token to register with")
+        httpPolicyFlag := flag.String("http-policy", "", "plain http handling when the server has https: redirect, both or http-only")
+        takeoverFlag := flag.Bool("takeover", false, "replace a connected tunnel on the same subdomain owned by this token")
+        pingIntervalFlag := flag.Duration("ping-interval", 20*time.Second, "how often to ping the server, 0 disables pings")
+        pingTimeoutFlag := flag.Duration("ping-timeout", 10*time.Second, "how long to wait for a pong before reconnecting")
+        drainTimeoutFlag := flag.Duration("drain-timeout", 30*time.Second, "how long to wait for in-flight requests when shutting down")
+        inspectFlag := flag.String("inspect", "localhost:4040", "address of the request inspector, empty to disable it")
+        recordFlag := flag.String("record", "", "har file to write every http exchange to")
+        recordMaxSizeFlag := flag.Int64("record-max-size", 100, "megabytes a har file may grow to before it is rotated, 0 never rotates")
+        var redact listFlag
+        flag.Var(&redact, "redact", "hide secrets in the har file: header:<name>, field:<name> or regex:<expr>; repeatable")
+        metricsFlag := flag.String("metrics", "", "address to serve prometheus metrics on, e.g. localhost:9100")
         flag.CommandLine.Parse(os.Args[3:])
         
+        args.Takeover = *takeoverFlag
+        args.HTTPPolicy = *httpPolicyFlag
+        args.PingInterval = *pingIntervalFlag
+        args.PingTimeout = *pingTimeoutFlag
+        args.DrainTimeout = *drainTimeoutFlag
+        args.InspectAddr = *inspectFlag
+        args.RecordPath = *recordFlag
+        args.RecordMaxSize = *recordMaxSizeFlag << 20
+        args.Redact = redact
+        args.MetricsAddr = *metricsFlag
+        
+        if *tokenFlag != "" {
+            cfg.Token = *tokenFlag
+        }
+        
         if *subdomainFlag != "" {
-            subdomain = subdomainFlag
+            args.Subdomain = subdomainFlag
+        }
+        args.RemotePort = *remotePortFlag
+    }
+    
+    if len(os.Args) >= 2 && os.Args[1] == "replay" {
+        args.Mode = os.Args[1]
+        
+        // the id comes first, "mole replay <id> -H ..."
+        rest := os.Args[2:]
+        if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+            args.ReplayID, rest = rest[0], rest[1:]
         }
+        
+        flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+        var headers listFlag
+        flag.Var(&headers, "H", "header to replace, \"Key: value\", or \"Key:\" to remove it; repeatable")
+        bodyFlag := flag.String("d", "", "body to send instead of the captured one, @file to read it from a file")
+        inspectFlag := flag.String("inspect", "localhost:4040", "address of the running client's inspector")
+        flag.CommandLine.Parse(rest)
+        
+        args.ReplayHeaders = headers
+        args.InspectAddr = *inspectFlag
+        flag.Visit(func(f *flag.Flag) {
+            if f.Name == "d" {
+                args.ReplayBody = bodyFlag
+            }
+        })
+    }
+    
+    // the environment is used when neither the file nor a flag set a token
+    if cfg.Token == "" {
+        cfg.Token = os.Getenv("MOLE_TOKEN")
     }
     
     // set defaults
@@ -53,5 +165,5 @@ func Load() (*Config, *string, *int, error) {
         cfg.Port = 80
     }
     
-    return cfg, subdomain, localPort, nil
+    return cfg, args, nil
 }
\ No newline at end of file
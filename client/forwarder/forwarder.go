@@ -1,7 +1,7 @@
 package forwarder
 
 import (
-    "bytes"
+    "context"
     "fmt"
     "io"
     "log"
@@ -15,101 +15,96 @@ type Forwarder struct {
     client    *http.Client
 }
 
+// Trailer holds the declared trailer names until Body is fully read, the
+// values are filled in after that
 type Response struct {
-    ID         string            `json:"id"`
-    StatusCode int               `json:"status_code"`
-    Headers    map[string]string `json:"headers"`
-    Body       []byte            `json:"body"`
+    StatusCode int
+    Headers    http.Header
+    Trailer    http.Header
+    Body       io.ReadCloser
 }
 
 func NewForwarder(localPort int) *Forwarder {
     return &Forwarder{
         localPort: localPort,
         client: &http.Client{
-            Timeout: 30 * time.Second,
+            // bound the wait for headers only, bodies may stream for much longer
+            Transport: &http.Transport{
+                Proxy:                 http.ProxyFromEnvironment,
+                ResponseHeaderTimeout: 30 * time.Second,
+                IdleConnTimeout:       90 * time.Second,
+            },
         },
     }
 }
 
-func (f *Forwarder) Forward(method, urlPath string, headers map[string]string, body []byte) (*Response, error) {
+// Forward sends a request to the local server. body is streamed as it is
+// read and the returned response body must be closed by the caller. trailer
+// values must be filled in by the body reader before it returns io.EOF.
+func (f *Forwarder) Forward(ctx context.Context, method, urlPath string, headers, trailer http.Header, body io.Reader) (*Response, error) {
     // construct local url
     localURL := fmt.Sprintf("http://localhost:%d%s", f.localPort, urlPath)
     log.Printf("[FORWARDER] Forwarding %s %s to %s", method, urlPath, localURL)
     
     // create request
-    var bodyReader io.Reader
-    if len(body) > 0 {
-        bodyReader = bytes.NewReader(body)
-    }
-    
-    req, err := http.NewRequest(method, localURL, bodyReader)
+    req, err := http.NewRequestWithContext(ctx, method, localURL, body)
     if err != nil {
         log.Printf("[FORWARDER] Failed to create request: %v", err)
         return nil, fmt.Errorf("failed to create request: %v", err)
     }
     
     // add headers with proper filtering
-    for key, value := range headers {
+    for key, values := range headers {
         normalizedKey := http.CanonicalHeaderKey(key)
+        if len(values) == 0 {
+            continue
+        }
         
         // skip problematic headers that should be handled by http client
         switch normalizedKey {
-        case "Connection", "Upgrade", "Proxy-Connection", "Transfer-Encoding":
+        case "Connection", "Upgrade", "Proxy-Connection", "Transfer-Encoding", "Trailer":
             continue
         case "Content-Length":
-            // only set if we have a body and the value is valid
-            if len(body) > 0 {
-                if contentLength, err := strconv.Atoi(value); err == nil && contentLength == len(body) {
-                    req.Header.Set(key, value)
+            // a known length is sent as is, otherwise the body goes out chunked
+            if contentLength, err := strconv.ParseInt(values[0], 10, 64); err == nil && contentLength >= 0 {
+                req.ContentLength = contentLength
+                if contentLength == 0 {
+                    req.Body = http.NoBody
                 }
             }
         case "Host":
             // set host to localhost for local forwarding
             req.Host = fmt.Sprintf("localhost:%d", f.localPort)
         default:
-            req.Header.Set(key, value)
+            for _, value := range values {
+                req.Header.Add(normalizedKey, value)
+            }
         }
     }
     
-    // ensure proper content-length for requests with body
-    if len(body) > 0 && req.Header.Get("Content-Length") == "" {
-        req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+    if len(trailer) > 0 {
+        req.Trailer = trailer
     }
     
-    log.Printf("[FORWARDER] Making request with %d headers, body size: %d bytes", len(req.Header), len(body))
+    log.Printf("[FORWARDER] Making request with %d headers, content length: %d", len(req.Header), req.ContentLength)
     
     // make request
+    start := time.Now()
     resp, err := f.client.Do(req)
     if err != nil {
+        forwardErrors.Inc("http", errorReason(err))
         log.Printf("[FORWARDER] Request failed: %v", err)
         return nil, fmt.Errorf("request failed: %v", err)
     }
-    defer resp.Body.Close()
+    forwardSeconds.Observe(time.Since(start).Seconds(), "http")
+    responsesTotal.Inc(statusClass(resp.StatusCode))
     
     log.Printf("[FORWARDER] Received response: status %d", resp.StatusCode)
     
-    // read response body
-    respBody, err := io.ReadAll(resp.Body)
-    if err != nil {
-        log.Printf("[FORWARDER] Failed to read response body: %v", err)
-        return nil, fmt.Errorf("failed to read response: %v", err)
-    }
-    
-    log.Printf("[FORWARDER] Response body size: %d bytes", len(respBody))
-    
-    // prepare response headers with proper filtering
-    respHeaders := make(map[string]string)
-    for key, values := range resp.Header {
-        if len(values) > 0 {
-            normalizedKey := http.CanonicalHeaderKey(key)
-            // include all headers, filtering will be done at proxy level
-            respHeaders[normalizedKey] = values[0]
-        }
-    }
-    
     return &Response{
         StatusCode: resp.StatusCode,
-        Headers:    respHeaders,
-        Body:       respBody,
+        Headers:    resp.Header,
+        Trailer:    resp.Trailer,
+        Body:       resp.Body,
     }, nil
 }
\ No newline at end of file
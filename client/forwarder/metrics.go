@@ -0,0 +1,37 @@
+package forwarder
+
+import (
+    "context"
+    "errors"
+    "net"
+    "strconv"
+    "syscall"
+    
+    "mole/internal/metrics"
+)
+
+var (
+    forwardSeconds = metrics.Default.Histogram("mole_client_forward_seconds", "Time the local service took to answer, response headers for http and the connect for tcp.", metrics.DefaultBuckets, "kind")
+    forwardErrors  = metrics.Default.Counter("mole_client_forward_errors_total", "Requests and connections that could not reach the local service, by kind and reason.", "kind", "reason")
+    responsesTotal = metrics.Default.Counter("mole_client_responses_total", "Responses from the local service, by status class.", "class")
+)
+
+// errorReason sorts a forwarding error into refused, timeout, canceled or
+// other
+func errorReason(err error) string {
+    var netErr net.Error
+    switch {
+    case errors.Is(err, context.Canceled):
+        return "canceled"
+    case errors.Is(err, syscall.ECONNREFUSED):
+        return "refused"
+    case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+        return "timeout"
+    default:
+        return "other"
+    }
+}
+
+func statusClass(status int) string {
+    return strconv.Itoa(status/100) + "xx"
+}
\ No newline at end of file
@@ -0,0 +1,35 @@
+package forwarder
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "time"
+)
+
+// dials the local service for every connection accepted on a tcp tunnel
+type TCPForwarder struct {
+    localPort int
+    dialer    *net.Dialer
+}
+
+func NewTCPForwarder(localPort int) *TCPForwarder {
+    return &TCPForwarder{
+        localPort: localPort,
+        dialer:    &net.Dialer{Timeout: 10 * time.Second},
+    }
+}
+
+func (f *TCPForwarder) Dial(ctx context.Context) (net.Conn, error) {
+    localAddr := fmt.Sprintf("localhost:%d", f.localPort)
+    start := time.Now()
+    conn, err := f.dialer.DialContext(ctx, "tcp", localAddr)
+    if err != nil {
+        forwardErrors.Inc("tcp", errorReason(err))
+        log.Printf("[FORWARDER] Dial %s failed: %v", localAddr, err)
+        return nil, fmt.Errorf("dial failed: %v", err)
+    }
+    forwardSeconds.Observe(time.Since(start).Seconds(), "tcp")
+    return conn, nil
+}
\ No newline at end of file
@@ -0,0 +1,30 @@
+package forwarder
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+)
+
+// opens a local udp socket per public peer of a udp tunnel, so replies from
+// the local service can be told apart
+type UDPForwarder struct {
+    localPort int
+}
+
+func NewUDPForwarder(localPort int) *UDPForwarder {
+    return &UDPForwarder{localPort: localPort}
+}
+
+func (f *UDPForwarder) Dial(ctx context.Context) (net.Conn, error) {
+    localAddr := fmt.Sprintf("localhost:%d", f.localPort)
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "udp", localAddr)
+    if err != nil {
+        forwardErrors.Inc("udp", errorReason(err))
+        log.Printf("[FORWARDER] Dial %s failed: %v", localAddr, err)
+        return nil, fmt.Errorf("dial failed: %v", err)
+    }
+    return conn, nil
+}
\ No newline at end of file
@@ -0,0 +1,135 @@
+package forwarder
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const handshakeTimeout = 30 * time.Second
+
+// IsUpgrade reports whether the headers ask to switch protocols, for
+// example to a websocket
+func IsUpgrade(headers http.Header) bool {
+    if headers.Get("Upgrade") == "" {
+        return false
+    }
+    for _, value := range headers.Values("Connection") {
+        for _, token := range strings.Split(value, ",") {
+            if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// Upgrade sends an upgrade request to the local server on a dedicated
+// connection. when the server switches protocols the returned conn carries
+// the upgraded traffic, otherwise conn is nil and resp is a regular response.
+func (f *Forwarder) Upgrade(ctx context.Context, method, urlPath string, headers http.Header) (*Response, net.Conn, error) {
+    localAddr := fmt.Sprintf("localhost:%d", f.localPort)
+    log.Printf("[FORWARDER] Upgrading %s %s via %s", method, urlPath, localAddr)
+    
+    start := time.Now()
+    dialer := &net.Dialer{Timeout: 10 * time.Second}
+    conn, err := dialer.DialContext(ctx, "tcp", localAddr)
+    if err != nil {
+        forwardErrors.Inc("http", errorReason(err))
+        log.Printf("[FORWARDER] Dial failed: %v", err)
+        return nil, nil, fmt.Errorf("dial failed: %v", err)
+    }
+    
+    req, err := http.NewRequestWithContext(ctx, method, "http://"+localAddr+urlPath, nil)
+    if err != nil {
+        conn.Close()
+        return nil, nil, fmt.Errorf("failed to create request: %v", err)
+    }
+    
+    // keep the upgrade headers this time, the local server needs them
+    for key, values := range headers {
+        if http.CanonicalHeaderKey(key) == "Host" {
+            continue
+        }
+        for _, value := range values {
+            req.Header.Add(key, value)
+        }
+    }
+    req.Host = localAddr
+    
+    // abort the handshake if the public caller goes away
+    conn.SetDeadline(time.Now().Add(handshakeTimeout))
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    defer stop()
+    
+    if err := req.Write(conn); err != nil {
+        forwardErrors.Inc("http", errorReason(err))
+        conn.Close()
+        return nil, nil, fmt.Errorf("request failed: %v", err)
+    }
+    
+    reader := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(reader, req)
+    if err != nil {
+        forwardErrors.Inc("http", errorReason(err))
+        conn.Close()
+        return nil, nil, fmt.Errorf("failed to read response: %v", err)
+    }
+    conn.SetDeadline(time.Time{})
+    forwardSeconds.Observe(time.Since(start).Seconds(), "http")
+    responsesTotal.Inc(statusClass(resp.StatusCode))
+    
+    log.Printf("[FORWARDER] Received upgrade response: status %d", resp.StatusCode)
+    
+    if resp.StatusCode != http.StatusSwitchingProtocols {
+        // a refused upgrade is an ordinary response, closing its body
+        // releases the dedicated connection
+        return &Response{
+            StatusCode: resp.StatusCode,
+            Headers:    resp.Header,
+            Trailer:    resp.Trailer,
+            Body:       &connBody{ReadCloser: resp.Body, conn: conn},
+        }, nil, nil
+    }
+    
+    return &Response{
+        StatusCode: resp.StatusCode,
+        Headers:    resp.Header,
+        Body:       http.NoBody,
+    }, &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// closes the dedicated connection along with the response body
+type connBody struct {
+    io.ReadCloser
+    conn net.Conn
+}
+
+func (b *connBody) Close() error {
+    err := b.ReadCloser.Close()
+    b.conn.Close()
+    return err
+}
+
+// keeps the bytes the response reader buffered past the handshake
+type bufferedConn struct {
+    net.Conn
+    reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+    return c.reader.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+    if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+        return cw.CloseWrite()
+    }
+    return c.Conn.Close()
+}
\ No newline at end of file
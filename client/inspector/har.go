@@ -0,0 +1,408 @@
+package inspector
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "mime"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+// rotated archives kept next to the current one, traffic.1.har is the newest
+const harBackups = 5
+
+// the archive is kept valid after every entry by writing each one over
+// the trailer and putting the trailer back after it
+const (
+    harHeader  = `{"log":{"version":"1.2","creator":{"name":"mole","version":"0.0.1-beta"},"entries":[` + "\n"
+    harTrailer = "\n]}}\n"
+)
+
+var errHARClosed = errors.New("har file is closed")
+
+// HARWriter appends finished exchanges to an HTTP Archive (HAR 1.2) file
+// that browser devtools can load, starting a new file once it grows past
+// the size limit
+type HARWriter struct {
+    mutex    sync.Mutex
+    path     string
+    maxSize  int64
+    redactor *Redactor
+    file     *os.File
+    size     int64
+    entries  int
+}
+
+// NewHARWriter starts an archive at path, an existing one is rotated
+// away first. a zero maxSize never rotates.
+func NewHARWriter(path string, maxSize int64, redactor *Redactor) (*HARWriter, error) {
+    h := &HARWriter{path: path, maxSize: maxSize, redactor: redactor}
+    if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+        if err := h.rotate(); err != nil {
+            return nil, err
+        }
+    }
+    if err := h.open(); err != nil {
+        return nil, err
+    }
+    return h, nil
+}
+
+// Write adds an exchange to the archive, base is the public url its path
+// is relative to
+func (h *HARWriter) Write(base string, ex Exchange) error {
+    data, err := json.Marshal(h.entry(base, ex))
+    if err != nil {
+        return err
+    }
+    
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    
+    if h.file == nil {
+        return errHARClosed
+    }
+    if h.maxSize > 0 && h.entries > 0 && h.size+int64(len(data)) > h.maxSize {
+        if err := h.file.Close(); err != nil {
+            return err
+        }
+        h.file = nil
+        if err := h.rotate(); err != nil {
+            return err
+        }
+        if err := h.open(); err != nil {
+            return err
+        }
+    }
+    
+    chunk := make([]byte, 0, len(data)+len(harTrailer)+2)
+    if h.entries > 0 {
+        chunk = append(chunk, ",\n"...)
+    }
+    chunk = append(chunk, data...)
+    chunk = append(chunk, harTrailer...)
+    if _, err := h.file.WriteAt(chunk, h.size-int64(len(harTrailer))); err != nil {
+        return fmt.Errorf("failed to write %s: %v", h.path, err)
+    }
+    h.size += int64(len(chunk) - len(harTrailer))
+    h.entries++
+    return nil
+}
+
+// Close closes the archive, it stays valid as written
+func (h *HARWriter) Close() error {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    
+    if h.file == nil {
+        return nil
+    }
+    err := h.file.Close()
+    h.file = nil
+    return err
+}
+
+// open starts an empty archive
+func (h *HARWriter) open() error {
+    file, err := os.Create(h.path)
+    if err != nil {
+        return fmt.Errorf("failed to create %s: %v", h.path, err)
+    }
+    if _, err := file.WriteString(harHeader + harTrailer); err != nil {
+        file.Close()
+        return fmt.Errorf("failed to write %s: %v", h.path, err)
+    }
+    h.file = file
+    h.size = int64(len(harHeader) + len(harTrailer))
+    h.entries = 0
+    return nil
+}
+
+// rotate shifts traffic.har to traffic.1.har, traffic.1.har to
+// traffic.2.har and so on, dropping the oldest
+func (h *HARWriter) rotate() error {
+    os.Remove(backupName(h.path, harBackups))
+    for n := harBackups - 1; n >= 1; n-- {
+        os.Rename(backupName(h.path, n), backupName(h.path, n+1))
+    }
+    if err := os.Rename(h.path, backupName(h.path, 1)); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("failed to rotate %s: %v", h.path, err)
+    }
+    return nil
+}
+
+// backupName puts the number before the extension so rotated files still
+// open as archives
+func backupName(path string, n int) string {
+    ext := filepath.Ext(path)
+    return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// the parts of the HAR 1.2 format mole fills in, custom fields start with
+// an underscore
+type harEntry struct {
+    StartedDateTime string      `json:"startedDateTime"`
+    Time            float64     `json:"time"`
+    Request         harRequest  `json:"request"`
+    Response        harResponse `json:"response"`
+    Cache           struct{}    `json:"cache"`
+    Timings         harTimings  `json:"timings"`
+    Comment         string      `json:"comment,omitempty"`
+    Error           string      `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+    Method      string         `json:"method"`
+    URL         string         `json:"url"`
+    HTTPVersion string         `json:"httpVersion"`
+    Cookies     []harNameValue `json:"cookies"`
+    Headers     []harNameValue `json:"headers"`
+    QueryString []harNameValue `json:"queryString"`
+    PostData    *harPostData   `json:"postData,omitempty"`
+    HeadersSize int64          `json:"headersSize"`
+    BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+    Status      int            `json:"status"`
+    StatusText  string         `json:"statusText"`
+    HTTPVersion string         `json:"httpVersion"`
+    Cookies     []harNameValue `json:"cookies"`
+    Headers     []harNameValue `json:"headers"`
+    Content     harContent     `json:"content"`
+    RedirectURL string         `json:"redirectURL"`
+    HeadersSize int64          `json:"headersSize"`
+    BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+    MimeType string         `json:"mimeType"`
+    Params   []harNameValue `json:"params,omitempty"`
+    Text     string         `json:"text"`
+    Encoding string         `json:"_encoding,omitempty"`
+    Comment  string         `json:"comment,omitempty"`
+}
+
+type harContent struct {
+    Size        int64  `json:"size"`
+    Compression int64  `json:"compression,omitempty"`
+    MimeType    string `json:"mimeType"`
+    Text        string `json:"text,omitempty"`
+    Encoding    string `json:"encoding,omitempty"`
+    Comment     string `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+    Name  string `json:"name"`
+    Value string `json:"value"`
+}
+
+// send is part of wait since the request body streams to the local service
+// as it arrives, the phases mole cannot see are -1
+type harTimings struct {
+    Blocked float64 `json:"blocked"`
+    DNS     float64 `json:"dns"`
+    Connect float64 `json:"connect"`
+    SSL     float64 `json:"ssl"`
+    Send    float64 `json:"send"`
+    Wait    float64 `json:"wait"`
+    Receive float64 `json:"receive"`
+}
+
+func (h *HARWriter) entry(base string, ex Exchange) harEntry {
+    path, query, _ := strings.Cut(ex.URL, "?")
+    query = h.redactor.Query(query)
+    rawURL := strings.TrimSuffix(base, "/") + path
+    if query != "" {
+        rawURL += "?" + query
+    }
+    
+    wait := milliseconds(ex.Wait)
+    receive := milliseconds(ex.Duration - ex.Wait)
+    if ex.Status == 0 {
+        wait, receive = milliseconds(ex.Duration), 0
+    }
+    
+    entry := harEntry{
+        StartedDateTime: ex.Start.Format(time.RFC3339Nano),
+        Time:            wait + receive,
+        Request: harRequest{
+            Method:      ex.Method,
+            URL:         rawURL,
+            HTTPVersion: "HTTP/1.1",
+            Cookies:     h.cookies("Cookie", (&http.Request{Header: ex.RequestHeaders}).Cookies()),
+            Headers:     h.headers(ex.RequestHeaders),
+            QueryString: queryString(query),
+            HeadersSize: -1,
+            BodySize:    ex.RequestBody.Size,
+        },
+        Response: harResponse{
+            Status:      ex.Status,
+            StatusText:  http.StatusText(ex.Status),
+            HTTPVersion: "HTTP/1.1",
+            Cookies:     h.cookies("Set-Cookie", (&http.Response{Header: ex.ResponseHeaders}).Cookies()),
+            Headers:     h.headers(ex.ResponseHeaders),
+            Content:     h.content(ex.ResponseHeaders, ex.ResponseBody),
+            RedirectURL: ex.ResponseHeaders.Get("Location"),
+            HeadersSize: -1,
+            BodySize:    ex.ResponseBody.Size,
+        },
+        Timings: harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: wait, Receive: receive},
+        Error:   ex.Error,
+    }
+    if ex.ReplayOf != "" {
+        entry.Comment = "replay of " + ex.ReplayOf
+    }
+    if ex.RequestBody.Size > 0 {
+        entry.Request.PostData = h.postData(ex.RequestHeaders, ex.RequestBody)
+    }
+    return entry
+}
+
+// postData keeps the request body as text, or base64 when it is binary
+func (h *HARWriter) postData(headers http.Header, body Body) *harPostData {
+    postData := &harPostData{MimeType: headers.Get("Content-Type")}
+    mediaType, _, _ := mime.ParseMediaType(postData.MimeType)
+    whole := !body.Truncated && !encoded(headers)
+    text, ok := h.redactor.Body(mediaType, body.Data, whole)
+    switch {
+    case !ok:
+    case isText(mediaType, body.Data):
+        postData.Text = text
+        if mediaType == "application/x-www-form-urlencoded" {
+            postData.Params = queryString(postData.Text)
+        }
+    default:
+        postData.Text = base64.StdEncoding.EncodeToString(body.Data)
+        postData.Encoding = "base64"
+    }
+    if body.Truncated {
+        postData.Comment = fmt.Sprintf("truncated to %d of %d bytes", len(body.Data), body.Size)
+    }
+    if !ok {
+        postData.Comment = leftOut
+    }
+    return postData
+}
+
+// content keeps the response body decoded, as text or base64 when it is
+// binary
+func (h *HARWriter) content(headers http.Header, body Body) harContent {
+    content := harContent{MimeType: headers.Get("Content-Type"), Size: body.Size}
+    data := body.Data
+    whole := !body.Truncated && !encoded(headers)
+    if strings.EqualFold(headers.Get("Content-Encoding"), "gzip") {
+        if decoded, err := gunzip(data); err == nil {
+            data = decoded
+            if !body.Truncated {
+                content.Size = int64(len(decoded))
+                content.Compression = content.Size - body.Size
+                whole = true
+            }
+        }
+    }
+    
+    mediaType, _, _ := mime.ParseMediaType(content.MimeType)
+    text, ok := h.redactor.Body(mediaType, data, whole)
+    switch {
+    case !ok:
+    case isText(mediaType, data):
+        content.Text = text
+    default:
+        content.Text = base64.StdEncoding.EncodeToString(data)
+        content.Encoding = "base64"
+    }
+    if body.Truncated {
+        content.Comment = fmt.Sprintf("truncated to %d of %d bytes", len(body.Data), body.Size)
+    }
+    if !ok {
+        content.Comment = leftOut
+    }
+    return content
+}
+
+// the comment on a body the redaction rules could not be applied to
+const leftOut = "left out, the redaction rules could not be applied to it"
+
+// encoded reports whether a body is still in a content encoding such as
+// gzip or br
+func encoded(headers http.Header) bool {
+    encoding := headers.Get("Content-Encoding")
+    return encoding != "" && !strings.EqualFold(encoding, "identity")
+}
+
+// isText tells whether a body can be kept as text, media types that are
+// binary by nature never are
+func isText(mediaType string, data []byte) bool {
+    return !binaryType(mediaType) && utf8.Valid(data)
+}
+
+func binaryType(mediaType string) bool {
+    return mediaType == "application/octet-stream" ||
+        strings.HasPrefix(mediaType, "image/") ||
+        strings.HasPrefix(mediaType, "audio/") ||
+        strings.HasPrefix(mediaType, "video/")
+}
+
+// headers lists headers sorted by name with the redaction rules applied
+func (h *HARWriter) headers(headers http.Header) []harNameValue {
+    keys := make([]string, 0, len(headers))
+    for key := range headers {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    
+    list := make([]harNameValue, 0, len(headers))
+    for _, key := range keys {
+        for _, value := range headers[key] {
+            value = h.redactor.Header(key, value)
+            if key == "Cookie" || key == "Set-Cookie" {
+                value = h.redactor.Cookies(key, value)
+            }
+            list = append(list, harNameValue{Name: key, Value: value})
+        }
+    }
+    return list
+}
+
+// cookies lists the cookies parsed from header, hidden when the header is
+// redacted or a redacted field has the same name
+func (h *HARWriter) cookies(header string, cookies []*http.Cookie) []harNameValue {
+    list := make([]harNameValue, 0, len(cookies))
+    for _, cookie := range cookies {
+        value := h.redactor.Header(header, cookie.Value)
+        if h.redactor.fields[strings.ToLower(cookie.Name)] {
+            value = redacted
+        }
+        list = append(list, harNameValue{Name: cookie.Name, Value: value})
+    }
+    return list
+}
+
+// queryString lists the parameters of a query in the order they appear
+func queryString(query string) []harNameValue {
+    list := []harNameValue{}
+    for _, pair := range strings.Split(query, "&") {
+        if pair == "" {
+            continue
+        }
+        name, value, _ := strings.Cut(pair, "=")
+        if unescaped, err := url.QueryUnescape(name); err == nil {
+            name = unescaped
+        }
+        if unescaped, err := url.QueryUnescape(value); err == nil {
+            value = unescaped
+        }
+        list = append(list, harNameValue{Name: name, Value: value})
+    }
+    return list
+}
\ No newline at end of file
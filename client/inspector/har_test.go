@@ -0,0 +1,270 @@
+package inspector
+
+import (
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+type harFile struct {
+    Log struct {
+        Version string     `json:"version"`
+        Entries []harEntry `json:"entries"`
+    } `json:"log"`
+}
+
+// readHAR parses an archive, failing the test when it is not valid json
+func readHAR(t *testing.T, path string) ([]harEntry, []byte) {
+    t.Helper()
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var har harFile
+    if err := json.Unmarshal(data, &har); err != nil {
+        t.Fatalf("%s is not a valid archive: %v\n%s", path, err, data)
+    }
+    if har.Log.Version != "1.2" {
+        t.Fatalf("%s has version %q", path, har.Log.Version)
+    }
+    return har.Log.Entries, data
+}
+
+func newHARWriter(t *testing.T, path string, maxSize int64, rules ...string) *HARWriter {
+    t.Helper()
+    redactor, err := NewRedactor(rules)
+    if err != nil {
+        t.Fatal(err)
+    }
+    h, err := NewHARWriter(path, maxSize, redactor)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { h.Close() })
+    return h
+}
+
+func exchange(n int) Exchange {
+    return Exchange{
+        ID:              fmt.Sprint(n),
+        Start:           time.Now(),
+        Method:          http.MethodGet,
+        URL:             fmt.Sprintf("/items/%d?page=%d", n, n),
+        RequestHeaders:  http.Header{"Accept": {"*/*"}},
+        Status:          http.StatusOK,
+        ResponseHeaders: http.Header{"Content-Type": {"text/plain"}},
+        ResponseBody:    Body{Data: []byte("hello"), Size: 5},
+        Wait:            time.Millisecond,
+        Duration:        2 * time.Millisecond,
+        Done:            true,
+    }
+}
+
+func TestHARValidAfterEveryWrite(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "traffic.har")
+    h := newHARWriter(t, path, 0)
+    
+    if entries, _ := readHAR(t, path); len(entries) != 0 {
+        t.Fatalf("new archive has %d entries", len(entries))
+    }
+    for i := 1; i <= 5; i++ {
+        if err := h.Write("https://app.example.com/", exchange(i)); err != nil {
+            t.Fatal(err)
+        }
+        // readable while still being written, as after a crash
+        entries, _ := readHAR(t, path)
+        if len(entries) != i {
+            t.Fatalf("archive has %d entries after %d writes", len(entries), i)
+        }
+        if got, want := entries[i-1].Request.URL, fmt.Sprintf("https://app.example.com/items/%d?page=%d", i, i); got != want {
+            t.Fatalf("entry %d has url %q, want %q", i, got, want)
+        }
+    }
+    
+    if err := h.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if entries, _ := readHAR(t, path); len(entries) != 5 {
+        t.Fatalf("closed archive has %d entries", len(entries))
+    }
+    if err := h.Write("https://app.example.com", exchange(6)); err != errHARClosed {
+        t.Fatalf("write after close returned %v", err)
+    }
+}
+
+func TestHARRotation(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "traffic.har")
+    
+    // an archive from an earlier run is kept as the newest backup
+    if err := os.WriteFile(path, []byte("earlier"), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    h := newHARWriter(t, path, 1)
+    if data, _ := os.ReadFile(backupName(path, 1)); string(data) != "earlier" {
+        t.Fatalf("earlier archive rotated to %q", data)
+    }
+    
+    // every entry passes the limit, so each one starts a new file. an entry
+    // larger than the limit is still written to an empty one.
+    const writes = harBackups + 3
+    for i := 1; i <= writes; i++ {
+        if err := h.Write("http://localhost", exchange(i)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    h.Close()
+    
+    for n := 0; n <= harBackups; n++ {
+        name := path
+        if n > 0 {
+            name = backupName(path, n)
+        }
+        entries, _ := readHAR(t, name)
+        if len(entries) != 1 || entries[0].Request.URL != fmt.Sprintf("http://localhost/items/%d?page=%d", writes-n, writes-n) {
+            t.Fatalf("%s holds %+v", filepath.Base(name), entries)
+        }
+    }
+    if _, err := os.Stat(backupName(path, harBackups+1)); !os.IsNotExist(err) {
+        t.Fatalf("kept more than %d backups", harBackups)
+    }
+}
+
+func TestBackupName(t *testing.T) {
+    tests := []struct {
+        path string
+        n    int
+        want string
+    }{
+        {"traffic.har", 1, "traffic.1.har"},
+        {"traffic.har", 2, "traffic.2.har"},
+        {"/var/log/mole.traffic.har", 3, "/var/log/mole.traffic.3.har"},
+        {"traffic", 1, "traffic.1"},
+        {"logs.d/traffic", 1, "logs.d/traffic.1"},
+    }
+    for _, tt := range tests {
+        if got := backupName(tt.path, tt.n); got != tt.want {
+            t.Errorf("backupName(%q, %d) = %q, want %q", tt.path, tt.n, got, tt.want)
+        }
+    }
+}
+
+func gzipped(t *testing.T, data string) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    zw := gzip.NewWriter(&buf)
+    zw.Write([]byte(data))
+    if err := zw.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func TestHARRedaction(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "traffic.har")
+    h := newHARWriter(t, path, 0, "header:Authorization", "field:password", "field:session", `regex:sk_live_\w+`)
+    
+    secrets := []string{"bearer-secret", "cookie-secret", "query-secret", "json-secret", "nested-secret", "form-secret", "set-cookie-secret", "sk_live_key1", "sk_live_key2", "truncated-secret", "gzip-secret"}
+    
+    json1 := Exchange{
+        Method: http.MethodPost,
+        URL:    "/login?user=ann&password=query-secret",
+        RequestHeaders: http.Header{
+            "Authorization": {"Bearer bearer-secret"},
+            "Cookie":        {"session=cookie-secret; theme=dark"},
+            "Content-Type":  {"application/json"},
+        },
+        RequestBody: Body{Data: []byte(`{"user":"ann","password":"json-secret","devices":[{"password":"nested-secret"}]}`), Size: 80},
+        Status:      http.StatusOK,
+        ResponseHeaders: http.Header{
+            "Set-Cookie":   {"session=set-cookie-secret; Path=/"},
+            "Content-Type": {"text/plain"},
+        },
+        ResponseBody: Body{Data: []byte("your key is sk_live_key1"), Size: 24},
+    }
+    form := Exchange{
+        Method:          http.MethodPost,
+        URL:             "/signup",
+        RequestHeaders:  http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+        RequestBody:     Body{Data: []byte("user=ann&password=form-secret"), Size: 29},
+        Status:          http.StatusOK,
+        ResponseHeaders: http.Header{"Content-Type": {"application/json"}},
+        ResponseBody:    Body{Data: []byte(`{"key":"sk_live_key2"}`), Size: 22},
+    }
+    // bodies the rules cannot be read through are left out entirely
+    leftOutBodies := Exchange{
+        Method:          http.MethodPost,
+        URL:             "/upload",
+        RequestHeaders:  http.Header{"Content-Type": {"application/json"}},
+        RequestBody:     Body{Data: []byte(`{"password":"truncated-secret","more":`), Size: 4096, Truncated: true},
+        Status:          http.StatusOK,
+        ResponseHeaders: http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"br"}},
+        ResponseBody:    Body{Data: []byte(`{"password":"gzip-secret"}`), Size: 26},
+    }
+    // a gzip response the archive decodes is redacted like a plain one
+    compressed := gzipped(t, `{"password":"gzip-secret","user":"ann"}`)
+    decoded := Exchange{
+        Method:          http.MethodGet,
+        URL:             "/me",
+        Status:          http.StatusOK,
+        ResponseHeaders: http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}},
+        ResponseBody:    Body{Data: compressed, Size: int64(len(compressed))},
+    }
+    
+    for _, ex := range []Exchange{json1, form, leftOutBodies, decoded} {
+        if err := h.Write("https://app.example.com", ex); err != nil {
+            t.Fatal(err)
+        }
+    }
+    entries, data := readHAR(t, path)
+    for _, secret := range secrets {
+        if bytes.Contains(data, []byte(secret)) {
+            t.Errorf("archive contains %q", secret)
+        }
+    }
+    
+    login := entries[0]
+    if login.Request.URL != "https://app.example.com/login?password=%5BREDACTED%5D&user=ann" {
+        t.Errorf("login url %q", login.Request.URL)
+    }
+    if got := login.Request.QueryString; len(got) != 2 || got[0] != (harNameValue{"password", redacted}) || got[1] != (harNameValue{"user", "ann"}) {
+        t.Errorf("login query %+v", got)
+    }
+    if got := login.Request.Cookies; len(got) != 2 || got[0] != (harNameValue{"session", redacted}) || got[1] != (harNameValue{"theme", "dark"}) {
+        t.Errorf("login cookies %+v", got)
+    }
+    if got := login.Response.Cookies; len(got) != 1 || got[0] != (harNameValue{"session", redacted}) {
+        t.Errorf("login response cookies %+v", got)
+    }
+    var body map[string]interface{}
+    if err := json.Unmarshal([]byte(login.Request.PostData.Text), &body); err != nil || body["user"] != "ann" || body["password"] != redacted {
+        t.Errorf("login body %q", login.Request.PostData.Text)
+    }
+    if login.Response.Content.Text != "your key is "+redacted {
+        t.Errorf("login response %q", login.Response.Content.Text)
+    }
+    
+    signup := entries[1]
+    if got := signup.Request.PostData.Params; len(got) != 2 || got[0] != (harNameValue{"password", redacted}) || got[1] != (harNameValue{"user", "ann"}) {
+        t.Errorf("signup form %+v", got)
+    }
+    
+    upload := entries[2]
+    if upload.Request.PostData.Text != "" || upload.Request.PostData.Comment != leftOut {
+        t.Errorf("truncated request body kept as %+v", upload.Request.PostData)
+    }
+    if upload.Response.Content.Text != "" || upload.Response.Content.Comment != leftOut {
+        t.Errorf("encoded response body kept as %+v", upload.Response.Content)
+    }
+    
+    me := entries[3]
+    if me.Response.Content.Text != `{"password":"[REDACTED]","user":"ann"}` || me.Response.Content.Comment != "" {
+        t.Errorf("gzip response kept as %+v", me.Response.Content)
+    }
+}
\ No newline at end of file
@@ -0,0 +1,294 @@
+package inspector
+
+import (
+    "io"
+    "net/http"
+    "sync"
+    "time"
+    
+    "mole/client/forwarder"
+)
+
+// bodies are captured up to this size, the rest is only counted
+const maxBodySize = 1 << 20
+
+// one request and its response as forwarded to the local service
+type Exchange struct {
+    ID              string
+    Start           time.Time
+    Method          string
+    URL             string
+    RequestHeaders  http.Header
+    RequestBody     Body
+    Status          int
+    ResponseHeaders http.Header
+    ResponseBody    Body
+    Error           string
+    
+    // the exchange this one replayed, empty for requests from the tunnel
+    ReplayOf string
+    
+    // Wait is the time until the response headers arrived, Duration until
+    // the response body was sent. Done is set once the exchange is over.
+    Wait     time.Duration
+    Duration time.Duration
+    Done     bool
+}
+
+// a captured body, Data holds at most maxBodySize of Size bytes
+type Body struct {
+    Data      []byte
+    Size      int64
+    Truncated bool
+}
+
+// Inspector keeps the latest exchanges in a ring buffer and shows them on
+// a local web page that updates as requests come in
+type Inspector struct {
+    mutex       sync.Mutex
+    ring        []*Exchange
+    next        int
+    count       int
+    size        int64
+    maxSize     int64
+    byID        map[string]*Exchange
+    subscribers map[chan string]struct{}
+    forwarder   *forwarder.Forwarder
+    finished    []func(Exchange)
+}
+
+// New creates an inspector that remembers the last capacity exchanges, as
+// long as their captured bodies add up to no more than maxSize bytes
+func New(capacity int, maxSize int64) *Inspector {
+    return &Inspector{
+        ring:        make([]*Exchange, capacity),
+        maxSize:     maxSize,
+        byID:        make(map[string]*Exchange),
+        subscribers: make(map[chan string]struct{}),
+    }
+}
+
+// Record starts recording a new exchange. the recording is filled in as the request
+// is forwarded, a nil inspector returns a nil recording that ignores
+// every call.
+func (i *Inspector) Record(id, method, url string, headers http.Header) *Recording {
+    if i == nil {
+        return nil
+    }
+    return i.record(&Exchange{
+        ID:             id,
+        Start:          time.Now(),
+        Method:         method,
+        URL:            url,
+        RequestHeaders: headers.Clone(),
+    })
+}
+
+// record adds an exchange to the ring buffer, dropping the oldest when full
+func (i *Inspector) record(ex *Exchange) *Recording {
+    id := ex.ID
+    i.mutex.Lock()
+    if i.count == len(i.ring) {
+        i.dropOldest()
+    }
+    i.ring[i.next] = ex
+    i.byID[id] = ex
+    i.next = (i.next + 1) % len(i.ring)
+    i.count++
+    i.mutex.Unlock()
+    
+    i.notify(id)
+    return &Recording{inspector: i, exchange: ex}
+}
+
+// dropOldest forgets the oldest exchange, the caller holds the mutex
+func (i *Inspector) dropOldest() {
+    oldest := (i.next - i.count + len(i.ring)) % len(i.ring)
+    ex := i.ring[oldest]
+    i.ring[oldest] = nil
+    i.count--
+    delete(i.byID, ex.ID)
+    i.size -= int64(len(ex.RequestBody.Data) + len(ex.ResponseBody.Data))
+}
+
+// grow accounts for n more captured bytes of ex and drops the oldest
+// exchanges until the bodies fit in maxSize again. it reports false once
+// ex itself was dropped, its body is no longer kept then. the caller holds
+// the mutex.
+func (i *Inspector) grow(ex *Exchange, n int) bool {
+    if i.byID[ex.ID] != ex {
+        return false
+    }
+    i.size += int64(n)
+    for i.size > i.maxSize && i.count > 1 {
+        i.dropOldest()
+    }
+    if i.byID[ex.ID] != ex {
+        // dropped along the way, the bytes are never kept
+        i.size -= int64(n)
+        return false
+    }
+    return true
+}
+
+// Exchanges returns copies of the recorded exchanges, newest first
+func (i *Inspector) Exchanges() []Exchange {
+    i.mutex.Lock()
+    defer i.mutex.Unlock()
+    
+    exchanges := make([]Exchange, 0, i.count)
+    for n := 1; n <= i.count; n++ {
+        ex := i.ring[(i.next-n+len(i.ring))%len(i.ring)]
+        exchanges = append(exchanges, *ex)
+    }
+    return exchanges
+}
+
+// Exchange returns a copy of the exchange with the given id
+func (i *Inspector) Exchange(id string) (Exchange, bool) {
+    i.mutex.Lock()
+    defer i.mutex.Unlock()
+    
+    ex, ok := i.byID[id]
+    if !ok {
+        return Exchange{}, false
+    }
+    return *ex, true
+}
+
+// OnFinish calls fn with every exchange once it is over, call before
+// requests come in
+func (i *Inspector) OnFinish(fn func(Exchange)) {
+    i.mutex.Lock()
+    i.finished = append(i.finished, fn)
+    i.mutex.Unlock()
+}
+
+// subscribe returns a channel that gets the id of every exchange that changes
+func (i *Inspector) subscribe() chan string {
+    ch := make(chan string, 64)
+    i.mutex.Lock()
+    i.subscribers[ch] = struct{}{}
+    i.mutex.Unlock()
+    return ch
+}
+
+func (i *Inspector) unsubscribe(ch chan string) {
+    i.mutex.Lock()
+    delete(i.subscribers, ch)
+    i.mutex.Unlock()
+}
+
+// notify tells subscribers an exchange changed, skipping those that fall
+// behind rather than slowing down the tunnel
+func (i *Inspector) notify(id string) {
+    i.mutex.Lock()
+    defer i.mutex.Unlock()
+    
+    for ch := range i.subscribers {
+        select {
+        case ch <- id:
+        default:
+        }
+    }
+}
+
+// Recording fills in one exchange while it is forwarded. all methods are
+// no-ops on a nil recording.
+type Recording struct {
+    inspector *Inspector
+    exchange  *Exchange
+}
+
+// RequestBody returns a reader that captures body as it is forwarded
+func (r *Recording) RequestBody(body io.Reader) io.Reader {
+    if r == nil {
+        return body
+    }
+    return &captureReader{src: body, recording: r, body: &r.exchange.RequestBody}
+}
+
+// Response records the response headers and returns a body that captures
+// what is sent back through the tunnel
+func (r *Recording) Response(status int, headers http.Header, body io.ReadCloser) io.ReadCloser {
+    if r == nil {
+        return body
+    }
+    
+    r.inspector.mutex.Lock()
+    r.exchange.Status = status
+    r.exchange.ResponseHeaders = headers.Clone()
+    r.exchange.Wait = time.Since(r.exchange.Start)
+    r.inspector.mutex.Unlock()
+    
+    r.inspector.notify(r.exchange.ID)
+    return &captureReader{src: body, closer: body, recording: r, body: &r.exchange.ResponseBody}
+}
+
+// Finish marks the exchange as over, err is why it failed if it did
+func (r *Recording) Finish(err error) {
+    if r == nil {
+        return
+    }
+    
+    r.inspector.mutex.Lock()
+    r.exchange.Duration = time.Since(r.exchange.Start)
+    r.exchange.Done = true
+    if err != nil && r.exchange.Error == "" {
+        r.exchange.Error = err.Error()
+    }
+    ex := *r.exchange
+    finished := r.inspector.finished
+    r.inspector.mutex.Unlock()
+    
+    r.inspector.notify(r.exchange.ID)
+    for _, fn := range finished {
+        fn(ex)
+    }
+}
+
+// Fail records why the request could not be forwarded
+func (r *Recording) Fail(err error) {
+    if r == nil {
+        return
+    }
+    
+    r.inspector.mutex.Lock()
+    r.exchange.Error = err.Error()
+    r.inspector.mutex.Unlock()
+}
+
+// captureReader copies what is read into a body, up to maxBodySize
+type captureReader struct {
+    src       io.Reader
+    closer    io.Closer
+    recording *Recording
+    body      *Body
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+    n, err := c.src.Read(p)
+    if n > 0 {
+        inspector := c.recording.inspector
+        inspector.mutex.Lock()
+        c.body.Size += int64(n)
+        if room := maxBodySize - len(c.body.Data); room > 0 {
+            if room > n {
+                room = n
+            }
+            if inspector.grow(c.recording.exchange, room) {
+                c.body.Data = append(c.body.Data, p[:room]...)
+            }
+        }
+        c.body.Truncated = c.body.Size > int64(len(c.body.Data))
+        inspector.mutex.Unlock()
+    }
+    return n, err
+}
+
+func (c *captureReader) Close() error {
+    if c.closer == nil {
+        return nil
+    }
+    return c.closer.Close()
+}
\ No newline at end of file
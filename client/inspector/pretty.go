@@ -0,0 +1,87 @@
+package inspector
+
+import (
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "unicode/utf8"
+)
+
+// body formats shown by the inspector page
+const (
+    formatJSON   = "json"
+    formatForm   = "form"
+    formatText   = "text"
+    formatBinary = "binary"
+    formatEmpty  = "empty"
+)
+
+// prettyBody renders a body for display based on its headers. json is
+// indented, forms are listed one field per line, gzip is undone first.
+func prettyBody(headers http.Header, data []byte) (string, string) {
+    if len(data) == 0 {
+        return formatEmpty, ""
+    }
+    
+    if strings.EqualFold(headers.Get("Content-Encoding"), "gzip") {
+        if decoded, err := gunzip(data); err == nil {
+            data = decoded
+        }
+    }
+    
+    mediaType, _, _ := mime.ParseMediaType(headers.Get("Content-Type"))
+    switch {
+    case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+        var out bytes.Buffer
+        if json.Indent(&out, data, "", "  ") == nil {
+            return formatJSON, out.String()
+        }
+    
+    case mediaType == "application/x-www-form-urlencoded":
+        if values, err := url.ParseQuery(string(data)); err == nil {
+            return formatForm, formatValues(values)
+        }
+    }
+    
+    if utf8.Valid(data) {
+        return formatText, string(data)
+    }
+    return formatBinary, fmt.Sprintf("%d bytes of binary data", len(data))
+}
+
+// formatValues lists form fields sorted by name, one value per line
+func formatValues(values url.Values) string {
+    keys := make([]string, 0, len(values))
+    for key := range values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    
+    var b strings.Builder
+    for _, key := range keys {
+        for _, value := range values[key] {
+            fmt.Fprintf(&b, "%s = %s\n", key, value)
+        }
+    }
+    return b.String()
+}
+
+// gunzip decodes a gzip body, a truncated capture yields what it can
+func gunzip(data []byte) ([]byte, error) {
+    r, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    decoded, err := io.ReadAll(io.LimitReader(r, maxBodySize))
+    if len(decoded) == 0 && err != nil {
+        return nil, err
+    }
+    return decoded, nil
+}
\ No newline at end of file
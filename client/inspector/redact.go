@@ -0,0 +1,185 @@
+package inspector
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strings"
+    "unicode/utf8"
+)
+
+// what redacted values are replaced with
+const redacted = "[REDACTED]"
+
+// Redactor blanks out secrets in exchanges before they are written to disk.
+// rules are "header:<name>" for a request or response header,
+// "field:<name>" for a json key at any depth, a form field or a query
+// parameter, and "regex:<expr>" for anything matching in a text body.
+type Redactor struct {
+    headers  map[string]bool
+    fields   map[string]bool
+    patterns []*regexp.Regexp
+}
+
+// NewRedactor parses redaction rules, none redacts nothing
+func NewRedactor(rules []string) (*Redactor, error) {
+    r := &Redactor{
+        headers: make(map[string]bool),
+        fields:  make(map[string]bool),
+    }
+    for _, rule := range rules {
+        kind, value, _ := strings.Cut(rule, ":")
+        if value == "" {
+            return nil, fmt.Errorf("invalid redaction rule %q, expected header:, field: or regex:", rule)
+        }
+        switch kind {
+        case "header":
+            r.headers[http.CanonicalHeaderKey(value)] = true
+        case "field":
+            r.fields[strings.ToLower(value)] = true
+        case "regex":
+            pattern, err := regexp.Compile(value)
+            if err != nil {
+                return nil, fmt.Errorf("invalid redaction rule %q: %v", rule, err)
+            }
+            r.patterns = append(r.patterns, pattern)
+        default:
+            return nil, fmt.Errorf("invalid redaction rule %q, expected header:, field: or regex:", rule)
+        }
+    }
+    return r, nil
+}
+
+// Header returns the value to keep for a header
+func (r *Redactor) Header(name, value string) string {
+    if r.headers[http.CanonicalHeaderKey(name)] {
+        return redacted
+    }
+    return value
+}
+
+// Query redacts the parameters of a raw query string
+func (r *Redactor) Query(query string) string {
+    if len(r.fields) == 0 || query == "" {
+        return query
+    }
+    // pairs that do not parse are dropped rather than kept as they came
+    values, err := url.ParseQuery(query)
+    if !r.redactValues(values) && err == nil {
+        return query
+    }
+    return values.Encode()
+}
+
+// Cookies redacts the cookies named by a field in a Cookie or Set-Cookie
+// header value, the attributes of a Set-Cookie are kept
+func (r *Redactor) Cookies(name, value string) string {
+    if len(r.fields) == 0 {
+        return value
+    }
+    pairs := strings.Split(value, ";")
+    n := len(pairs)
+    if http.CanonicalHeaderKey(name) == "Set-Cookie" {
+        n = 1
+    }
+    for i := 0; i < n; i++ {
+        key, _, ok := strings.Cut(pairs[i], "=")
+        if ok && r.fields[strings.ToLower(strings.TrimSpace(key))] {
+            pairs[i] = key + "=" + redacted
+        }
+    }
+    return strings.Join(pairs, ";")
+}
+
+// Body redacts a body of the given media type. fields are only looked for
+// in json and forms, patterns in any text. whole is false for a body that
+// was cut off or is still compressed. ok is false when rules apply to the
+// body but cannot be applied, it has to be left out rather than kept
+// unredacted then.
+func (r *Redactor) Body(mediaType string, data []byte, whole bool) (text string, ok bool) {
+    form := mediaType == "application/x-www-form-urlencoded"
+    isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+    fields := len(r.fields) > 0 && (form || isJSON)
+    patterns := len(r.patterns) > 0 && !binaryType(mediaType)
+    if !fields && !patterns || len(data) == 0 {
+        return string(data), true
+    }
+    if !whole || !utf8.Valid(data) {
+        return "", false
+    }
+    
+    text = string(data)
+    if fields && isJSON {
+        if text, ok = r.redactJSON(text); !ok {
+            return "", false
+        }
+    } else if fields {
+        text = r.Query(text)
+    }
+    for _, pattern := range r.patterns {
+        text = pattern.ReplaceAllString(text, redacted)
+    }
+    return text, true
+}
+
+// redactJSON rewrites a json document with the matching keys blanked out,
+// one with nothing to redact is kept as is. ok is false when it does not
+// parse.
+func (r *Redactor) redactJSON(text string) (string, bool) {
+    decoder := json.NewDecoder(strings.NewReader(text))
+    decoder.UseNumber()
+    var doc interface{}
+    if decoder.Decode(&doc) != nil || decoder.More() {
+        return "", false
+    }
+    if !r.redactValue(doc) {
+        return text, true
+    }
+    
+    var out bytes.Buffer
+    encoder := json.NewEncoder(&out)
+    encoder.SetEscapeHTML(false)
+    if encoder.Encode(doc) != nil {
+        return "", false
+    }
+    return strings.TrimSuffix(out.String(), "\n"), true
+}
+
+func (r *Redactor) redactValue(v interface{}) bool {
+    changed := false
+    switch v := v.(type) {
+    case map[string]interface{}:
+        for key, value := range v {
+            if r.fields[strings.ToLower(key)] {
+                v[key] = redacted
+                changed = true
+            } else if r.redactValue(value) {
+                changed = true
+            }
+        }
+    case []interface{}:
+        for _, value := range v {
+            if r.redactValue(value) {
+                changed = true
+            }
+        }
+    }
+    return changed
+}
+
+func (r *Redactor) redactValues(values url.Values) bool {
+    changed := false
+    for key, list := range values {
+        if !r.fields[strings.ToLower(key)] {
+            continue
+        }
+        for n := range list {
+            list[n] = redacted
+        }
+        changed = true
+    }
+    return changed
+}
\ No newline at end of file
@@ -0,0 +1,131 @@
+package inspector
+
+import (
+    "testing"
+)
+
+func TestNewRedactorRejectsInvalidRules(t *testing.T) {
+    for _, rule := range []string{"header:", "field", "bogus:x", "regex:(", "Header:x"} {
+        if _, err := NewRedactor([]string{rule}); err == nil {
+            t.Errorf("rule %q accepted", rule)
+        }
+    }
+}
+
+func TestRedactorHeader(t *testing.T) {
+    r, err := NewRedactor([]string{"header:authorization", "header:X-Api-Key"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name, value, want string
+    }{
+        {"Authorization", "Bearer secret", redacted},
+        {"AUTHORIZATION", "Bearer secret", redacted},
+        {"x-api-key", "k", redacted},
+        {"Accept", "text/html", "text/html"},
+    }
+    for _, tt := range tests {
+        if got := r.Header(tt.name, tt.value); got != tt.want {
+            t.Errorf("Header(%q) = %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestRedactorQuery(t *testing.T) {
+    r, err := NewRedactor([]string{"field:password", "field:Token"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        query, want string
+    }{
+        {"", ""},
+        // nothing to redact keeps the query as it came
+        {"b=2&a=1", "b=2&a=1"},
+        {"user=a&password=x", "password=%5BREDACTED%5D&user=a"},
+        {"TOKEN=1&token=2", "TOKEN=%5BREDACTED%5D&token=%5BREDACTED%5D"},
+        // pairs that do not parse are dropped rather than kept as they came
+        {"a=%zz&password=x", "password=%5BREDACTED%5D"},
+        {"a=%zz&b=1", "b=1"},
+    }
+    for _, tt := range tests {
+        if got := r.Query(tt.query); got != tt.want {
+            t.Errorf("Query(%q) = %q, want %q", tt.query, got, tt.want)
+        }
+    }
+    
+    none, _ := NewRedactor(nil)
+    if got := none.Query("password=x&a=%zz"); got != "password=x&a=%zz" {
+        t.Errorf("query changed without rules: %q", got)
+    }
+}
+
+func TestRedactorCookies(t *testing.T) {
+    r, err := NewRedactor([]string{"field:session"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        header, value, want string
+    }{
+        {"Cookie", "session=s1; theme=dark", "session=[REDACTED]; theme=dark"},
+        {"Cookie", "theme=dark; Session=s1", "theme=dark; Session=[REDACTED]"},
+        {"Cookie", "theme=dark", "theme=dark"},
+        {"Set-Cookie", "session=s1; Path=/; HttpOnly", "session=[REDACTED]; Path=/; HttpOnly"},
+        // only the cookie itself is named, not its attributes
+        {"set-cookie", "id=1; session=x", "id=1; session=x"},
+    }
+    for _, tt := range tests {
+        if got := r.Cookies(tt.header, tt.value); got != tt.want {
+            t.Errorf("Cookies(%q, %q) = %q, want %q", tt.header, tt.value, got, tt.want)
+        }
+    }
+}
+
+func TestRedactorBody(t *testing.T) {
+    r, err := NewRedactor([]string{"field:password", "regex:sk_live_[a-z0-9]+"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    tests := []struct {
+        name      string
+        mediaType string
+        data      string
+        whole     bool
+        want      string
+        ok        bool
+    }{
+        {"json field", "application/json", `{"user":"a","Password":"p"}`, true, `{"Password":"[REDACTED]","user":"a"}`, true},
+        {"nested json", "application/json", `{"list":[{"password":"p"}],"n":1.50}`, true, `{"list":[{"password":"[REDACTED]"}],"n":1.50}`, true},
+        {"json suffix", "application/vnd.api+json", `{"password":"p"}`, true, `{"password":"[REDACTED]"}`, true},
+        {"json kept as is", "application/json", "{ \"user\": \"<a>\" }", true, "{ \"user\": \"<a>\" }", true},
+        {"html in redacted json", "application/json", `{"password":"p","a":"<b>"}`, true, `{"a":"<b>","password":"[REDACTED]"}`, true},
+        {"json and pattern", "application/json", `{"key":"sk_live_abc1"}`, true, `{"key":"[REDACTED]"}`, true},
+        {"form", "application/x-www-form-urlencoded", "user=a&password=p", true, "password=%5BREDACTED%5D&user=a", true},
+        {"pattern in text", "text/plain", "key sk_live_abc1 here", true, "key [REDACTED] here", true},
+        {"fields not looked for in text", "text/plain", "password=p", true, "password=p", true},
+        {"empty", "application/json", "", false, "", true},
+        {"binary", "image/png", "sk_live_abc1", false, "sk_live_abc1", true},
+        
+        // bodies the rules cannot be applied to are left out
+        {"invalid json", "application/json", `{"password":`, true, "", false},
+        {"two json documents", "application/json", `{"a":1}{"password":"p"}`, true, "", false},
+        {"truncated json", "application/json", `{"password":"p"}`, false, "", false},
+        {"truncated text", "text/plain", "sk_live_abc1", false, "", false},
+        {"invalid utf-8", "text/plain", "\xff sk_live_abc1", true, "", false},
+    }
+    for _, tt := range tests {
+        got, ok := r.Body(tt.mediaType, []byte(tt.data), tt.whole)
+        if got != tt.want || ok != tt.ok {
+            t.Errorf("%s: Body = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+        }
+    }
+    
+    // without rules every body is kept, whole or not
+    none, _ := NewRedactor(nil)
+    if got, ok := none.Body("application/json", []byte(`{"password":`), false); !ok || got != `{"password":` {
+        t.Errorf("body changed without rules: %q, %v", got, ok)
+    }
+}
\ No newline at end of file
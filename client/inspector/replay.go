@@ -0,0 +1,110 @@
+package inspector
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "time"
+    
+    "mole/client/forwarder"
+)
+
+var (
+    errNotFound       = errors.New("no such request")
+    errReplayDisabled = errors.New("replay is not enabled")
+)
+
+// ReplayRequest edits a captured request before it is sent again. Headers
+// replace the captured values of the same name, an empty list removes the
+// header. a nil Body keeps the captured one.
+type ReplayRequest struct {
+    Headers http.Header `json:"headers"`
+    Body    *string     `json:"body"`
+}
+
+// EnableReplay lets captured requests be sent to the local service again
+// through fwd
+func (i *Inspector) EnableReplay(fwd *forwarder.Forwarder) {
+    i.mutex.Lock()
+    i.forwarder = fwd
+    i.mutex.Unlock()
+}
+
+// Replay sends a captured request to the local service again, with the
+// edits applied, and records it as a new exchange. the error is only set
+// when the request could not be sent at all, a failed forward is recorded
+// on the exchange.
+func (i *Inspector) Replay(ctx context.Context, id string, edit ReplayRequest) (Exchange, error) {
+    i.mutex.Lock()
+    fwd := i.forwarder
+    i.mutex.Unlock()
+    if fwd == nil {
+        return Exchange{}, errReplayDisabled
+    }
+    
+    original, ok := i.Exchange(id)
+    if !ok {
+        return Exchange{}, errNotFound
+    }
+    
+    body := original.RequestBody.Data
+    if edit.Body != nil {
+        body = []byte(*edit.Body)
+    } else if original.RequestBody.Truncated {
+        return Exchange{}, fmt.Errorf("request body of %d bytes was too large to keep, send a new body", original.RequestBody.Size)
+    }
+    
+    headers := original.RequestHeaders.Clone()
+    if headers == nil {
+        headers = make(http.Header)
+    }
+    for key, values := range edit.Headers {
+        if len(values) == 0 {
+            headers.Del(key)
+        } else {
+            headers[http.CanonicalHeaderKey(key)] = values
+        }
+    }
+    // the body is sent whole, never chunked
+    headers.Del("Transfer-Encoding")
+    headers.Set("Content-Length", strconv.Itoa(len(body)))
+    
+    recording := i.record(&Exchange{
+        ID:             newID(),
+        Start:          time.Now(),
+        Method:         original.Method,
+        URL:            original.URL,
+        RequestHeaders: headers,
+        ReplayOf:       id,
+    })
+    
+    resp, err := fwd.Forward(ctx, original.Method, original.URL, headers, nil, recording.RequestBody(bytes.NewReader(body)))
+    if err != nil {
+        recording.Fail(err)
+        recording.Finish(nil)
+    } else {
+        respBody := recording.Response(resp.StatusCode, resp.Headers, resp.Body)
+        _, err = io.Copy(io.Discard, respBody)
+        respBody.Close()
+        recording.Finish(err)
+    }
+    
+    i.mutex.Lock()
+    replay := *recording.exchange
+    i.mutex.Unlock()
+    return replay, nil
+}
+
+// newID makes an id for a replayed exchange, in the same form as the
+// request ids the server assigns
+func newID() string {
+    bytes := make([]byte, 16)
+    rand.Read(bytes)
+    return hex.EncodeToString(bytes)
+}
\ No newline at end of file
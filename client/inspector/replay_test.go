@@ -0,0 +1,125 @@
+package inspector
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strconv"
+    "strings"
+    "testing"
+    
+    "mole/client/forwarder"
+)
+
+// received is what the local service got from a replay
+type received struct {
+    headers http.Header
+    body    string
+}
+
+// newReplayInspector captures one POST to /items as "orig" and replays to
+// a local service that reports each request it gets
+func newReplayInspector(t *testing.T) (*Inspector, chan received) {
+    t.Helper()
+    requests := make(chan received, 16)
+    local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        requests <- received{r.Header, string(body)}
+        w.Write([]byte("ok"))
+    }))
+    t.Cleanup(local.Close)
+    u, _ := url.Parse(local.URL)
+    port, _ := strconv.Atoi(u.Port())
+    
+    i := New(10, maxBodySize)
+    i.EnableReplay(forwarder.NewForwarder(port))
+    
+    recording := i.Record("orig", http.MethodPost, "/items?page=1", http.Header{
+        "Content-Type": {"text/plain"},
+        "X-Debug":      {"1"},
+        "X-Keep":       {"yes"},
+    })
+    io.ReadAll(recording.RequestBody(strings.NewReader("original body")))
+    recording.Response(http.StatusOK, http.Header{}, io.NopCloser(strings.NewReader("ok"))).Close()
+    recording.Finish(nil)
+    return i, requests
+}
+
+func TestReplayContentType(t *testing.T) {
+    i, requests := newReplayInspector(t)
+    
+    tests := []struct {
+        contentType string
+        code        int
+    }{
+        {"application/json", http.StatusOK},
+        {"application/json; charset=utf-8", http.StatusOK},
+        {"Application/JSON", http.StatusOK},
+        // what a cross-origin form can send without a preflight
+        {"", http.StatusUnsupportedMediaType},
+        {"text/plain", http.StatusUnsupportedMediaType},
+        {"application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+        {"multipart/form-data; boundary=x", http.StatusUnsupportedMediaType},
+        {"application/jsonp", http.StatusUnsupportedMediaType},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest(http.MethodPost, "/api/requests/orig/replay", strings.NewReader(`{}`))
+        if tt.contentType != "" {
+            r.Header.Set("Content-Type", tt.contentType)
+        }
+        w := httptest.NewRecorder()
+        i.serveExchange(w, r)
+        if w.Code != tt.code {
+            t.Errorf("content type %q answered %d, want %d: %s", tt.contentType, w.Code, tt.code, w.Body)
+        }
+    }
+    if n := len(requests); n != 3 {
+        t.Errorf("local service got %d replays, want 3", n)
+    }
+    
+    w := httptest.NewRecorder()
+    i.serveExchange(w, httptest.NewRequest(http.MethodGet, "/api/requests/orig/replay", nil))
+    if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+        t.Errorf("GET answered %d, allowing %q", w.Code, w.Header().Get("Allow"))
+    }
+}
+
+func TestReplayEdits(t *testing.T) {
+    i, requests := newReplayInspector(t)
+    
+    body := "edited body"
+    replay, err := i.Replay(context.Background(), "orig", ReplayRequest{
+        Headers: http.Header{"X-Debug": {}, "x-new": {"a", "b"}},
+        Body:    &body,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    got := <-requests
+    if _, ok := got.headers["X-Debug"]; ok {
+        t.Errorf("removed header sent: %v", got.headers)
+    }
+    if got.headers.Get("X-Keep") != "yes" || strings.Join(got.headers["X-New"], ",") != "a,b" {
+        t.Errorf("sent headers %v", got.headers)
+    }
+    if got.body != body || got.headers.Get("Content-Length") != strconv.Itoa(len(body)) {
+        t.Errorf("sent body %q with length %q", got.body, got.headers.Get("Content-Length"))
+    }
+    if replay.ReplayOf != "orig" || replay.Status != http.StatusOK || !replay.Done {
+        t.Errorf("replay recorded as %+v", replay)
+    }
+    
+    // no edits sends the captured request as it was
+    if _, err := i.Replay(context.Background(), "orig", ReplayRequest{}); err != nil {
+        t.Fatal(err)
+    }
+    if got := <-requests; got.body != "original body" || got.headers.Get("X-Debug") != "1" {
+        t.Errorf("unedited replay sent %q with %v", got.body, got.headers)
+    }
+    
+    if _, err := i.Replay(context.Background(), "missing", ReplayRequest{}); err != errNotFound {
+        t.Errorf("replaying an unknown request returned %v", err)
+    }
+}
\ No newline at end of file
@@ -0,0 +1,299 @@
+package inspector
+
+import (
+    _ "embed"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "mime"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+    "unicode/utf8"
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+// the list view of an exchange
+type summary struct {
+    ID           string    `json:"id"`
+    Start        time.Time `json:"start"`
+    Method       string    `json:"method"`
+    URL          string    `json:"url"`
+    Status       int       `json:"status"`
+    Error        string    `json:"error,omitempty"`
+    ReplayOf     string    `json:"replay_of,omitempty"`
+    Done         bool      `json:"done"`
+    DurationMS   float64   `json:"duration_ms"`
+    RequestSize  int64     `json:"request_size"`
+    ResponseSize int64     `json:"response_size"`
+}
+
+// the detail view of an exchange, bodies rendered for display
+type detail struct {
+    summary
+    WaitMS          float64     `json:"wait_ms"`
+    RequestHeaders  http.Header `json:"request_headers"`
+    ResponseHeaders http.Header `json:"response_headers"`
+    RequestBody     bodyView    `json:"request_body"`
+    ResponseBody    bodyView    `json:"response_body"`
+}
+
+// Text is the body rendered for reading, Raw the body as sent when it is
+// text and kept whole, for editing before a replay
+type bodyView struct {
+    Size      int64  `json:"size"`
+    Truncated bool   `json:"truncated"`
+    Format    string `json:"format"`
+    Text      string `json:"text"`
+    Raw       string `json:"raw,omitempty"`
+}
+
+// the answer to a replay, both exchanges side by side
+type replayResult struct {
+    Original detail `json:"original"`
+    Replay   detail `json:"replay"`
+}
+
+// Start serves the inspector page and its api on addr in the background
+func (i *Inspector) Start(addr string) error {
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        return fmt.Errorf("failed to start inspector: %v", err)
+    }
+    
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", i.serveIndex)
+    mux.HandleFunc("/api/requests", i.serveList)
+    mux.HandleFunc("/api/requests/", i.serveExchange)
+    mux.HandleFunc("/api/events", i.serveEvents)
+    
+    // a name or address given explicitly may be used to reach the inspector
+    bound, _, _ := net.SplitHostPort(addr)
+    if ip := net.ParseIP(bound); ip != nil && ip.IsUnspecified() {
+        bound = ""
+    }
+    
+    go func() {
+        if err := http.Serve(listener, guard(bound, mux)); err != nil {
+            log.Printf("[INSPECTOR] Stopped: %v", err)
+        }
+    }()
+    return nil
+}
+
+// guard turns away requests a web page could make on the user's behalf.
+// the Host must name this machine, which defeats dns rebinding, and api
+// calls from a browser must come from the inspector page itself.
+func guard(bound string, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !allowedHost(r.Host, bound) {
+            http.Error(w, "host not allowed", http.StatusForbidden)
+            return
+        }
+        if strings.HasPrefix(r.URL.Path, "/api/") && !sameOrigin(r) {
+            http.Error(w, "cross-origin request refused", http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// allowedHost reports whether a Host header names the loopback interface
+// or the address the inspector was started on
+func allowedHost(host, bound string) bool {
+    if name, _, err := net.SplitHostPort(host); err == nil {
+        host = name
+    }
+    host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+    
+    // browsers always send a name, an empty one comes from a client given
+    // just a port
+    if host == "" || host == "localhost" {
+        return true
+    }
+    if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+        return true
+    }
+    return bound != "" && strings.EqualFold(host, strings.TrimSuffix(bound, "."))
+}
+
+// sameOrigin reports whether a request comes from the inspector page.
+// browsers mark requests from other sites with Sec-Fetch-Site and Origin,
+// tools such as curl and mole replay send neither.
+func sameOrigin(r *http.Request) bool {
+    switch r.Header.Get("Sec-Fetch-Site") {
+    case "", "same-origin", "none":
+    default:
+        return false
+    }
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return true
+    }
+    u, err := url.Parse(origin)
+    return err == nil && u.Scheme == "http" && strings.EqualFold(u.Host, r.Host)
+}
+
+func (i *Inspector) serveIndex(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/" {
+        http.NotFound(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Write(indexHTML)
+}
+
+func (i *Inspector) serveList(w http.ResponseWriter, r *http.Request) {
+    exchanges := i.Exchanges()
+    summaries := make([]summary, 0, len(exchanges))
+    for _, ex := range exchanges {
+        summaries = append(summaries, summarize(ex))
+    }
+    writeJSON(w, summaries)
+}
+
+func (i *Inspector) serveExchange(w http.ResponseWriter, r *http.Request) {
+    id := strings.TrimPrefix(r.URL.Path, "/api/requests/")
+    if id, ok := strings.CutSuffix(id, "/replay"); ok {
+        i.serveReplay(w, r, id)
+        return
+    }
+    
+    ex, ok := i.Exchange(id)
+    if !ok {
+        http.Error(w, errNotFound.Error(), http.StatusNotFound)
+        return
+    }
+    writeJSON(w, describe(ex))
+}
+
+// serveReplay sends a captured request again, edited by the ReplayRequest
+// in the body if there is one. the body must be declared as json, which a
+// cross-origin form cannot do without a preflight the inspector never
+// answers, on top of the Origin check in guard.
+func (i *Inspector) serveReplay(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", http.MethodPost)
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+        http.Error(w, "replay requests must be sent as application/json", http.StatusUnsupportedMediaType)
+        return
+    }
+    
+    var edit ReplayRequest
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&edit); err != nil && err != io.EOF {
+            http.Error(w, fmt.Sprintf("invalid replay request: %v", err), http.StatusBadRequest)
+            return
+        }
+    }
+    
+    original, ok := i.Exchange(id)
+    if !ok {
+        http.Error(w, errNotFound.Error(), http.StatusNotFound)
+        return
+    }
+    replay, err := i.Replay(r.Context(), id, edit)
+    switch {
+    case errors.Is(err, errNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    default:
+        writeJSON(w, replayResult{Original: describe(original), Replay: describe(replay)})
+    }
+}
+
+// serveEvents streams the summary of every exchange that changes as
+// server-sent events, the page uses it to update live
+func (i *Inspector) serveEvents(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "streaming not supported", http.StatusInternalServerError)
+        return
+    }
+    
+    ch := i.subscribe()
+    defer i.unsubscribe(ch)
+    
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    flusher.Flush()
+    
+    for {
+        select {
+        case id := <-ch:
+            ex, ok := i.Exchange(id)
+            if !ok {
+                continue
+            }
+            data, err := json.Marshal(summarize(ex))
+            if err != nil {
+                continue
+            }
+            fmt.Fprintf(w, "data: %s\n\n", data)
+            flusher.Flush()
+        
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+func summarize(ex Exchange) summary {
+    return summary{
+        ID:           ex.ID,
+        Start:        ex.Start,
+        Method:       ex.Method,
+        URL:          ex.URL,
+        Status:       ex.Status,
+        Error:        ex.Error,
+        ReplayOf:     ex.ReplayOf,
+        Done:         ex.Done,
+        DurationMS:   milliseconds(ex.Duration),
+        RequestSize:  ex.RequestBody.Size,
+        ResponseSize: ex.ResponseBody.Size,
+    }
+}
+
+func describe(ex Exchange) detail {
+    return detail{
+        summary:         summarize(ex),
+        WaitMS:          milliseconds(ex.Wait),
+        RequestHeaders:  ex.RequestHeaders,
+        ResponseHeaders: ex.ResponseHeaders,
+        RequestBody:     viewBody(ex.RequestHeaders, ex.RequestBody),
+        ResponseBody:    viewBody(ex.ResponseHeaders, ex.ResponseBody),
+    }
+}
+
+func viewBody(headers http.Header, body Body) bodyView {
+    format, text := prettyBody(headers, body.Data)
+    view := bodyView{
+        Size:      body.Size,
+        Truncated: body.Truncated,
+        Format:    format,
+        Text:      text,
+    }
+    if !body.Truncated && utf8.Valid(body.Data) {
+        view.Raw = string(body.Data)
+    }
+    return view
+}
+
+func milliseconds(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(v)
+}
\ No newline at end of file
@@ -0,0 +1,87 @@
+package inspector
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func guarded(bound string) http.Handler {
+    return guard(bound, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    }))
+}
+
+func TestGuardHost(t *testing.T) {
+    tests := []struct {
+        bound string
+        host  string
+        code  int
+    }{
+        {"", "localhost:4040", http.StatusOK},
+        {"", "LOCALHOST.:4040", http.StatusOK},
+        {"", "127.0.0.1:4040", http.StatusOK},
+        {"", "127.0.0.2", http.StatusOK},
+        {"", "[::1]:4040", http.StatusOK},
+        {"", "", http.StatusOK},
+        // a name the attacker controls, resolving to 127.0.0.1
+        {"", "evil.example.com:4040", http.StatusForbidden},
+        {"", "127.0.0.1.nip.io:4040", http.StatusForbidden},
+        {"", "localhost.evil.example.com", http.StatusForbidden},
+        {"", "192.168.1.10:4040", http.StatusForbidden},
+        // the address the inspector was started on is allowed as well
+        {"192.168.1.10", "192.168.1.10:4040", http.StatusOK},
+        {"devbox.lan", "DevBox.lan.:4040", http.StatusOK},
+        {"devbox.lan", "other.lan:4040", http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        for _, path := range []string{"/", "/api/requests"} {
+            r := httptest.NewRequest(http.MethodGet, path, nil)
+            r.Host = tt.host
+            w := httptest.NewRecorder()
+            guarded(tt.bound).ServeHTTP(w, r)
+            if w.Code != tt.code {
+                t.Errorf("host %q bound to %q: %s answered %d, want %d", tt.host, tt.bound, path, w.Code, tt.code)
+            }
+        }
+    }
+}
+
+func TestGuardOrigin(t *testing.T) {
+    tests := []struct {
+        name    string
+        headers map[string]string
+        code    int
+    }{
+        {"curl", nil, http.StatusOK},
+        {"inspector page", map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://localhost:4040"}, http.StatusOK},
+        {"typed in the address bar", map[string]string{"Sec-Fetch-Site": "none"}, http.StatusOK},
+        {"other site", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+        {"other port", map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+        {"other origin", map[string]string{"Origin": "http://evil.example.com"}, http.StatusForbidden},
+        {"other scheme", map[string]string{"Origin": "https://localhost:4040"}, http.StatusForbidden},
+        {"sandboxed page", map[string]string{"Origin": "null"}, http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest(http.MethodPost, "/api/requests/1/replay", nil)
+        r.Host = "localhost:4040"
+        for key, value := range tt.headers {
+            r.Header.Set(key, value)
+        }
+        w := httptest.NewRecorder()
+        guarded("").ServeHTTP(w, r)
+        if w.Code != tt.code {
+            t.Errorf("%s: answered %d, want %d", tt.name, w.Code, tt.code)
+        }
+    }
+    
+    // the page itself may be linked to from anywhere
+    r := httptest.NewRequest(http.MethodGet, "/", nil)
+    r.Host = "localhost:4040"
+    r.Header.Set("Sec-Fetch-Site", "cross-site")
+    w := httptest.NewRecorder()
+    guarded("").ServeHTTP(w, r)
+    if w.Code != http.StatusOK {
+        t.Errorf("cross-site navigation to the page answered %d", w.Code)
+    }
+}
\ No newline at end of file
@@ -3,62 +3,153 @@ package main
 import (
     "fmt"
     "log"
+    "net/http"
     "os"
     "os/signal"
-    "strconv"
     "syscall"
     
     "mole/client/config"
     "mole/client/forwarder"
+    "mole/client/inspector"
     "mole/client/tunnel"
+    "mole/internal/metrics"
 )
 
+// exchanges the inspector keeps, and the most memory their bodies may take
+const (
+    inspectorHistory = 500
+    inspectorMemory  = 64 << 20
+)
+
+const usage = `usage:
+  mole http <port> [-d subdomain] [-token token] [-takeover] [-http-policy redirect|both|http-only] [-inspect addr]
+            [-record file.har] [-record-max-size mb] [-redact rule] [-metrics addr]
+  mole tcp <port> [-r remote-port] [-token token] [-metrics addr]
+  mole udp <port> [-r remote-port] [-token token] [-metrics addr]
+  mole replay [request-id] [-H "Key: value"] [-d body|@file] [-inspect addr]`
+
 func main() {
     
-	if len(os.Args) < 3 || os.Args[1] != "http" {
-        fmt.Println("usage: mole http <port> [-d subdomain]")
-        os.Exit(1)
-    }
-    
-    // parse local port
-    localPort, err := strconv.Atoi(os.Args[2])
-    if err != nil {
-        log.Fatalf("invalid port: %v", err)
-    }
-    
-    cfg, subdomainOverride, _, err := config.Load()
+    cfg, args, err := config.Load()
     if err != nil {
         log.Fatalf("failed to load config: %v", err)
     }
     
-    // determine subdomain
-    subdomain := cfg.Subdomain
-    if subdomainOverride != nil {
-        subdomain = *subdomainOverride
+    if args.Mode == "" {
+        fmt.Println(usage)
+        os.Exit(1)
     }
-    
-    if subdomain == "" {
-        log.Fatalf("subdomain is required (set in config.json or use -d flag)")
+    if args.Mode == "replay" {
+        if err := runReplay(args); err != nil {
+            log.Fatalf("%v", err)
+        }
+        return
+    }
+    if args.LocalPort == 0 {
+        log.Fatalf("invalid port: %s", os.Args[2])
     }
     
-    // create forwarder
-    fwd := forwarder.NewForwarder(localPort)
-    
-    // create tunnel client
     serverURL := fmt.Sprintf("%s:%d", cfg.Server, cfg.Port)
-    client := tunnel.NewClient(serverURL, subdomain, fwd)
     
-    // connect to server
-    if err := client.Connect(); err != nil {
-        log.Fatalf("failed to connect: %v", err)
+    var client *tunnel.Client
+    switch args.Mode {
+    case "tcp":
+        // create tcp forwarder and tunnel client
+        fwd := forwarder.NewTCPForwarder(args.LocalPort)
+        client = tunnel.NewTCPClient(serverURL, args.RemotePort, fwd)
+    
+    case "udp":
+        // create udp forwarder and tunnel client
+        fwd := forwarder.NewUDPForwarder(args.LocalPort)
+        client = tunnel.NewUDPClient(serverURL, args.RemotePort, fwd)
+    
+    default:
+        // determine subdomain, the server picks one when none is set
+        subdomain := cfg.Subdomain
+        if args.Subdomain != nil {
+            subdomain = *args.Subdomain
+        }
+        
+        // create forwarder and tunnel client
+        fwd := forwarder.NewForwarder(args.LocalPort)
+        client = tunnel.NewClient(serverURL, subdomain, fwd)
+        
+        // recent requests can be looked at in a browser and written to a
+        // har file, the inspector captures them for both
+        if args.InspectAddr != "" || args.RecordPath != "" {
+            inspect := inspector.New(inspectorHistory, inspectorMemory)
+            inspect.EnableReplay(fwd)
+            client.SetInspector(inspect)
+            
+            if args.InspectAddr != "" {
+                if err := inspect.Start(args.InspectAddr); err != nil {
+                    log.Printf("warning: %v", err)
+                } else {
+                    log.Printf("inspect requests at http://%s", args.InspectAddr)
+                }
+            }
+            
+            if args.RecordPath != "" {
+                redactor, err := inspector.NewRedactor(args.Redact)
+                if err != nil {
+                    log.Fatalf("%v", err)
+                }
+                har, err := inspector.NewHARWriter(args.RecordPath, args.RecordMaxSize, redactor)
+                if err != nil {
+                    log.Fatalf("%v", err)
+                }
+                log.Printf("recording requests to %s", args.RecordPath)
+                
+                // urls in the archive are absolute, under the public address
+                inspect.OnFinish(func(ex inspector.Exchange) {
+                    base := client.URL()
+                    if base == "" {
+                        base = fmt.Sprintf("http://localhost:%d", args.LocalPort)
+                    }
+                    if err := har.Write(base, ex); err != nil {
+                        log.Printf("[ERROR] Failed to record %s: %v", ex.ID, err)
+                    }
+                })
+            }
+        }
     }
-    defer client.Close()
     
-    protocol := "http"
-    if cfg.UseHTTPS {
-        protocol = "https"
+    // forwarder latency and errors for prometheus
+    if args.MetricsAddr != "" {
+        mux := http.NewServeMux()
+        mux.Handle("/metrics", metrics.Default)
+        go func() {
+            if err := http.ListenAndServe(args.MetricsAddr, mux); err != nil {
+                log.Printf("warning: failed to serve metrics: %v", err)
+            }
+        }()
+        log.Printf("serving metrics on http://%s/metrics", args.MetricsAddr)
     }
-    log.Printf("forwarding http://localhost:%d to %s://%s.%s", localPort, protocol, subdomain, cfg.Server)
+    
+    client.SetToken(cfg.Token)
+    client.SetTakeover(args.Takeover)
+    client.SetHTTPPolicy(args.HTTPPolicy)
+    client.SetHeartbeat(args.PingInterval, args.PingTimeout)
+    
+    // report connection state changes, the public address once online
+    client.OnStateChange(func(state tunnel.State) {
+        log.Printf("tunnel %s", state)
+        if state != tunnel.StateOnline {
+            return
+        }
+        
+        if client.URL() != "" {
+            log.Printf("forwarding %s://localhost:%d to %s", args.Mode, args.LocalPort, client.URL())
+        } else if args.Mode == "tcp" || args.Mode == "udp" {
+            log.Printf("forwarding %s://localhost:%d to %s://%s:%d", args.Mode, args.LocalPort, args.Mode, cfg.Server, client.RemotePort())
+        } else {
+            protocol := "http"
+            if cfg.UseHTTPS {
+                protocol = "https"
+            }
+            log.Printf("forwarding http://localhost:%d to %s://%s.%s", args.LocalPort, protocol, client.Subdomain(), cfg.Server)
+        }
+    })
     
     // handle shutdown gracefully
     c := make(chan os.Signal, 1)
@@ -66,13 +157,18 @@ func main() {
     
     go func() {
         <-c // wait for signal
-        log.Println("shutting down...")
-        client.Close()
+        log.Printf("shutting down, waiting up to %s for in-flight requests", args.DrainTimeout)
+        go func() {
+            <-c
+            log.Println("got a second signal, exiting without waiting")
+            os.Exit(1)
+        }()
+        client.Drain(args.DrainTimeout)
         os.Exit(0)
     }()
     
-    // start listening for requests
-    if err := client.Listen(); err != nil {
+    // connect and serve, reconnecting whenever the connection drops
+    if err := client.Run(); err != nil {
         log.Fatalf("tunnel error: %v", err)
     }
 }
\ No newline at end of file
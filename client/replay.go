@@ -0,0 +1,142 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "sort"
+    "strings"
+    
+    "mole/client/config"
+    "mole/client/inspector"
+)
+
+// the parts of an inspector exchange the replay command prints
+type replayedExchange struct {
+    ID         string      `json:"id"`
+    Method     string      `json:"method"`
+    URL        string      `json:"url"`
+    Status     int         `json:"status"`
+    Error      string      `json:"error"`
+    DurationMS float64     `json:"duration_ms"`
+    Headers    http.Header `json:"response_headers"`
+    Body       struct {
+        Size      int64  `json:"size"`
+        Truncated bool   `json:"truncated"`
+        Text      string `json:"text"`
+    } `json:"response_body"`
+}
+
+// runReplay asks the inspector of a running client to send a captured
+// request to the local service again, then prints the original response
+// and the new one. without an id it lists the captured requests.
+func runReplay(args *config.Args) error {
+    base := "http://" + args.InspectAddr + "/api/requests"
+    if args.ReplayID == "" {
+        return listCaptured(base)
+    }
+    
+    edit := inspector.ReplayRequest{Headers: make(http.Header)}
+    for _, header := range args.ReplayHeaders {
+        key, value, found := strings.Cut(header, ":")
+        if !found || strings.TrimSpace(key) == "" {
+            return fmt.Errorf("invalid header %q, expected \"Key: value\"", header)
+        }
+        key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+        if value = strings.TrimSpace(value); value == "" {
+            edit.Headers[key] = []string{}
+        } else {
+            edit.Headers[key] = append(edit.Headers[key], value)
+        }
+    }
+    if args.ReplayBody != nil {
+        body := *args.ReplayBody
+        if strings.HasPrefix(body, "@") {
+            data, err := os.ReadFile(body[1:])
+            if err != nil {
+                return fmt.Errorf("failed to read body: %v", err)
+            }
+            body = string(data)
+        }
+        edit.Body = &body
+    }
+    
+    payload, err := json.Marshal(edit)
+    if err != nil {
+        return err
+    }
+    resp, err := http.Post(base+"/"+args.ReplayID+"/replay", "application/json", bytes.NewReader(payload))
+    if err != nil {
+        return fmt.Errorf("is the client running? %v", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        message, _ := io.ReadAll(resp.Body)
+        return fmt.Errorf("replay failed: %s", strings.TrimSpace(string(message)))
+    }
+    
+    var result struct {
+        Original replayedExchange `json:"original"`
+        Replay   replayedExchange `json:"replay"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return fmt.Errorf("invalid replay response: %v", err)
+    }
+    
+    fmt.Printf("replayed %s %s as %s in %.1fms\n", result.Replay.Method, result.Replay.URL, result.Replay.ID, result.Replay.DurationMS)
+    printResponse("original", &result.Original)
+    printResponse("replay", &result.Replay)
+    return nil
+}
+
+func listCaptured(base string) error {
+    resp, err := http.Get(base)
+    if err != nil {
+        return fmt.Errorf("is the client running? %v", err)
+    }
+    defer resp.Body.Close()
+    
+    var exchanges []replayedExchange
+    if err := json.NewDecoder(resp.Body).Decode(&exchanges); err != nil {
+        return fmt.Errorf("invalid inspector response: %v", err)
+    }
+    if len(exchanges) == 0 {
+        fmt.Println("no requests captured yet")
+        return nil
+    }
+    for _, ex := range exchanges {
+        fmt.Printf("%s  %3d  %-7s %s\n", ex.ID, ex.Status, ex.Method, ex.URL)
+    }
+    return nil
+}
+
+func printResponse(title string, ex *replayedExchange) {
+    fmt.Printf("\n--- %s\n", title)
+    if ex.Error != "" {
+        fmt.Printf("error: %s\n", ex.Error)
+    }
+    if ex.Status == 0 {
+        return
+    }
+    
+    fmt.Printf("HTTP %d\n", ex.Status)
+    keys := make([]string, 0, len(ex.Headers))
+    for key := range ex.Headers {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        for _, value := range ex.Headers[key] {
+            fmt.Printf("%s: %s\n", key, value)
+        }
+    }
+    if ex.Body.Text != "" {
+        fmt.Printf("\n%s\n", strings.TrimRight(ex.Body.Text, "\n"))
+    }
+    if ex.Body.Truncated {
+        fmt.Printf("(%d bytes, truncated)\n", ex.Body.Size)
+    }
+}
\ No newline at end of file
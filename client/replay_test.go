@@ -0,0 +1,79 @@
+package main
+
+import (
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strconv"
+    "strings"
+    "testing"
+    
+    "mole/client/config"
+    "mole/client/forwarder"
+    "mole/client/inspector"
+)
+
+// startInspector runs an inspector holding one captured request, "orig",
+// that replays to a local service reporting the headers it gets
+func startInspector(t *testing.T) (string, chan http.Header) {
+    t.Helper()
+    headers := make(chan http.Header, 4)
+    local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        headers <- r.Header
+    }))
+    t.Cleanup(local.Close)
+    u, _ := url.Parse(local.URL)
+    port, _ := strconv.Atoi(u.Port())
+    
+    i := inspector.New(10, 1<<20)
+    i.EnableReplay(forwarder.NewForwarder(port))
+    recording := i.Record("orig", http.MethodGet, "/", http.Header{
+        "Authorization": {"Bearer token"},
+        "X-Debug":       {"1"},
+    })
+    recording.Response(http.StatusOK, http.Header{}, io.NopCloser(strings.NewReader(""))).Close()
+    recording.Finish(nil)
+    
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := listener.Addr().String()
+    listener.Close()
+    if err := i.Start(addr); err != nil {
+        t.Fatal(err)
+    }
+    return addr, headers
+}
+
+func TestReplayHeaderFlags(t *testing.T) {
+    addr, headers := startInspector(t)
+    
+    args := &config.Args{
+        InspectAddr:   addr,
+        ReplayID:      "orig",
+        ReplayHeaders: []string{"Authorization:", "x-debug: ", "X-New: a", "x-new:b"},
+    }
+    if err := runReplay(args); err != nil {
+        t.Fatal(err)
+    }
+    got := <-headers
+    // "Key:" with no value removes the captured header
+    for _, key := range []string{"Authorization", "X-Debug"} {
+        if _, ok := got[key]; ok {
+            t.Errorf("%s still sent: %v", key, got)
+        }
+    }
+    if strings.Join(got["X-New"], ",") != "a,b" {
+        t.Errorf("X-New sent as %q", got["X-New"])
+    }
+    
+    for _, header := range []string{"X-Debug", ": value", " :"} {
+        args.ReplayHeaders = []string{header}
+        if err := runReplay(args); err == nil || !strings.Contains(err.Error(), "invalid header") {
+            t.Errorf("header %q returned %v", header, err)
+        }
+    }
+}
\ No newline at end of file
@@ -1,43 +1,113 @@
 package tunnel
 
 import (
+    "errors"
     "fmt"
+    "io"
     "log"
+    "net"
+    "net/http"
     "net/url"
     "strings"
+    "sync"
+    "sync/atomic"
+    "time"
     
     "github.com/gorilla/websocket"
     
     "mole/client/forwarder"
+    "mole/client/inspector"
+    "mole/internal/wire"
+)
+
+// heartbeat defaults, see SetHeartbeat
+const (
+    defaultPingInterval = 20 * time.Second
+    defaultPingTimeout  = 10 * time.Second
+)
+
+// tunnel kinds sent in the register message
+const (
+    KindHTTP = "http"
+    KindTCP  = "tcp"
+    KindUDP  = "udp"
 )
 
 type Client struct {
-    serverURL string
-    subdomain string
-    forwarder *forwarder.Forwarder
-    conn      *websocket.Conn
+    serverURL    string
+    kind         string
+    subdomain    string
+    remotePort   int
+    url          string
+    token        string
+    takeover     bool
+    httpPolicy   string
+    forwarder    *forwarder.Forwarder
+    inspector    *inspector.Inspector
+    tcpForwarder *forwarder.TCPForwarder
+    udpForwarder *forwarder.UDPForwarder
+    conn         *websocket.Conn
+    writer       *wire.Writer
+    protocol     string
+    session      string
+    link         *wire.Link
+    pingInterval time.Duration
+    pingTimeout  time.Duration
+    rtt          atomic.Int64
+    state        State
+    onState      func(State)
+    done         chan struct{}
+    closeOnce    sync.Once
+    draining     bool
+    streams      map[string]*stream
+    mutex        sync.Mutex
 }
 
-type Request struct {
-    ID      string            `json:"id"`
-    Method  string            `json:"method"`
-    URL     string            `json:"url"`
-    Headers map[string]string `json:"headers"`
-    Body    []byte            `json:"body"`
+// NewClient creates a client for an http tunnel, an empty subdomain lets the
+// server generate one
+func NewClient(serverURL, subdomain string, forwarder *forwarder.Forwarder) *Client {
+    return &Client{
+        serverURL:    serverURL,
+        kind:         KindHTTP,
+        subdomain:    subdomain,
+        forwarder:    forwarder,
+        streams:      make(map[string]*stream),
+        state:        StateOffline,
+        pingInterval: defaultPingInterval,
+        pingTimeout:  defaultPingTimeout,
+        done:         make(chan struct{}),
+    }
 }
 
-type Response struct {
-    ID         string            `json:"id"`
-    StatusCode int               `json:"status_code"`
-    Headers    map[string]string `json:"headers"`
-    Body       []byte            `json:"body"`
+// NewTCPClient creates a client for a raw tcp tunnel, remotePort zero lets
+// the server pick the public port
+func NewTCPClient(serverURL string, remotePort int, forwarder *forwarder.TCPForwarder) *Client {
+    return &Client{
+        serverURL:    serverURL,
+        kind:         KindTCP,
+        remotePort:   remotePort,
+        tcpForwarder: forwarder,
+        streams:      make(map[string]*stream),
+        state:        StateOffline,
+        pingInterval: defaultPingInterval,
+        pingTimeout:  defaultPingTimeout,
+        done:         make(chan struct{}),
+    }
 }
 
-func NewClient(serverURL, subdomain string, forwarder *forwarder.Forwarder) *Client {
+// NewUDPClient creates a client for a udp tunnel, remotePort zero lets the
+// server pick the public port
+func NewUDPClient(serverURL string, remotePort int, forwarder *forwarder.UDPForwarder) *Client {
     return &Client{
-        serverURL: serverURL,
-        subdomain: subdomain,
-        forwarder: forwarder,
+        serverURL:    serverURL,
+        kind:         KindUDP,
+        remotePort:   remotePort,
+        udpForwarder: forwarder,
+        streams:      make(map[string]*stream),
+        state:        StateOffline,
+        pingInterval: defaultPingInterval,
+        pingTimeout:  defaultPingTimeout,
+        done:         make(chan struct{}),
     }
 }
 
@@ -48,85 +118,433 @@ func (c *Client) Connect() error {
     }
     u := url.URL{Scheme: scheme, Host: c.serverURL, Path: "/tunnel"}
     
-    var err error
-    c.conn, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
+    // the token rides on the dial as well so proxies in front of the
+    // server can reject unauthenticated clients early
+    var header http.Header
+    if c.token != "" {
+        header = http.Header{"Authorization": {"Bearer " + c.token}}
+    }
+    
+    conn, resp, err := websocket.DefaultDialer.Dial(u.String(), header)
     if err != nil {
+        // a server that turns the connection away says why in the body
+        if resp != nil {
+            reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+            if reason := strings.TrimSpace(string(reason)); reason != "" {
+                return fmt.Errorf("server refused the connection: %s: %s", resp.Status, reason)
+            }
+        }
         return fmt.Errorf("failed to connect to server: %v", err)
     }
     
-    // register with subdomain
+    // register with subdomain, or requested port for tcp tunnels
     registerMsg := map[string]interface{}{
         "type":      "register",
-        "subdomain": c.subdomain,
+        "kind":      c.kind,
+        "protocols": wire.SupportedProtocols,
+    }
+    if c.token != "" {
+        registerMsg["token"] = c.token
+    }
+    if c.takeover {
+        registerMsg["takeover"] = true
+    }
+    if c.httpPolicy != "" {
+        registerMsg["http_policy"] = c.httpPolicy
+    }
+    // a resumed session replays the frames either side missed, counting
+    // from what the other side received
+    if session, link := c.currentSession(); session != "" {
+        registerMsg["resume"] = session
+        registerMsg["received"] = link.Received()
+    }
+    if c.kind == KindTCP || c.kind == KindUDP {
+        registerMsg["remote_port"] = c.remotePort
+    } else {
+        registerMsg["subdomain"] = c.subdomain
     }
     
-    if err := c.conn.WriteJSON(registerMsg); err != nil {
+    if err := conn.WriteJSON(registerMsg); err != nil {
+        conn.Close()
         return fmt.Errorf("failed to register: %v", err)
     }
     
     // wait for confirmation
     var response map[string]interface{}
-    if err := c.conn.ReadJSON(&response); err != nil {
+    if err := conn.ReadJSON(&response); err != nil {
+        conn.Close()
         return fmt.Errorf("failed to read registration response: %v", err)
     }
     
     if response["type"] != "registered" {
-        return fmt.Errorf("registration failed")
+        conn.Close()
+        code, _ := response["code"].(string)
+        reason, _ := response["error"].(string)
+        if reason == "" {
+            reason = "registration failed"
+        }
+        return &RegistrationError{Code: code, Message: reason}
+    }
+    
+    if port, ok := response["remote_port"].(float64); ok {
+        c.remotePort = int(port)
+    }
+    if subdomain, ok := response["subdomain"].(string); ok && subdomain != "" {
+        c.subdomain = subdomain
+    }
+    if publicURL, ok := response["url"].(string); ok {
+        c.url = publicURL
+    }
+    
+    // servers that predate protocol negotiation only speak json
+    protocol := wire.ProtocolJSON
+    if negotiated, ok := response["protocol"].(string); ok && negotiated != "" {
+        protocol = negotiated
+    }
+    session, _ := response["session"].(string)
+    resumed, _ := response["resumed"].(bool)
+    received, _ := response["received"].(float64)
+    
+    c.mutex.Lock()
+    select {
+    case <-c.done:
+        c.mutex.Unlock()
+        conn.Close()
+        return errClosed
+    default:
+    }
+    
+    // all writes after the handshake go through the writer, handleRequest
+    // runs one goroutine per request
+    c.conn = conn
+    c.writer = wire.NewWriter(conn, nil)
+    c.protocol = protocol
+    c.session = session
+    
+    // a new session means the server forgot the streams of the old one
+    var lost *wire.Link
+    if !resumed || c.link == nil {
+        lost = c.link
+        c.link = wire.NewLink(protocol)
+    }
+    writer, link, draining := c.writer, c.link, c.draining
+    c.mutex.Unlock()
+    
+    if lost != nil {
+        lost.Close()
+        c.dropStreams()
+    }
+    
+    // stream frames the server missed go out before anything new
+    if err := link.Attach(writer, uint64(received)); err != nil {
+        c.mutex.Lock()
+        c.session = ""
+        c.mutex.Unlock()
+        writer.Close()
+        conn.Close()
+        return fmt.Errorf("failed to resume session: %v", err)
+    }
+    
+    // a resumed tunnel is routed to again until the server hears otherwise
+    if draining {
+        sendDraining(writer)
     }
     
-    log.Printf("tunnel established for %s.%s", c.subdomain, c.extractDomain())
+    if resumed {
+        log.Printf("tunnel resumed (protocol %s)", protocol)
+    } else if c.kind == KindTCP || c.kind == KindUDP {
+        log.Printf("%s tunnel established on %s:%d (protocol %s)", c.kind, c.extractDomain(), c.remotePort, protocol)
+    } else {
+        log.Printf("tunnel established for %s.%s (protocol %s)", c.subdomain, c.extractDomain(), protocol)
+    }
+    c.setState(StateOnline)
     return nil
 }
 
+// SetToken sets the api token sent when registering, call before Connect
+func (c *Client) SetToken(token string) {
+    c.token = token
+}
+
+// SetTakeover asks the server to hand over a subdomain this token already
+// has connected instead of refusing the registration
+func (c *Client) SetTakeover(takeover bool) {
+    c.takeover = takeover
+}
+
+// SetHTTPPolicy picks what the server does with plain http requests while
+// it serves https: redirect them, serve both, or serve http only
+func (c *Client) SetHTTPPolicy(policy string) {
+    c.httpPolicy = policy
+}
+
+// SetInspector records every http exchange in the inspector, call before Run
+func (c *Client) SetInspector(i *inspector.Inspector) {
+    c.inspector = i
+}
+
+// SetHeartbeat sets how often the server is pinged and how long a pong may
+// take before the connection is considered dead, a zero interval disables
+// pings
+func (c *Client) SetHeartbeat(interval, timeout time.Duration) {
+    c.pingInterval = interval
+    c.pingTimeout = timeout
+}
+
+// RTT is the round trip time of the latest answered heartbeat
+func (c *Client) RTT() time.Duration {
+    return time.Duration(c.rtt.Load())
+}
+
+func (c *Client) Subdomain() string {
+    return c.subdomain
+}
+
+// URL is the public address reported by the server once connected, empty
+// for servers that do not report it
+func (c *Client) URL() string {
+    return c.url
+}
+
+// RemotePort is the public port of a tcp or udp tunnel once connected
+func (c *Client) RemotePort() int {
+    return c.remotePort
+}
+
+// Listen serves the tunnel until the connection drops, use Run to keep
+// reconnecting instead
 func (c *Client) Listen() error {
+    err := c.listen()
+    c.setState(StateOffline)
+    return err
+}
+
+func (c *Client) listen() error {
+    c.mutex.Lock()
+    conn, writer, link := c.conn, c.writer, c.link
+    c.mutex.Unlock()
+    
+    // streams outlive the connection, their frames wait for the session to
+    // resume on the next one
+    defer func() {
+        link.Detach(writer)
+        writer.Close()
+        conn.Close()
+    }()
+    
+    // a server that stops answering pings is treated as gone
+    hb := wire.StartHeartbeat(conn, c.pingInterval, c.pingTimeout, func(d time.Duration) {
+        c.rtt.Store(int64(d))
+    })
+    defer hb.Stop()
+    
     for {
-        var req Request
-        if err := c.conn.ReadJSON(&req); err != nil {
-            return fmt.Errorf("failed to read request: %v", err)
+        hb.Extend()
+        messageType, data, err := conn.ReadMessage()
+        if err != nil {
+            var netErr net.Error
+            if errors.As(err, &netErr) && netErr.Timeout() {
+                return fmt.Errorf("server missed its heartbeat")
+            }
+            return fmt.Errorf("failed to read frame: %v", err)
         }
         
-        // forward request to local server
-        go c.handleRequest(&req)
+        frame, err := wire.DecodeFrame(messageType, data)
+        if err != nil {
+            log.Printf("[CLIENT] Ignoring invalid frame: %v", err)
+            continue
+        }
+        link.Receive(frame)
+        
+        switch frame.Type {
+        case wire.FrameRequest:
+            // forward request to local server
+            s := c.openStream(frame.ID, frame.Trailers, link)
+            if forwarder.IsUpgrade(frame.Headers) {
+                go c.handleUpgrade(frame, s)
+            } else {
+                go c.handleRequest(frame, s)
+            }
+        case wire.FrameOpen:
+            // a public connection was accepted on a tcp tunnel, or a new
+            // peer sent a datagram to a udp tunnel
+            s := c.openStream(frame.ID, nil, link)
+            if c.kind == KindUDP {
+                go c.handleDatagrams(frame, s)
+            } else {
+                go c.handleOpen(frame, s)
+            }
+        case wire.FrameData, wire.FrameEnd:
+            if s := c.getStream(frame.ID); s != nil {
+                if err := s.deliver(frame); err != nil {
+                    log.Printf("[CLIENT] Resetting stream %s: %v", frame.ID, err)
+                }
+            }
+        case wire.FrameWindow:
+            if s := c.getStream(frame.ID); s != nil {
+                s.window.Grant(frame.Credit)
+            }
+        case wire.FrameAck:
+            link.Acknowledge(frame.Ack)
+        case wire.FrameCancel:
+            if s := c.getStream(frame.ID); s != nil {
+                log.Printf("[CLIENT] Request %s cancelled by server", frame.ID)
+                s.cancel()
+            }
+        case wire.FrameError:
+            // the server is dropping this tunnel
+            switch frame.Code {
+            case wire.CodeReplaced:
+                return ErrReplaced
+            case wire.CodeDisconnected:
+                return ErrDisconnected
+            }
+            return fmt.Errorf("tunnel closed by server: %s", frame.Error)
+        case wire.FrameGoingAway:
+            return errGoingAway
+        default:
+            log.Printf("[CLIENT] Ignoring unexpected %s frame", frame.Type)
+        }
     }
 }
 
-func (c *Client) handleRequest(req *Request) {
+func (c *Client) openStream(id string, trailer http.Header, link *wire.Link) *stream {
+    s := newStream(id, trailer, link)
+    c.mutex.Lock()
+    c.streams[id] = s
+    c.mutex.Unlock()
+    return s
+}
+
+func (c *Client) getStream(id string) *stream {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return c.streams[id]
+}
+
+func (c *Client) streamCount() int {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return len(c.streams)
+}
+
+// dropStreams cancels every open stream when their session is gone
+func (c *Client) dropStreams() {
+    c.mutex.Lock()
+    streams := c.streams
+    c.streams = make(map[string]*stream)
+    c.mutex.Unlock()
+    
+    for _, s := range streams {
+        s.cancel()
+    }
+}
+
+func (c *Client) closeStream(s *stream) {
+    c.mutex.Lock()
+    delete(c.streams, s.id)
+    c.mutex.Unlock()
+    
+    close(s.done)
+    s.cancel()
+}
+
+func (c *Client) handleRequest(req *wire.Frame, s *stream) {
+    defer c.closeStream(s)
     log.Printf("[CLIENT] Handling request %s: %s %s", req.ID, req.Method, req.URL)
     
-    resp, err := c.forwarder.Forward(req.Method, req.URL, req.Headers, req.Body)
+    // the inspector sees the bodies as they stream through
+    recording := c.inspector.Record(req.ID, req.Method, req.URL, req.Headers)
+    body := recording.RequestBody(s)
+    
+    resp, err := c.forwarder.Forward(s.ctx, req.Method, req.URL, req.Headers, s.trailer, body)
     if err != nil {
         log.Printf("[CLIENT] Forwarding failed for request %s: %v", req.ID, err)
+        recording.Fail(err)
         // send error response
-        errorResp := &Response{
-            ID:         req.ID,
-            StatusCode: 502,
-            Headers:    map[string]string{"Content-Type": "text/plain"},
-            Body:       []byte(fmt.Sprintf("Bad Gateway: %v", err)),
+        resp = &forwarder.Response{
+            StatusCode: http.StatusBadGateway,
+            Headers:    http.Header{"Content-Type": {"text/plain"}},
+            Body:       io.NopCloser(strings.NewReader(fmt.Sprintf("Bad Gateway: %v", err))),
         }
-        c.sendResponse(errorResp)
-        return
+    } else {
+        log.Printf("[CLIENT] Forwarding successful for request %s: status %d", req.ID, resp.StatusCode)
     }
     
-    log.Printf("[CLIENT] Forwarding successful for request %s: status %d", req.ID, resp.StatusCode)
+    resp.Body = recording.Response(resp.StatusCode, resp.Headers, resp.Body)
+    recording.Finish(c.sendResponse(s, resp))
+}
+
+// sendResponse sends the response headers followed by the body as data
+// frames and the trailers on the end frame, returning what stopped it
+func (c *Client) sendResponse(s *stream, resp *forwarder.Response) error {
+    defer resp.Body.Close()
+    id := s.id
+    log.Printf("[CLIENT] Sending response for request %s: status %d", id, resp.StatusCode)
     
-    // convert forwarder.Response to tunnel.Response
-    tunnelResp := &Response{
-        ID:         req.ID,
-        StatusCode: resp.StatusCode,
-        Headers:    resp.Headers,
-        Body:       resp.Body,
+    if err := c.sendResponseHeader(s, resp); err != nil {
+        log.Printf("[CLIENT] Failed to send response for request %s: %v", id, err)
+        return err
+    }
+    
+    size, err := c.sendData(s, resp.Body)
+    if err != nil {
+        log.Printf("[CLIENT] Failed to relay response body for request %s: %v", id, err)
+        s.write(&wire.Frame{Type: wire.FrameEnd, ID: id, Error: err.Error()})
+        return err
     }
-    c.sendResponse(tunnelResp)
+    
+    if err := s.write(&wire.Frame{Type: wire.FrameEnd, ID: id, Trailers: resp.Trailer}); err != nil {
+        log.Printf("[CLIENT] Failed to send response for request %s: %v", id, err)
+        return err
+    }
+    log.Printf("[CLIENT] Response sent successfully for request %s, body size %d bytes", id, size)
+    return nil
 }
 
-func (c *Client) sendResponse(resp *Response) {
-    // send response back via websocket for proper tunneling
-    log.Printf("[CLIENT] Sending response for request %s: status %d, body size %d bytes", resp.ID, resp.StatusCode, len(resp.Body))
+func (c *Client) sendResponseHeader(s *stream, resp *forwarder.Response) error {
+    // announce trailer names up front, values are only known after the body
+    var declared http.Header
+    for key := range resp.Trailer {
+        if declared == nil {
+            declared = make(http.Header)
+        }
+        declared[key] = nil
+    }
     
-    if err := c.conn.WriteJSON(resp); err != nil {
-        log.Printf("[CLIENT] Failed to send response for request %s: %v", resp.ID, err)
-    } else {
-        log.Printf("[CLIENT] Response sent successfully for request %s", resp.ID)
+    return s.write(&wire.Frame{
+        Type:       wire.FrameResponse,
+        ID:         s.id,
+        StatusCode: resp.StatusCode,
+        Headers:    resp.Headers,
+        Trailers:   declared,
+    })
+}
+
+// sendData sends everything read from r as data frames, as fast as the
+// server grants credit for them. it returns the number of bytes sent and
+// the error that stopped it, nil at EOF.
+func (c *Client) sendData(s *stream, r io.Reader) (int64, error) {
+    var size int64
+    buf := make([]byte, wire.ChunkSize)
+    for {
+        n, err := r.Read(buf)
+        if n > 0 {
+            size += int64(n)
+            if !s.window.Take(s.ctx.Done()) {
+                return size, errStreamClosed
+            }
+            if err := s.write(&wire.Frame{Type: wire.FrameData, ID: s.id, Body: buf[:n]}); err != nil {
+                return size, err
+            }
+        }
+        
+        if err == io.EOF {
+            return size, nil
+        }
+        if err != nil {
+            return size, err
+        }
     }
 }
 
@@ -138,9 +556,53 @@ func (c *Client) extractDomain() string {
     return c.serverURL
 }
 
+// Drain shuts the tunnel down without dropping requests. the server is told
+// to route nothing new here, the streams already open get until the timeout
+// to finish, then the client closes.
+func (c *Client) Drain(timeout time.Duration) error {
+    c.mutex.Lock()
+    c.draining = true
+    writer, online := c.writer, c.state == StateOnline
+    c.mutex.Unlock()
+    
+    if online {
+        sendDraining(writer)
+    }
+    
+    deadline := time.Now().Add(timeout)
+    for c.streamCount() > 0 && time.Now().Before(deadline) {
+        time.Sleep(100 * time.Millisecond)
+    }
+    if n := c.streamCount(); n > 0 {
+        log.Printf("[CLIENT] Drain timeout passed, dropping %d streams", n)
+    }
+    return c.Close()
+}
+
+func sendDraining(writer *wire.Writer) {
+    if err := writer.WriteControlJSON(map[string]interface{}{"type": wire.FrameDraining}); err != nil {
+        log.Printf("[CLIENT] Failed to tell the server we are draining: %v", err)
+    }
+}
+
 func (c *Client) Close() error {
-    if c.conn != nil {
-        return c.conn.Close()
+    c.closeOnce.Do(func() {
+        close(c.done)
+    })
+    c.setState(StateOffline)
+    
+    c.mutex.Lock()
+    conn, writer, link := c.conn, c.writer, c.link
+    c.mutex.Unlock()
+    
+    if link != nil {
+        link.Close()
+    }
+    if writer != nil {
+        writer.Close()
+    }
+    if conn != nil {
+        return conn.Close()
     }
     return nil
 }
\ No newline at end of file
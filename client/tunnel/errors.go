@@ -0,0 +1,28 @@
+package tunnel
+
+import (
+    "errors"
+    "fmt"
+)
+
+// ErrReplaced is returned by Listen when another connection of the same
+// owner took the tunnel over
+var ErrReplaced = errors.New("tunnel taken over by another connection")
+
+// ErrDisconnected is returned by Listen when the server administrator
+// closed the tunnel
+var ErrDisconnected = errors.New("tunnel disconnected by the server administrator")
+
+// RegistrationError is returned by Connect when the server rejects the
+// tunnel, Code tells callers whether trying again can help
+type RegistrationError struct {
+    Code    string
+    Message string
+}
+
+func (e *RegistrationError) Error() string {
+    if e.Code == "" {
+        return fmt.Sprintf("registration failed: %s", e.Message)
+    }
+    return fmt.Sprintf("registration failed: %s (%s)", e.Message, e.Code)
+}
\ No newline at end of file
@@ -0,0 +1,107 @@
+package tunnel
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    
+    "mole/client/forwarder"
+    "mole/internal/wire"
+)
+
+// handleUpgrade relays a protocol switch, typically a websocket handshake,
+// to the local server and then pipes raw bytes both ways
+func (c *Client) handleUpgrade(req *wire.Frame, s *stream) {
+    defer c.closeStream(s)
+    log.Printf("[CLIENT] Handling upgrade %s: %s %s (%s)", req.ID, req.Method, req.URL, req.Headers.Get("Upgrade"))
+    
+    resp, conn, err := c.forwarder.Upgrade(s.ctx, req.Method, req.URL, req.Headers)
+    if err != nil {
+        log.Printf("[CLIENT] Upgrade failed for request %s: %v", req.ID, err)
+        c.sendResponse(s, &forwarder.Response{
+            StatusCode: http.StatusBadGateway,
+            Headers:    http.Header{"Content-Type": {"text/plain"}},
+            Body:       io.NopCloser(strings.NewReader(fmt.Sprintf("Bad Gateway: %v", err))),
+        })
+        return
+    }
+    
+    // the local server refused to switch, relay its answer as is
+    if conn == nil {
+        c.sendResponse(s, resp)
+        return
+    }
+    defer conn.Close()
+    
+    if err := c.sendResponseHeader(s, resp); err != nil {
+        log.Printf("[CLIENT] Failed to send upgrade response for request %s: %v", req.ID, err)
+        return
+    }
+    
+    log.Printf("[CLIENT] Upgraded request %s, piping connection", req.ID)
+    c.pipe(s, conn)
+    log.Printf("[CLIENT] Upgraded connection closed for request %s", req.ID)
+}
+
+// handleOpen dials the local service for a connection accepted on a tcp
+// tunnel and pipes raw bytes both ways
+func (c *Client) handleOpen(f *wire.Frame, s *stream) {
+    defer c.closeStream(s)
+    log.Printf("[CLIENT] Opening connection %s from %s", f.ID, f.Addr)
+    
+    if c.tcpForwarder == nil {
+        s.write(&wire.Frame{Type: wire.FrameEnd, ID: f.ID, Error: "tunnel does not accept raw connections"})
+        return
+    }
+    
+    conn, err := c.tcpForwarder.Dial(s.ctx)
+    if err != nil {
+        log.Printf("[CLIENT] Connection %s failed: %v", f.ID, err)
+        s.write(&wire.Frame{Type: wire.FrameEnd, ID: f.ID, Error: err.Error()})
+        return
+    }
+    defer conn.Close()
+    
+    c.pipe(s, conn)
+    log.Printf("[CLIENT] Connection %s closed", f.ID)
+}
+
+// pipe relays bytes between a local connection and a stream until both
+// directions are done or the stream is cancelled
+func (c *Client) pipe(s *stream, conn net.Conn) {
+    // a cancelled stream unblocks both directions
+    go func() {
+        <-s.ctx.Done()
+        conn.Close()
+    }()
+    
+    // local -> server
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        end := &wire.Frame{Type: wire.FrameEnd, ID: s.id}
+        if _, err := c.sendData(s, conn); err != nil {
+            end.Error = err.Error()
+        }
+        s.write(end)
+    }()
+    
+    // server -> local, half-closing once the server side is done
+    if _, err := io.Copy(conn, s); err != nil {
+        conn.Close()
+    } else {
+        closeWrite(conn)
+    }
+    <-done
+}
+
+func closeWrite(conn net.Conn) {
+    if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+        cw.CloseWrite()
+        return
+    }
+    conn.Close()
+}
\ No newline at end of file
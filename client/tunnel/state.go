@@ -0,0 +1,171 @@
+package tunnel
+
+import (
+    "errors"
+    "log"
+    "math/rand"
+    "time"
+    
+    "mole/internal/wire"
+)
+
+// connection states reported through OnStateChange
+type State int
+
+const (
+    StateConnecting State = iota
+    StateOnline
+    StateReconnecting
+    StateOffline
+)
+
+func (s State) String() string {
+    switch s {
+    case StateConnecting:
+        return "connecting"
+    case StateOnline:
+        return "online"
+    case StateReconnecting:
+        return "reconnecting"
+    default:
+        return "offline"
+    }
+}
+
+// reconnect backoff, doubled after every failed attempt
+const (
+    minBackoff = 500 * time.Millisecond
+    maxBackoff = 30 * time.Second
+)
+
+var (
+    errClosed    = errors.New("client closed")
+    errGoingAway = errors.New("server is going away")
+)
+
+// OnStateChange registers a callback for connection state changes, call
+// before Run
+func (c *Client) OnStateChange(fn func(State)) {
+    c.onState = fn
+}
+
+// State returns the current connection state
+func (c *Client) State() State {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return c.state
+}
+
+// Run connects and serves the tunnel until Close is called or the server
+// refuses it for good. dropped connections are retried with jittered
+// exponential backoff, re-registering the same subdomain or port and
+// resuming the session so the tunnel is kept. requests in flight when the
+// connection dropped carry on over the resumed session, they only fail when
+// the server no longer has it.
+func (c *Client) Run() error {
+    c.setState(StateConnecting)
+    
+    online := false
+    attempt := 0
+    for {
+        err := c.Connect()
+        if err == nil {
+            online = true
+            attempt = 0
+            err = c.listen()
+        }
+        
+        select {
+        case <-c.done:
+            return nil
+        default:
+        }
+        if !retryable(err, online) {
+            c.setState(StateOffline)
+            return err
+        }
+        if errors.Is(err, errGoingAway) {
+            // the server finished our requests and took the session with
+            // it, register from scratch as soon as one is back
+            c.mutex.Lock()
+            c.session = ""
+            c.mutex.Unlock()
+            attempt = 0
+        }
+        
+        delay := backoff(attempt)
+        attempt++
+        log.Printf("[CLIENT] %v, reconnecting in %s", err, delay.Round(time.Millisecond))
+        c.setState(StateReconnecting)
+        
+        select {
+        case <-time.After(delay):
+        case <-c.done:
+            return nil
+        }
+    }
+}
+
+// retryable reports whether reconnecting can get past err. a subdomain in
+// use after having been online is most likely our own dropped connection
+// the server has not noticed yet.
+func retryable(err error, online bool) bool {
+    if errors.Is(err, ErrReplaced) || errors.Is(err, ErrDisconnected) {
+        return false
+    }
+    
+    var regErr *RegistrationError
+    if errors.As(err, &regErr) {
+        switch regErr.Code {
+        case wire.CodeUnavailable, "":
+            return true
+        case wire.CodeInUse:
+            return online
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// backoff returns the delay before the given reconnect attempt, randomized
+// over the upper half so clients dropped together do not return together
+func backoff(attempt int) time.Duration {
+    d := maxBackoff
+    if attempt < 16 {
+        d = minBackoff << attempt
+        if d > maxBackoff {
+            d = maxBackoff
+        }
+    }
+    return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (c *Client) setState(state State) {
+    c.mutex.Lock()
+    if c.state == state {
+        c.mutex.Unlock()
+        return
+    }
+    
+    // closed clients stay offline
+    select {
+    case <-c.done:
+        state = StateOffline
+    default:
+    }
+    
+    c.state = state
+    fn := c.onState
+    c.mutex.Unlock()
+    
+    if fn != nil {
+        fn(state)
+    }
+}
+
+func (c *Client) currentSession() (string, *wire.Link) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return c.session, c.link
+}
\ No newline at end of file
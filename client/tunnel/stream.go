@@ -0,0 +1,127 @@
+package tunnel
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    
+    "mole/internal/wire"
+)
+
+// frames buffered per stream, the server's window of data frames plus its
+// end frame
+const streamBufferSize = wire.StreamWindow + 1
+
+var (
+    errStreamClosed   = errors.New("stream closed")
+    errWindowExceeded = errors.New("server sent past its window")
+)
+
+// one request exchange multiplexed over the tunnel. it reads as the request
+// body, fed by the data frames the server sends for the same id. streams
+// belong to the session's link and carry on when it resumes on a new
+// connection.
+type stream struct {
+    id      string
+    trailer http.Header
+    frames  chan *wire.Frame
+    done    chan struct{}
+    ctx     context.Context
+    cancel  context.CancelFunc
+    buf     []byte
+    err     error
+    
+    // credit for the data frames sent to the server, and the data frames
+    // consumed here that are granted back to it
+    window *wire.Window
+    credit wire.Credit
+    
+    link *wire.Link
+}
+
+func newStream(id string, trailer http.Header, link *wire.Link) *stream {
+    ctx, cancel := context.WithCancel(context.Background())
+    return &stream{
+        id:      id,
+        trailer: trailer,
+        frames:  make(chan *wire.Frame, streamBufferSize),
+        done:    make(chan struct{}),
+        ctx:     ctx,
+        cancel:  cancel,
+        window:  wire.NewWindow(),
+        link:    link,
+    }
+}
+
+// write sends a frame on the stream's link. while the client reconnects it
+// waits for the resumed connection, it fails once the session is lost.
+func (s *stream) write(f *wire.Frame) error {
+    return s.link.Send(f)
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+    for len(s.buf) == 0 {
+        if s.err != nil {
+            return 0, s.err
+        }
+        
+        f, err := s.next()
+        if err != nil {
+            s.err = err
+            continue
+        }
+        
+        switch f.Type {
+        case wire.FrameData:
+            s.buf = f.Body
+        case wire.FrameEnd:
+            s.err = io.EOF
+            if f.Error != "" {
+                s.err = errors.New(f.Error)
+            }
+            // the http client reads trailer values once the body hits EOF
+            for key, values := range f.Trailers {
+                if s.trailer != nil {
+                    s.trailer[key] = values
+                }
+            }
+        }
+    }
+    
+    n := copy(p, s.buf)
+    s.buf = s.buf[n:]
+    return n, nil
+}
+
+// next waits for the stream's next frame, for callers that need frame
+// boundaries such as udp datagrams. the server gets its data frames back as
+// credit in batches.
+func (s *stream) next() (*wire.Frame, error) {
+    select {
+    case f := <-s.frames:
+        if f.Type == wire.FrameData {
+            if n := s.credit.Consume(); n > 0 {
+                s.write(&wire.Frame{Type: wire.FrameWindow, ID: s.id, Credit: n})
+            }
+        }
+        return f, nil
+    case <-s.ctx.Done():
+        return nil, errStreamClosed
+    }
+}
+
+// deliver queues a frame for the stream without blocking the read loop. the
+// buffer holds the server's whole window, a server that sends past it gets
+// the stream reset.
+func (s *stream) deliver(f *wire.Frame) error {
+    select {
+    case s.frames <- f:
+        return nil
+    case <-s.done:
+        return nil
+    default:
+        s.cancel()
+        return errWindowExceeded
+    }
+}
\ No newline at end of file
@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+    "errors"
+    "log"
+    "net"
+    
+    "mole/internal/wire"
+)
+
+// largest payload a udp datagram can carry
+const maxDatagramSize = 65535
+
+// handleDatagrams relays a udp peer session through its own local socket,
+// one datagram per data frame, until the server ends the session
+func (c *Client) handleDatagrams(f *wire.Frame, s *stream) {
+    defer c.closeStream(s)
+    log.Printf("[CLIENT] Opening udp session %s for %s", f.ID, f.Addr)
+    
+    if c.udpForwarder == nil {
+        s.write(&wire.Frame{Type: wire.FrameEnd, ID: f.ID, Error: "tunnel does not accept datagrams"})
+        return
+    }
+    
+    conn, err := c.udpForwarder.Dial(s.ctx)
+    if err != nil {
+        log.Printf("[CLIENT] Udp session %s failed: %v", f.ID, err)
+        s.write(&wire.Frame{Type: wire.FrameEnd, ID: f.ID, Error: err.Error()})
+        return
+    }
+    defer conn.Close()
+    
+    // local service -> server
+    go c.readDatagrams(s, conn)
+    
+    // server -> local service
+    for {
+        frame, err := s.next()
+        if err != nil {
+            break
+        }
+        if frame.Type == wire.FrameEnd {
+            break
+        }
+        if _, err := conn.Write(frame.Body); err != nil {
+            log.Printf("[CLIENT] Failed to write datagram for session %s: %v", f.ID, err)
+        }
+    }
+    log.Printf("[CLIENT] Udp session %s closed", f.ID)
+}
+
+func (c *Client) readDatagrams(s *stream, conn net.Conn) {
+    buf := make([]byte, maxDatagramSize)
+    for {
+        n, err := conn.Read(buf)
+        if err != nil {
+            // closed with the session
+            if errors.Is(err, net.ErrClosed) {
+                return
+            }
+            // the local port refused the last datagram, keep the session open
+            log.Printf("[CLIENT] Udp session %s read failed: %v", s.id, err)
+            continue
+        }
+        
+        // datagrams the server has no credit for are dropped, like on a slow link
+        if !s.window.TryTake() {
+            continue
+        }
+        if err := s.write(&wire.Frame{Type: wire.FrameData, ID: s.id, Body: buf[:n]}); err != nil {
+            return
+        }
+    }
+}
\ No newline at end of file
@@ -3,6 +3,13 @@
 # start crond in background
 crond -b
 
+# the server obtains its own certificates when acme is enabled
+if [ "$MOLE_ACME" = "true" ]; then
+    mkdir -p /var/log
+    echo "Starting mole server with built-in acme, logging to /var/log/mole.log"
+    exec "./mole-server" --port 443 2>&1 | tee /var/log/mole.log
+fi
+
 # automatically set certificate paths based on domain
 if [ "$MOLE_USE_HTTPS" = "true" ]; then
     export MOLE_CERT_FILE="/etc/letsencrypt/live/$MOLE_DOMAIN/fullchain.pem"
@@ -50,13 +57,20 @@ fi
 
 # setup certificate renewal cron job
 if [ "$MOLE_USE_HTTPS" = "true" ]; then
-    echo "0 12 * * * /usr/bin/certbot renew --quiet && echo 'certificates renewed'" > /var/spool/cron/crontabs/root
+    # the server also polls the files, the hook just makes the swap immediate
+    echo "0 12 * * * /usr/bin/certbot renew --quiet --deploy-hook 'pkill -HUP mole-server' && echo 'certificates renewed'" > /var/spool/cron/crontabs/root
     echo "certificate renewal cron job added"
 fi
 
 # create log directory
 mkdir -p /var/log
 
+# https takes 443 so plain http stays on 80
+PORT=80
+if [ "$MOLE_USE_HTTPS" = "true" ]; then
+    PORT=443
+fi
+
 # start the mole server with logging
 echo "Starting mole server with logging to /var/log/mole.log"
-exec "./mole-server" --port 80 2>&1 | tee /var/log/mole.log
\ No newline at end of file
+exec "./mole-server" --port $PORT 2>&1 | tee /var/log/mole.log
\ No newline at end of file
@@ -2,6 +2,11 @@ module mole
 
 go 1.21
 
-require github.com/gorilla/websocket v1.5.0
+require (
+	github.com/gorilla/websocket v1.5.0
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.21.0
+	golang.org/x/net v0.21.0
+)
 
-require github.com/joho/godotenv v1.5.1 // indirect
+require golang.org/x/text v0.14.0 // indirect
@@ -0,0 +1,272 @@
+package metrics
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Default is the registry the program's packages add their metrics to
+var Default = NewRegistry()
+
+// DefaultBuckets are latency buckets in seconds, from 5ms to 30s
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// a metric family written in the prometheus text format
+type metric interface {
+    write(w io.Writer)
+}
+
+// Registry holds metrics and serves them to prometheus in its text
+// exposition format, in the order they were added
+type Registry struct {
+    mutex   sync.Mutex
+    metrics []metric
+}
+
+func NewRegistry() *Registry {
+    return &Registry{}
+}
+
+// Counter adds a counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+    c := &Counter{desc: desc{name: name, help: help, labels: labels}, values: make(map[string]*series)}
+    r.add(c)
+    return c
+}
+
+// Histogram adds a histogram with the given upper bounds and label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+    h := &Histogram{desc: desc{name: name, help: help, labels: labels}, buckets: buckets, values: make(map[string]*histogramSeries)}
+    r.add(h)
+    return h
+}
+
+// GaugeFunc adds a gauge whose samples are collected on every scrape,
+// for values kept elsewhere such as the open tunnels
+func (r *Registry) GaugeFunc(name, help string, labels []string, collect func() []Sample) {
+    r.add(&gaugeFunc{desc: desc{name: name, help: help, labels: labels}, collect: collect})
+}
+
+func (r *Registry) add(m metric) {
+    r.mutex.Lock()
+    r.metrics = append(r.metrics, m)
+    r.mutex.Unlock()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+    r.mutex.Lock()
+    metrics := append([]metric(nil), r.metrics...)
+    r.mutex.Unlock()
+    
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    for _, m := range metrics {
+        m.write(w)
+    }
+}
+
+// Sample is one value of a gauge, with a value for each label
+type Sample struct {
+    Labels []string
+    Value  float64
+}
+
+type desc struct {
+    name   string
+    help   string
+    labels []string
+}
+
+func (d *desc) header(w io.Writer, kind string) {
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+}
+
+// labelPairs formats label names and values as name="value",... with the
+// values escaped
+func (d *desc) labelPairs(values []string) string {
+    pairs := make([]string, 0, len(d.labels))
+    for i, name := range d.labels {
+        value := ""
+        if i < len(values) {
+            value = values[i]
+        }
+        pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(value)))
+    }
+    return strings.Join(pairs, ",")
+}
+
+// label values escape only backslashes, quotes and newlines
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// seriesKey identifies the series of a set of label values
+func seriesKey(values []string) string {
+    return strings.Join(values, "\xff")
+}
+
+type series struct {
+    labels []string
+    value  float64
+}
+
+// Counter is a value that only goes up, one series per set of label values
+type Counter struct {
+    desc
+    mutex  sync.Mutex
+    values map[string]*series
+}
+
+// Inc adds one to the series with the given label values
+func (c *Counter) Inc(labels ...string) {
+    c.Add(1, labels...)
+}
+
+// Add adds v to the series with the given label values
+func (c *Counter) Add(v float64, labels ...string) {
+    key := seriesKey(labels)
+    c.mutex.Lock()
+    s, ok := c.values[key]
+    if !ok {
+        s = &series{labels: labels}
+        c.values[key] = s
+    }
+    s.value += v
+    c.mutex.Unlock()
+}
+
+// Delete drops every series whose label values start with the given ones,
+// for labels naming things that come and go such as tunnels
+func (c *Counter) Delete(labels ...string) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    for key, s := range c.values {
+        if hasPrefix(s.labels, labels) {
+            delete(c.values, key)
+        }
+    }
+}
+
+func hasPrefix(values, prefix []string) bool {
+    if len(values) < len(prefix) {
+        return false
+    }
+    for i := range prefix {
+        if values[i] != prefix[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func (c *Counter) write(w io.Writer) {
+    c.header(w, "counter")
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    
+    // a counter without labels is shown at zero before its first increment
+    if len(c.labels) == 0 && len(c.values) == 0 {
+        fmt.Fprintf(w, "%s 0\n", c.name)
+    }
+    keys := make([]string, 0, len(c.values))
+    for key := range c.values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        s := c.values[key]
+        writeSample(w, c.name, c.labelPairs(s.labels), s.value)
+    }
+}
+
+type histogramSeries struct {
+    labels []string
+    counts []uint64
+    sum    float64
+    count  uint64
+}
+
+// Histogram counts observations in buckets, one series per set of label
+// values
+type Histogram struct {
+    desc
+    buckets []float64
+    mutex   sync.Mutex
+    values  map[string]*histogramSeries
+}
+
+// Observe records v in the series with the given label values
+func (h *Histogram) Observe(v float64, labels ...string) {
+    key := seriesKey(labels)
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    
+    s, ok := h.values[key]
+    if !ok {
+        s = &histogramSeries{labels: labels, counts: make([]uint64, len(h.buckets))}
+        h.values[key] = s
+    }
+    for i, bound := range h.buckets {
+        if v <= bound {
+            s.counts[i]++
+        }
+    }
+    s.sum += v
+    s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+    h.header(w, "histogram")
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    
+    keys := make([]string, 0, len(h.values))
+    for key := range h.values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        s := h.values[key]
+        pairs := h.labelPairs(s.labels)
+        prefix := pairs
+        if prefix != "" {
+            prefix += ","
+        }
+        for i, bound := range h.buckets {
+            writeSample(w, h.name+"_bucket", prefix+`le="`+formatFloat(bound)+`"`, float64(s.counts[i]))
+        }
+        writeSample(w, h.name+"_bucket", prefix+`le="+Inf"`, float64(s.count))
+        writeSample(w, h.name+"_sum", pairs, s.sum)
+        writeSample(w, h.name+"_count", pairs, float64(s.count))
+    }
+}
+
+type gaugeFunc struct {
+    desc
+    collect func() []Sample
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+    g.header(w, "gauge")
+    for _, sample := range g.collect() {
+        writeSample(w, g.name, g.labelPairs(sample.Labels), sample.Value)
+    }
+}
+
+func writeSample(w io.Writer, name, labels string, value float64) {
+    if labels == "" {
+        fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+        return
+    }
+    fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(value))
+}
+
+func formatFloat(v float64) string {
+    if math.IsInf(v, 1) {
+        return "+Inf"
+    }
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
\ No newline at end of file
@@ -0,0 +1,172 @@
+package wire
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    
+    "github.com/gorilla/websocket"
+)
+
+// wire protocols negotiated in the register handshake
+const (
+    ProtocolJSON   = "json"
+    ProtocolBinary = "binary.v1"
+)
+
+// binary frame layout, all integers big endian:
+//
+//	version  uint8
+//	type     uint8
+//	flags    uint8
+//	id len   uint8
+//	id       [id len]byte
+//	length   uint32
+//	payload  [length]byte
+const (
+    binaryVersion    = 1
+    binaryHeaderSize = 4
+    maxPayloadSize   = 16 << 20
+)
+
+// frame flags
+const (
+    // payload holds the json encoded frame fields instead of raw body bytes
+    flagMeta = 1 << 0
+)
+
+var frameTypeCodes = map[string]byte{
+    FrameRequest:  1,
+    FrameResponse: 2,
+    FrameData:     3,
+    FrameEnd:      4,
+    FrameCancel:   5,
+    FrameOpen:     6,
+    FrameWindow:   7,
+}
+
+var frameTypeNames = func() map[byte]string {
+    names := make(map[byte]string, len(frameTypeCodes))
+    for name, code := range frameTypeCodes {
+        names[code] = name
+    }
+    return names
+}()
+
+var errShortFrame = errors.New("short binary frame")
+
+// SupportedProtocols are offered by clients in the register message, most
+// preferred first
+var SupportedProtocols = []string{ProtocolBinary, ProtocolJSON}
+
+// NegotiateProtocol picks the first protocol offered by the client that the
+// server understands, or returns "" when there is none. clients from before
+// frames were introduced offer nothing and get json, in the single message
+// requests of LegacyRequest.
+func NegotiateProtocol(offered []string) string {
+    if len(offered) == 0 {
+        return ProtocolJSON
+    }
+    for _, p := range offered {
+        if p == ProtocolBinary || p == ProtocolJSON {
+            return p
+        }
+    }
+    return ""
+}
+
+func EncodeFrame(protocol string, f *Frame) (int, []byte, error) {
+    if protocol != ProtocolBinary {
+        data, err := json.Marshal(f)
+        return websocket.TextMessage, data, err
+    }
+    
+    data, err := encodeBinary(f)
+    return websocket.BinaryMessage, data, err
+}
+
+// DecodeFrame accepts both encodings, the websocket message type tells them apart
+func DecodeFrame(messageType int, data []byte) (*Frame, error) {
+    if messageType == websocket.BinaryMessage {
+        return decodeBinary(data)
+    }
+    
+    var f Frame
+    if err := json.Unmarshal(data, &f); err != nil {
+        return nil, err
+    }
+    return &f, nil
+}
+
+func encodeBinary(f *Frame) ([]byte, error) {
+    code, ok := frameTypeCodes[f.Type]
+    if !ok {
+        return nil, fmt.Errorf("unknown frame type: %s", f.Type)
+    }
+    if len(f.ID) > 255 {
+        return nil, fmt.Errorf("frame id too long: %d bytes", len(f.ID))
+    }
+    
+    // data frames carry the raw body, every other frame its json fields
+    var flags byte
+    payload := f.Body
+    if f.Type != FrameData {
+        meta := *f
+        meta.Body = nil
+        var err error
+        if payload, err = json.Marshal(&meta); err != nil {
+            return nil, err
+        }
+        flags |= flagMeta
+    }
+    if len(payload) > maxPayloadSize {
+        return nil, fmt.Errorf("frame payload too large: %d bytes", len(payload))
+    }
+    
+    buf := make([]byte, 0, binaryHeaderSize+len(f.ID)+4+len(payload))
+    buf = append(buf, binaryVersion, code, flags, byte(len(f.ID)))
+    buf = append(buf, f.ID...)
+    buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+    buf = append(buf, payload...)
+    return buf, nil
+}
+
+func decodeBinary(data []byte) (*Frame, error) {
+    if len(data) < binaryHeaderSize {
+        return nil, errShortFrame
+    }
+    if data[0] != binaryVersion {
+        return nil, fmt.Errorf("unsupported frame version: %d", data[0])
+    }
+    
+    name, ok := frameTypeNames[data[1]]
+    if !ok {
+        return nil, fmt.Errorf("unknown frame type code: %d", data[1])
+    }
+    flags := data[2]
+    idLen := int(data[3])
+    data = data[binaryHeaderSize:]
+    
+    if len(data) < idLen+4 {
+        return nil, errShortFrame
+    }
+    id := string(data[:idLen])
+    length := binary.BigEndian.Uint32(data[idLen : idLen+4])
+    payload := data[idLen+4:]
+    if uint32(len(payload)) != length {
+        return nil, fmt.Errorf("frame length mismatch: header says %d, got %d", length, len(payload))
+    }
+    
+    f := &Frame{}
+    if flags&flagMeta != 0 {
+        if err := json.Unmarshal(payload, f); err != nil {
+            return nil, err
+        }
+    } else {
+        f.Body = payload
+    }
+    f.Type = name
+    f.ID = id
+    return f, nil
+}
\ No newline at end of file
@@ -0,0 +1,191 @@
+package wire
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "strings"
+    "testing"
+    
+    "github.com/gorilla/websocket"
+)
+
+var testFrames = []*Frame{
+    {
+        Type:     FrameRequest,
+        ID:       "req-1",
+        Method:   "POST",
+        URL:      "/upload?x=1",
+        Headers:  http.Header{"Content-Type": {"application/json"}, "X-Multi": {"a", "b"}},
+        Trailers: http.Header{"X-Checksum": nil},
+    },
+    {Type: FrameResponse, ID: "req-1", StatusCode: 201, Headers: http.Header{"Set-Cookie": {"a=1", "b=2"}}},
+    {Type: FrameData, ID: "req-1", Body: []byte{0, 1, 2, 0xff, '"', '\n'}},
+    {Type: FrameData, ID: "req-1", Body: bytes.Repeat([]byte("x"), ChunkSize)},
+    {Type: FrameEnd, ID: "req-1", Trailers: http.Header{"X-Checksum": {"abc"}}},
+    {Type: FrameEnd, ID: "req-1", Error: "local service reset the connection"},
+    {Type: FrameCancel, ID: "req-1"},
+    {Type: FrameOpen, ID: "conn-1", Addr: "203.0.113.7:5000"},
+    {Type: FrameWindow, ID: "conn-1", Credit: windowBatch},
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+    for _, protocol := range []string{ProtocolBinary, ProtocolJSON} {
+        for _, f := range testFrames {
+            messageType, data, err := EncodeFrame(protocol, f)
+            if err != nil {
+                t.Fatalf("%s: encoding %s frame: %v", protocol, f.Type, err)
+            }
+            wantType := websocket.TextMessage
+            if protocol == ProtocolBinary {
+                wantType = websocket.BinaryMessage
+            }
+            if messageType != wantType {
+                t.Errorf("%s: %s frame sent as message type %d", protocol, f.Type, messageType)
+            }
+            
+            got, err := DecodeFrame(messageType, data)
+            if err != nil {
+                t.Fatalf("%s: decoding %s frame: %v", protocol, f.Type, err)
+            }
+            if !reflect.DeepEqual(got, f) {
+                t.Errorf("%s: round trip changed the frame\n got %+v\nwant %+v", protocol, got, f)
+            }
+        }
+    }
+}
+
+func TestBinaryLayout(t *testing.T) {
+    body := []byte("raw body")
+    _, data, err := EncodeFrame(ProtocolBinary, &Frame{Type: FrameData, ID: "abc", Body: body})
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    // data frames carry the body as is, not base64 inside json
+    want := []byte{binaryVersion, frameTypeCodes[FrameData], 0, 3, 'a', 'b', 'c'}
+    want = binary.BigEndian.AppendUint32(want, uint32(len(body)))
+    want = append(want, body...)
+    if !bytes.Equal(data, want) {
+        t.Fatalf("data frame encoded as %v, want %v", data, want)
+    }
+    
+    // every other frame carries its fields as json
+    _, data, err = EncodeFrame(ProtocolBinary, &Frame{Type: FrameEnd, ID: "abc", Error: "boom"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if data[2]&flagMeta == 0 || !json.Valid(data[binaryHeaderSize+3+4:]) {
+        t.Fatalf("end frame payload is not flagged json: %v", data)
+    }
+}
+
+func TestDecodeBinaryRejectsBrokenFrames(t *testing.T) {
+    _, valid, err := EncodeFrame(ProtocolBinary, &Frame{Type: FrameData, ID: "abc", Body: []byte("hello")})
+    if err != nil {
+        t.Fatal(err)
+    }
+    with := func(i int, b byte) []byte {
+        data := append([]byte(nil), valid...)
+        data[i] = b
+        return data
+    }
+    
+    tests := []struct {
+        name string
+        data []byte
+    }{
+        {"empty", nil},
+        {"short header", valid[:binaryHeaderSize-1]},
+        {"truncated id", valid[:binaryHeaderSize+2]},
+        {"missing length", valid[:binaryHeaderSize+3+2]},
+        {"truncated payload", valid[:len(valid)-1]},
+        {"trailing bytes", append(append([]byte(nil), valid...), 0)},
+        {"version 0", with(0, 0)},
+        {"future version", with(0, binaryVersion+1)},
+        {"unknown type", with(1, 0xee)},
+        {"id longer than frame", with(3, 200)},
+        {"meta flag on raw body", with(2, flagMeta)},
+    }
+    for _, tt := range tests {
+        if f, err := DecodeFrame(websocket.BinaryMessage, tt.data); err == nil {
+            t.Errorf("%s: decoded %+v, want an error", tt.name, f)
+        }
+    }
+    
+    if _, err := DecodeFrame(websocket.TextMessage, []byte(`{"type":"data"`)); err == nil {
+        t.Error("truncated json frame decoded")
+    }
+}
+
+func TestEncodeBinaryRejects(t *testing.T) {
+    // control messages are always json, they have no binary type code
+    if _, _, err := EncodeFrame(ProtocolBinary, &Frame{Type: FrameDraining}); err == nil {
+        t.Error("control message encoded as a binary frame")
+    }
+    if _, _, err := EncodeFrame(ProtocolBinary, &Frame{Type: FrameData, ID: strings.Repeat("x", 256)}); err == nil {
+        t.Error("frame id over 255 bytes encoded")
+    }
+    if _, _, err := EncodeFrame(ProtocolBinary, &Frame{Type: FrameData, ID: "x", Body: make([]byte, maxPayloadSize+1)}); err == nil {
+        t.Error("oversized payload encoded")
+    }
+}
+
+func TestNegotiateProtocol(t *testing.T) {
+    tests := []struct {
+        offered []string
+        want    string
+    }{
+        // clients from before negotiation offer nothing
+        {nil, ProtocolJSON},
+        {[]string{}, ProtocolJSON},
+        {SupportedProtocols, ProtocolBinary},
+        {[]string{ProtocolJSON, ProtocolBinary}, ProtocolJSON},
+        {[]string{"binary.v2", ProtocolJSON}, ProtocolJSON},
+        {[]string{"binary.v2"}, ""},
+    }
+    for _, tt := range tests {
+        if got := NegotiateProtocol(tt.offered); got != tt.want {
+            t.Errorf("NegotiateProtocol(%q) = %q, want %q", tt.offered, got, tt.want)
+        }
+    }
+}
+
+func TestLegacyMessages(t *testing.T) {
+    req := NewLegacyRequest("id1", "POST", "/x", http.Header{"X-Multi": {"a", "b"}, "Empty": {}}, []byte("body"))
+    data, err := json.Marshal(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the shape clients from before frames decode, base64 body included
+    want := `{"id":"id1","method":"POST","url":"/x","headers":{"X-Multi":"a"},"body":"Ym9keQ=="}`
+    if string(data) != want {
+        t.Fatalf("legacy request is %s, want %s", data, want)
+    }
+    
+    frames, err := DecodeLegacyResponse([]byte(`{"id":"id1","status_code":200,"headers":{"content-type":"text/plain"},"body":"aGk="}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    wantFrames := []*Frame{
+        {Type: FrameResponse, ID: "id1", StatusCode: 200, Headers: http.Header{"Content-Type": {"text/plain"}}},
+        {Type: FrameData, ID: "id1", Body: []byte("hi")},
+        {Type: FrameEnd, ID: "id1"},
+    }
+    if !reflect.DeepEqual(frames, wantFrames) {
+        t.Fatalf("legacy response decoded to %+v", frames)
+    }
+    
+    frames, err = DecodeLegacyResponse([]byte(`{"id":"id2","status_code":204,"headers":{}}`))
+    if err != nil || len(frames) != 2 {
+        t.Fatalf("empty legacy response decoded to %d frames: %v", len(frames), err)
+    }
+    
+    for _, bad := range []string{`{"id":"id3"}`, `{"status_code":200}`, `not json`} {
+        if _, err := DecodeLegacyResponse([]byte(bad)); err == nil {
+            t.Errorf("legacy response %s decoded", bad)
+        }
+    }
+}
\ No newline at end of file
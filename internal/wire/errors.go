@@ -0,0 +1,22 @@
+package wire
+
+// error codes sent to clients when registration is rejected or the tunnel
+// is closed by the server
+const (
+    CodeUnauthorized   = "unauthorized"
+    CodeInvalidRequest = "invalid_request"
+    CodeUnavailable    = "unavailable"
+    CodeReserved       = "reserved"
+    CodeInUse          = "in_use"
+    CodeReplaced       = "replaced"
+    CodeDisconnected   = "disconnected"
+    
+    // the client speaks none of the server's wire protocols
+    CodeUnsupportedVersion = "unsupported_version"
+    
+    // subdomain policy violations
+    CodeInvalidSubdomain = "invalid_subdomain"
+    CodeSubdomainTooLong = "subdomain_too_long"
+    CodeBlocked          = "blocked"
+    CodeNotAllowed       = "not_allowed"
+)
\ No newline at end of file
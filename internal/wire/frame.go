@@ -0,0 +1,62 @@
+package wire
+
+import "net/http"
+
+// frame types exchanged over the tunnel websocket. an exchange is a request
+// frame followed by data frames and an end frame, answered by a response
+// frame followed by data frames and an end frame with the same id. raw
+// connections start with an open frame and carry data frames both ways.
+// window frames grant the other side credit to send more data frames.
+const (
+    FrameRequest  = "request"
+    FrameResponse = "response"
+    FrameData     = "data"
+    FrameEnd      = "end"
+    FrameCancel   = "cancel"
+    FrameOpen     = "open"
+    FrameWindow   = "window"
+    
+    // control message telling the client why the server dropped it, always json
+    FrameError = "error"
+    
+    // control message from a client that is shutting down, no new requests
+    // or connections are routed to it while it finishes the open ones
+    FrameDraining = "draining"
+    
+    // control message sent before the server shuts down, the session ends
+    // with it so the client registers again instead of resuming
+    FrameGoingAway = "going_away"
+    
+    // control message acknowledging the stream frames received so far, the
+    // sender stops keeping them for a replay
+    FrameAck = "ack"
+)
+
+// size of the body chunks carried by data frames
+const ChunkSize = 32 * 1024
+
+// headers keep every value of repeated fields in order. trailers on request
+// and response frames only declare the trailer names, the values follow on
+// the end frame once the body is done.
+type Frame struct {
+    Type       string      `json:"type"`
+    ID         string      `json:"id"`
+    Method     string      `json:"method,omitempty"`
+    URL        string      `json:"url,omitempty"`
+    Addr       string      `json:"addr,omitempty"`
+    Headers    http.Header `json:"headers,omitempty"`
+    Trailers   http.Header `json:"trailers,omitempty"`
+    StatusCode int         `json:"status_code,omitempty"`
+    Body       []byte      `json:"body,omitempty"`
+    Error      string      `json:"error,omitempty"`
+    Code       string      `json:"code,omitempty"`
+    Credit     int         `json:"credit,omitempty"`
+    Ack        uint64      `json:"ack,omitempty"`
+}
+
+// Sequenced reports whether frames of a type belong to a stream and are
+// numbered by the link, control messages are not
+func Sequenced(frameType string) bool {
+    _, ok := frameTypeCodes[frameType]
+    return ok
+}
\ No newline at end of file
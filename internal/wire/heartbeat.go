@@ -0,0 +1,79 @@
+package wire
+
+import (
+    "strconv"
+    "sync"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// heartbeat pings the other end every interval. reads on the connection
+// fail once nothing, not even a pong, has arrived for interval plus
+// timeout, which is how half-open connections are detected.
+type Heartbeat struct {
+    conn     *websocket.Conn
+    interval time.Duration
+    timeout  time.Duration
+    rtt      func(time.Duration)
+    done     chan struct{}
+    stopOnce sync.Once
+}
+
+// StartHeartbeat starts pinging conn, a zero interval disables it. rtt is
+// called with the round trip time of every answered ping.
+func StartHeartbeat(conn *websocket.Conn, interval, timeout time.Duration, rtt func(time.Duration)) *Heartbeat {
+    h := &Heartbeat{
+        conn:     conn,
+        interval: interval,
+        timeout:  timeout,
+        rtt:      rtt,
+        done:     make(chan struct{}),
+    }
+    if interval <= 0 {
+        return h
+    }
+    
+    // pings carry the time they were sent, echoed back in the pong
+    conn.SetPongHandler(func(data string) error {
+        if sent, err := strconv.ParseInt(data, 10, 64); err == nil && h.rtt != nil {
+            h.rtt(time.Duration(time.Now().UnixNano() - sent))
+        }
+        h.Extend()
+        return nil
+    })
+    go h.run()
+    return h
+}
+
+// Extend pushes the read deadline out, called before every read so a busy
+// reader that stopped reading for a while is not mistaken for a dead peer
+func (h *Heartbeat) Extend() {
+    if h.interval > 0 {
+        h.conn.SetReadDeadline(time.Now().Add(h.interval + h.timeout))
+    }
+}
+
+func (h *Heartbeat) run() {
+    ticker := time.NewTicker(h.interval)
+    defer ticker.Stop()
+    
+    for {
+        select {
+        case <-ticker.C:
+            // control frames may be written alongside the connection writer
+            payload := strconv.FormatInt(time.Now().UnixNano(), 10)
+            if err := h.conn.WriteControl(websocket.PingMessage, []byte(payload), time.Now().Add(h.timeout)); err != nil {
+                return
+            }
+        case <-h.done:
+            return
+        }
+    }
+}
+
+func (h *Heartbeat) Stop() {
+    h.stopOnce.Do(func() {
+        close(h.done)
+    })
+}
\ No newline at end of file
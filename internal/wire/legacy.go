@@ -0,0 +1,68 @@
+package wire
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+)
+
+// LegacyRequest is the message clients from before frames were introduced
+// understand: the whole request in one json message, the body base64
+// encoded and a single value per header
+type LegacyRequest struct {
+    ID      string            `json:"id"`
+    Method  string            `json:"method"`
+    URL     string            `json:"url"`
+    Headers map[string]string `json:"headers"`
+    Body    []byte            `json:"body"`
+}
+
+// the answer of such a client, again in one message
+type legacyResponse struct {
+    ID         string            `json:"id"`
+    StatusCode int               `json:"status_code"`
+    Headers    map[string]string `json:"headers"`
+    Body       []byte            `json:"body"`
+}
+
+var errNotLegacyResponse = errors.New("not a response message")
+
+// NewLegacyRequest builds the message for a request, keeping the first
+// value of every header
+func NewLegacyRequest(id, method, url string, headers http.Header, body []byte) *LegacyRequest {
+    req := &LegacyRequest{
+        ID:      id,
+        Method:  method,
+        URL:     url,
+        Headers: make(map[string]string, len(headers)),
+        Body:    body,
+    }
+    for key, values := range headers {
+        if len(values) > 0 {
+            req.Headers[key] = values[0]
+        }
+    }
+    return req
+}
+
+// DecodeLegacyResponse turns the answer of a legacy client into the
+// response, data and end frames a framed client would have sent
+func DecodeLegacyResponse(data []byte) ([]*Frame, error) {
+    var resp legacyResponse
+    if err := json.Unmarshal(data, &resp); err != nil {
+        return nil, err
+    }
+    if resp.ID == "" || resp.StatusCode == 0 {
+        return nil, errNotLegacyResponse
+    }
+    
+    headers := make(http.Header, len(resp.Headers))
+    for key, value := range resp.Headers {
+        headers.Set(key, value)
+    }
+    frames := []*Frame{{Type: FrameResponse, ID: resp.ID, StatusCode: resp.StatusCode, Headers: headers}}
+    if len(resp.Body) > 0 {
+        frames = append(frames, &Frame{Type: FrameData, ID: resp.ID, Body: resp.Body})
+    }
+    return append(frames, &Frame{Type: FrameEnd, ID: resp.ID}), nil
+}
\ No newline at end of file
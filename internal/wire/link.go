@@ -0,0 +1,219 @@
+package wire
+
+import (
+    "errors"
+    "sync"
+)
+
+// stream frames received before they are acknowledged
+const ackInterval = 16
+
+// most bytes kept for a replay. a peer that stops acknowledging cannot grow
+// the log without bound, the session just cannot be resumed past the frames
+// dropped from it.
+const maxLogSize = 32 << 20
+
+var (
+    ErrLinkClosed = errors.New("tunnel session closed")
+    ErrNoReplay   = errors.New("frames to replay are no longer kept")
+)
+
+// a sent stream frame kept until the other side acknowledges it
+type logEntry struct {
+    seq         uint64
+    messageType int
+    data        []byte
+}
+
+// Link carries the stream frames of a tunnel session over its current
+// connection. every frame is numbered and kept until the other side
+// acknowledges it, so when the connection drops and the session resumes on
+// a new one, what the other side missed is sent again and the streams carry
+// on. both sides count the frames they receive and exchange the counts when
+// resuming.
+type Link struct {
+    protocol string
+    
+    // held while a frame is queued, so frames reach the writer in the order
+    // they were numbered
+    sendMutex sync.Mutex
+    
+    mutex    sync.Mutex
+    writer   *Writer
+    attached chan struct{}
+    done     chan struct{}
+    err      error
+    log      []logEntry
+    logSize  int
+    sent     uint64
+    dropped  uint64
+    received uint64
+}
+
+// NewLink creates a link that encodes frames with protocol, it is detached
+// until the first Attach
+func NewLink(protocol string) *Link {
+    return &Link{
+        protocol: protocol,
+        attached: make(chan struct{}),
+        done:     make(chan struct{}),
+    }
+}
+
+// Send numbers a frame and writes it on the current connection. while the
+// link is detached, or when the connection drops under it, it waits for the
+// next connection to replay the frame on and only fails once the link is
+// closed.
+func (l *Link) Send(f *Frame) error {
+    messageType, data, err := EncodeFrame(l.protocol, f)
+    if err != nil {
+        return err
+    }
+    
+    l.sendMutex.Lock()
+    l.mutex.Lock()
+    if l.err != nil {
+        l.mutex.Unlock()
+        l.sendMutex.Unlock()
+        return l.err
+    }
+    l.sent++
+    l.log = append(l.log, logEntry{seq: l.sent, messageType: messageType, data: data})
+    l.logSize += len(data)
+    for l.logSize > maxLogSize && len(l.log) > 1 {
+        l.drop(l.log[0].seq)
+    }
+    writer, attached := l.writer, l.attached
+    l.mutex.Unlock()
+    
+    var msg *outboundMessage
+    if writer != nil {
+        msg, _ = writer.queue(messageType, data)
+    }
+    l.sendMutex.Unlock()
+    
+    if msg != nil && writer.wait(msg) == nil {
+        return nil
+    }
+    select {
+    case <-attached:
+        return nil
+    case <-l.done:
+        return l.err
+    }
+}
+
+// Attach moves the link onto a new connection. the frames the other side
+// has not received, going by the count it reported, are written first.
+func (l *Link) Attach(writer *Writer, peerReceived uint64) error {
+    l.sendMutex.Lock()
+    defer l.sendMutex.Unlock()
+    
+    l.mutex.Lock()
+    if l.err != nil {
+        l.mutex.Unlock()
+        return l.err
+    }
+    if !l.canResume(peerReceived) {
+        l.mutex.Unlock()
+        return ErrNoReplay
+    }
+    l.drop(peerReceived)
+    replay := append([]logEntry(nil), l.log...)
+    l.writer = writer
+    close(l.attached)
+    l.attached = make(chan struct{})
+    l.mutex.Unlock()
+    
+    // a connection lost during the replay is retried by the next attach,
+    // the frames stay in the log until acknowledged
+    for _, entry := range replay {
+        if _, err := writer.queue(entry.messageType, entry.data); err != nil {
+            break
+        }
+    }
+    return nil
+}
+
+// CanResume reports whether the frames the other side is missing are still
+// kept
+func (l *Link) CanResume(peerReceived uint64) bool {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    return l.err == nil && l.canResume(peerReceived)
+}
+
+func (l *Link) canResume(peerReceived uint64) bool {
+    return peerReceived >= l.dropped && peerReceived <= l.sent
+}
+
+// Detach stops writing on a connection that is gone, frames sent until the
+// next Attach wait for it
+func (l *Link) Detach(writer *Writer) {
+    l.mutex.Lock()
+    if l.writer == writer {
+        l.writer = nil
+    }
+    l.mutex.Unlock()
+}
+
+// Receive counts a frame read from the connection. every few stream frames
+// the other side is told how far it got, so it can drop them from its log.
+func (l *Link) Receive(f *Frame) {
+    if !Sequenced(f.Type) {
+        return
+    }
+    
+    l.mutex.Lock()
+    l.received++
+    n, writer := l.received, l.writer
+    l.mutex.Unlock()
+    
+    if n%ackInterval == 0 && writer != nil {
+        go writer.WriteControlJSON(&Frame{Type: FrameAck, Ack: n})
+    }
+}
+
+// Received is the number of stream frames read so far, sent when resuming
+// so the other side knows where to replay from
+func (l *Link) Received() uint64 {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    return l.received
+}
+
+// Acknowledge drops the frames the other side confirmed from the log
+func (l *Link) Acknowledge(n uint64) {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    if n <= l.sent {
+        l.drop(n)
+    }
+}
+
+// drop removes the frames numbered up to seq from the log. callers hold the
+// mutex.
+func (l *Link) drop(seq uint64) {
+    i := 0
+    for i < len(l.log) && l.log[i].seq <= seq {
+        l.logSize -= len(l.log[i].data)
+        l.log[i] = logEntry{}
+        i++
+    }
+    l.log = l.log[i:]
+    if seq > l.dropped {
+        l.dropped = seq
+    }
+}
+
+// Close ends the session, frames waiting for a connection and every frame
+// sent after fail with ErrLinkClosed
+func (l *Link) Close() {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    if l.err == nil {
+        l.err = ErrLinkClosed
+        l.writer = nil
+        close(l.done)
+    }
+}
\ No newline at end of file
@@ -0,0 +1,175 @@
+package wire
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// wsPair returns the two ends of a websocket connection
+func wsPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+    t.Helper()
+    accepted := make(chan *websocket.Conn, 1)
+    upgrader := websocket.Upgrader{}
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        conn, err := upgrader.Upgrade(w, r, nil)
+        if err != nil {
+            t.Error(err)
+            return
+        }
+        accepted <- conn
+    }))
+    t.Cleanup(srv.Close)
+    
+    client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    server := <-accepted
+    t.Cleanup(func() {
+        client.Close()
+        server.Close()
+    })
+    return client, server
+}
+
+// readFrame reads the next frame and counts it on the receiving link
+func readFrame(t *testing.T, conn *websocket.Conn, link *Link) *Frame {
+    t.Helper()
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    messageType, data, err := conn.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    f, err := DecodeFrame(messageType, data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if link != nil {
+        link.Receive(f)
+    }
+    return f
+}
+
+func dataFrame(i int) *Frame {
+    return &Frame{Type: FrameData, ID: "s", Body: []byte(fmt.Sprint(i))}
+}
+
+func TestLinkReplaysAfterDrop(t *testing.T) {
+    for _, protocol := range []string{ProtocolBinary, ProtocolJSON} {
+        t.Run(protocol, func(t *testing.T) {
+            sender, receiver := NewLink(protocol), NewLink(protocol)
+            
+            out, in := wsPair(t)
+            writer := NewWriter(out, nil)
+            if err := sender.Attach(writer, 0); err != nil {
+                t.Fatal(err)
+            }
+            for i := 1; i <= 10; i++ {
+                if err := sender.Send(dataFrame(i)); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            
+            // the receiver only gets to read four before the connection drops
+            for i := 1; i <= 4; i++ {
+                if f := readFrame(t, in, receiver); string(f.Body) != fmt.Sprint(i) {
+                    t.Fatalf("frame %d has body %q", i, f.Body)
+                }
+            }
+            sender.Detach(writer)
+            writer.Close()
+            out.Close()
+            
+            // sends wait while detached and go out with the replay
+            sent := make(chan error, 1)
+            go func() { sent <- sender.Send(dataFrame(11)) }()
+            select {
+            case err := <-sent:
+                t.Fatalf("send returned while detached: %v", err)
+            case <-time.After(50 * time.Millisecond):
+            }
+            
+            out, in = wsPair(t)
+            if err := sender.Attach(NewWriter(out, nil), receiver.Received()); err != nil {
+                t.Fatal(err)
+            }
+            if err := <-sent; err != nil {
+                t.Fatalf("send waiting for the resume failed: %v", err)
+            }
+            for i := 5; i <= 11; i++ {
+                if f := readFrame(t, in, receiver); string(f.Body) != fmt.Sprint(i) {
+                    t.Fatalf("after the resume got %q, want %d", f.Body, i)
+                }
+            }
+        })
+    }
+}
+
+func TestLinkAcknowledge(t *testing.T) {
+    sender, receiver := NewLink(ProtocolBinary), NewLink(ProtocolBinary)
+    out, in := wsPair(t)
+    if err := sender.Attach(NewWriter(out, nil), 0); err != nil {
+        t.Fatal(err)
+    }
+    if err := receiver.Attach(NewWriter(in, nil), 0); err != nil {
+        t.Fatal(err)
+    }
+    
+    for i := 1; i <= ackInterval; i++ {
+        sender.Send(dataFrame(i))
+        readFrame(t, in, receiver)
+    }
+    
+    // the receiver acknowledges a whole interval as a json control message
+    ack := readFrame(t, out, nil)
+    if ack.Type != FrameAck || ack.Ack != ackInterval {
+        t.Fatalf("got %s frame acknowledging %d, want ack of %d", ack.Type, ack.Ack, ackInterval)
+    }
+    sender.Acknowledge(ack.Ack)
+    
+    if sender.CanResume(ackInterval - 1) {
+        t.Error("resume allowed from before acknowledged frames that were dropped")
+    }
+    if !sender.CanResume(ackInterval) {
+        t.Error("resume refused from the acknowledged count")
+    }
+    if sender.CanResume(ackInterval + 1) {
+        t.Error("resume allowed past the frames sent")
+    }
+    
+    // control messages are not counted
+    receiver.Receive(&Frame{Type: FrameDraining})
+    if n := receiver.Received(); n != ackInterval {
+        t.Errorf("received %d, want %d", n, ackInterval)
+    }
+}
+
+func TestLinkCloseFailsWaitingSends(t *testing.T) {
+    link := NewLink(ProtocolBinary)
+    sent := make(chan error, 1)
+    go func() { sent <- link.Send(dataFrame(1)) }()
+    
+    time.Sleep(20 * time.Millisecond)
+    link.Close()
+    select {
+    case err := <-sent:
+        if err != ErrLinkClosed {
+            t.Fatalf("waiting send failed with %v, want ErrLinkClosed", err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("send still waiting after close")
+    }
+    
+    if err := link.Send(dataFrame(2)); err != ErrLinkClosed {
+        t.Fatalf("send after close returned %v", err)
+    }
+    if err := link.Attach(nil, 0); err != ErrLinkClosed {
+        t.Fatalf("attach after close returned %v", err)
+    }
+}
\ No newline at end of file
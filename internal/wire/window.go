@@ -0,0 +1,83 @@
+package wire
+
+import "sync"
+
+// every stream direction may have StreamWindow data frames in flight. the
+// receiver buffers that many and grants credit back with window frames as
+// it consumes them, so a slow stream holds up its own sender instead of
+// the read loop shared by every stream on the connection.
+const StreamWindow = 64
+
+// consumed data frames granted back in one window frame
+const windowBatch = StreamWindow / 4
+
+// Window is the credit a sender has left on one stream
+type Window struct {
+    mutex   sync.Mutex
+    credit  int
+    granted chan struct{}
+}
+
+func NewWindow() *Window {
+    return &Window{
+        credit:  StreamWindow,
+        granted: make(chan struct{}, 1),
+    }
+}
+
+// Grant adds the credit carried by a window frame
+func (w *Window) Grant(n int) {
+    w.mutex.Lock()
+    w.credit += n
+    w.mutex.Unlock()
+    
+    select {
+    case w.granted <- struct{}{}:
+    default:
+    }
+}
+
+// Take waits for the credit to send one data frame, it returns false once
+// done is closed
+func (w *Window) Take(done <-chan struct{}) bool {
+    for {
+        if w.TryTake() {
+            return true
+        }
+        select {
+        case <-w.granted:
+        case <-done:
+            return false
+        }
+    }
+}
+
+// TryTake takes the credit for one data frame without waiting, for
+// datagrams that are better dropped than delayed
+func (w *Window) TryTake() bool {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    if w.credit <= 0 {
+        return false
+    }
+    w.credit--
+    return true
+}
+
+// Credit counts the data frames a receiver took off a stream's buffer and
+// batches them into window frames
+type Credit struct {
+    consumed int
+}
+
+// Consume records one data frame taken off the buffer and returns the
+// credit to grant back, zero until a batch has built up
+func (c *Credit) Consume() int {
+    c.consumed++
+    if c.consumed < windowBatch {
+        return 0
+    }
+    n := c.consumed
+    c.consumed = 0
+    return n
+}
\ No newline at end of file
@@ -0,0 +1,147 @@
+package wire
+
+import (
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestWindowExhaustion(t *testing.T) {
+    w := NewWindow()
+    for i := 0; i < StreamWindow; i++ {
+        if !w.TryTake() {
+            t.Fatalf("credit ran out after %d frames, want %d", i, StreamWindow)
+        }
+    }
+    if w.TryTake() {
+        t.Fatal("took credit past the window")
+    }
+    
+    // Take waits for the next grant
+    taken := make(chan bool, 1)
+    go func() { taken <- w.Take(nil) }()
+    select {
+    case <-taken:
+        t.Fatal("Take returned without credit")
+    case <-time.After(50 * time.Millisecond):
+    }
+    w.Grant(1)
+    if !<-taken {
+        t.Fatal("Take failed after a grant")
+    }
+    
+    // and gives up once the stream is done
+    done := make(chan struct{})
+    go func() { taken <- w.Take(done) }()
+    close(done)
+    if <-taken {
+        t.Fatal("Take succeeded without credit")
+    }
+}
+
+func TestWindowConcurrentTakes(t *testing.T) {
+    w := NewWindow()
+    done := make(chan struct{})
+    
+    var taken atomic.Int64
+    var wg sync.WaitGroup
+    for i := 0; i < 16; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for w.Take(done) {
+                taken.Add(1)
+            }
+        }()
+    }
+    
+    const grants = 1000
+    for i := 0; i < grants; i++ {
+        w.Grant(1)
+    }
+    
+    // every frame of credit is taken exactly once
+    deadline := time.Now().Add(5 * time.Second)
+    for taken.Load() < StreamWindow+grants && time.Now().Before(deadline) {
+        time.Sleep(time.Millisecond)
+    }
+    close(done)
+    wg.Wait()
+    if n := taken.Load(); n != StreamWindow+grants {
+        t.Fatalf("took %d frames, want %d", n, StreamWindow+grants)
+    }
+}
+
+func TestCreditBatches(t *testing.T) {
+    var c Credit
+    total := 0
+    for i := 1; i <= StreamWindow; i++ {
+        n := c.Consume()
+        if n != 0 && (n != windowBatch || i%windowBatch != 0) {
+            t.Fatalf("frame %d granted %d", i, n)
+        }
+        total += n
+    }
+    if total != StreamWindow {
+        t.Fatalf("granted %d for %d frames", total, StreamWindow)
+    }
+}
+
+// TestWindowBoundsBuffer runs a fast sender against a slow receiver. the
+// receiver buffers StreamWindow frames, the sender must never need more.
+func TestWindowBoundsBuffer(t *testing.T) {
+    window := NewWindow()
+    buffer := make(chan int, StreamWindow)
+    done := make(chan struct{})
+    
+    const frames = 2000
+    overflow := make(chan int, 1)
+    go func() {
+        for i := 0; i < frames; i++ {
+            if !window.Take(done) {
+                return
+            }
+            select {
+            case buffer <- i:
+            default:
+                overflow <- i
+                return
+            }
+        }
+        close(buffer)
+    }()
+    
+    var credit Credit
+    next := 0
+    for {
+        select {
+        case i, ok := <-buffer:
+            if !ok {
+                if next != frames {
+                    t.Fatalf("received %d frames, want %d", next, frames)
+                }
+                return
+            }
+            if i != next {
+                t.Fatalf("got frame %d, want %d", i, next)
+            }
+            next++
+            if next%100 == 0 {
+                // a slow consumer now and then
+                time.Sleep(time.Millisecond)
+            }
+            if n := credit.Consume(); n > 0 {
+                window.Grant(n)
+            }
+        
+        case i := <-overflow:
+            close(done)
+            t.Fatalf("frame %d sent past the receiver's buffer", i)
+        
+        case <-time.After(5 * time.Second):
+            close(done)
+            t.Fatalf("stalled after %d frames", next)
+        }
+    }
+}
\ No newline at end of file
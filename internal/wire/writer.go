@@ -0,0 +1,202 @@
+package wire
+
+import (
+    "encoding/json"
+    "errors"
+    "sync"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+const (
+    writeQueueSize = 256
+    writeTimeout   = 10 * time.Second
+    enqueueTimeout = 30 * time.Second
+)
+
+var (
+    ErrWriterClosed = errors.New("connection writer closed")
+    ErrQueueFull    = errors.New("write queue full")
+)
+
+type outboundMessage struct {
+    messageType int
+    data        []byte
+    result      chan error
+}
+
+// Writer is the only goroutine allowed to write to a websocket connection,
+// gorilla/websocket does not support concurrent writers. control messages are
+// queued separately and always written before pending data messages.
+// onWrite, when set, is called with the size of every message written.
+type Writer struct {
+    conn      *websocket.Conn
+    onWrite   func(n int)
+    control   chan *outboundMessage
+    data      chan *outboundMessage
+    done      chan struct{}
+    closeOnce sync.Once
+    mutex     sync.Mutex
+    err       error
+}
+
+func NewWriter(conn *websocket.Conn, onWrite func(n int)) *Writer {
+    w := &Writer{
+        conn:    conn,
+        onWrite: onWrite,
+        control: make(chan *outboundMessage, 16),
+        data:    make(chan *outboundMessage, writeQueueSize),
+        done:    make(chan struct{}),
+    }
+    go w.run()
+    return w
+}
+
+func (w *Writer) WriteJSON(v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    return w.enqueue(w.data, websocket.TextMessage, data)
+}
+
+func (w *Writer) WriteMessage(messageType int, data []byte) error {
+    return w.enqueue(w.data, messageType, data)
+}
+
+func (w *Writer) WriteControlJSON(v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    return w.enqueue(w.control, websocket.TextMessage, data)
+}
+
+// enqueue blocks while the queue is full and until the message is written,
+// so slow connections push back on the goroutines producing messages
+func (w *Writer) enqueue(queue chan *outboundMessage, messageType int, data []byte) error {
+    msg := &outboundMessage{
+        messageType: messageType,
+        data:        data,
+        result:      make(chan error, 1),
+    }
+    
+    timer := time.NewTimer(enqueueTimeout)
+    defer timer.Stop()
+    
+    select {
+    case queue <- msg:
+    case <-w.done:
+        return w.closeErr()
+    case <-timer.C:
+        return ErrQueueFull
+    }
+    return w.wait(msg)
+}
+
+// queue adds a data message without waiting for it to be written. it only
+// blocks while the queue is full, a connection too slow to take messages
+// fails its writes and closes the writer.
+func (w *Writer) queue(messageType int, data []byte) (*outboundMessage, error) {
+    msg := &outboundMessage{
+        messageType: messageType,
+        data:        data,
+        result:      make(chan error, 1),
+    }
+    
+    select {
+    case w.data <- msg:
+        return msg, nil
+    case <-w.done:
+        return nil, w.closeErr()
+    }
+}
+
+// wait returns once a queued message is written or the writer is closed
+func (w *Writer) wait(msg *outboundMessage) error {
+    select {
+    case err := <-msg.result:
+        return err
+    case <-w.done:
+        return w.closeErr()
+    }
+}
+
+// Queued counts the messages waiting to be written
+func (w *Writer) Queued() int {
+    return len(w.control) + len(w.data)
+}
+
+func (w *Writer) run() {
+    for {
+        // drain control messages first
+        select {
+        case msg := <-w.control:
+            if !w.write(msg) {
+                return
+            }
+            continue
+        default:
+        }
+        
+        select {
+        case msg := <-w.control:
+            if !w.write(msg) {
+                return
+            }
+        case msg := <-w.data:
+            if !w.write(msg) {
+                return
+            }
+        case <-w.done:
+            return
+        }
+    }
+}
+
+func (w *Writer) write(msg *outboundMessage) bool {
+    w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+    err := w.conn.WriteMessage(msg.messageType, msg.data)
+    msg.result <- err
+    if err != nil {
+        // a failed write leaves the connection unusable
+        w.fail(err)
+        w.conn.Close()
+        return false
+    }
+    if w.onWrite != nil {
+        w.onWrite(len(msg.data))
+    }
+    return true
+}
+
+func (w *Writer) fail(err error) {
+    w.closeOnce.Do(func() {
+        w.mutex.Lock()
+        w.err = err
+        w.mutex.Unlock()
+        close(w.done)
+    })
+}
+
+// Close stops the writer, queued and future messages fail with ErrWriterClosed
+func (w *Writer) Close() {
+    w.fail(ErrWriterClosed)
+}
+
+func (w *Writer) closeErr() error {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    return w.err
+}
+
+// IsClosed reports whether the connection behind the writer is gone
+func (w *Writer) IsClosed() bool {
+    select {
+    case <-w.done:
+        return true
+    default:
+        return false
+    }
+}
\ No newline at end of file
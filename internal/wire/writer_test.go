@@ -0,0 +1,148 @@
+package wire
+
+import (
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// large enough that a few of them fill the socket buffers of a connection
+// nobody reads from
+var bigMessage = make([]byte, 1<<20)
+
+// stall queues big messages until the writer is stuck on the unread
+// connection, with some still waiting behind the blocked write. it returns
+// how many were queued.
+func stall(t *testing.T, w *Writer) int {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for count := 1; ; count++ {
+        if _, err := w.queue(websocket.BinaryMessage, bigMessage); err != nil {
+            t.Fatal(err)
+        }
+        time.Sleep(10 * time.Millisecond)
+        if w.Queued() >= 4 {
+            return count
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("writer never stalled")
+        }
+    }
+}
+
+func TestWriterKeepsOrder(t *testing.T) {
+    out, in := wsPair(t)
+    w := NewWriter(out, nil)
+    defer w.Close()
+    
+    const producers, messages = 8, 200
+    var wg sync.WaitGroup
+    for p := 0; p < producers; p++ {
+        wg.Add(1)
+        go func(p int) {
+            defer wg.Done()
+            for i := 0; i < messages; i++ {
+                if err := w.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("%d:%d", p, i))); err != nil {
+                    t.Error(err)
+                    return
+                }
+            }
+        }(p)
+    }
+    
+    // each producer's messages arrive in the order it wrote them
+    next := make([]int, producers)
+    in.SetReadDeadline(time.Now().Add(10 * time.Second))
+    for n := 0; n < producers*messages; n++ {
+        _, data, err := in.ReadMessage()
+        if err != nil {
+            t.Fatal(err)
+        }
+        var p, i int
+        fmt.Sscanf(string(data), "%d:%d", &p, &i)
+        if i != next[p] {
+            t.Fatalf("producer %d: got message %d, want %d", p, i, next[p])
+        }
+        next[p]++
+    }
+    wg.Wait()
+}
+
+func TestWriterControlFirst(t *testing.T) {
+    out, in := wsPair(t)
+    w := NewWriter(out, nil)
+    defer w.Close()
+    
+    count := stall(t, w)
+    
+    sent := make(chan error, 1)
+    go func() { sent <- w.WriteControlJSON(&Frame{Type: FrameDraining}) }()
+    for len(w.control) == 0 {
+        time.Sleep(time.Millisecond)
+    }
+    waiting := len(w.data)
+    
+    // the control message overtakes every data message still queued, only
+    // the one being written goes first
+    in.SetReadDeadline(time.Now().Add(10 * time.Second))
+    for i := 0; ; i++ {
+        messageType, _, err := in.ReadMessage()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if messageType == websocket.TextMessage {
+            if i > count-waiting {
+                t.Fatalf("control message came after %d data messages, %d were still queued behind it", i, waiting)
+            }
+            break
+        }
+        if i == count-1 {
+            t.Fatal("control message came after every data message")
+        }
+    }
+    if err := <-sent; err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestWriterCloseFailsBlockedWriters(t *testing.T) {
+    out, _ := wsPair(t)
+    w := NewWriter(out, nil)
+    stall(t, w)
+    
+    // fill the queue so the writers below wait on it, or on their message
+    for len(w.data) < writeQueueSize {
+        if _, err := w.queue(websocket.BinaryMessage, bigMessage); err != nil {
+            t.Fatal(err)
+        }
+    }
+    
+    const writers = 8
+    errs := make(chan error, writers)
+    for i := 0; i < writers; i++ {
+        go func() { errs <- w.WriteMessage(websocket.BinaryMessage, bigMessage) }()
+    }
+    time.Sleep(50 * time.Millisecond)
+    
+    w.Close()
+    for i := 0; i < writers; i++ {
+        select {
+        case err := <-errs:
+            if err != ErrWriterClosed {
+                t.Fatalf("blocked write failed with %v, want ErrWriterClosed", err)
+            }
+        case <-time.After(time.Second):
+            t.Fatal("write still blocked after close")
+        }
+    }
+    
+    if !w.IsClosed() {
+        t.Fatal("writer not closed")
+    }
+    if err := w.WriteControlJSON(&Frame{Type: FrameDraining}); err != ErrWriterClosed {
+        t.Fatalf("write after close returned %v", err)
+    }
+}
\ No newline at end of file
@@ -0,0 +1,257 @@
+package admin
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+    
+    "mole/server/auth"
+    "mole/server/tunnel"
+)
+
+// Handler serves the admin api: connected tunnels, reserved subdomains and
+// api tokens. it is meant for its own listener, away from public traffic.
+type Handler struct {
+    manager  *tunnel.Manager
+    tokens   *auth.Store
+    reserved *auth.Reservations
+    token    string
+}
+
+func NewHandler(manager *tunnel.Manager, tokens *auth.Store, reserved *auth.Reservations, token string) *Handler {
+    return &Handler{
+        manager:  manager,
+        tokens:   tokens,
+        reserved: reserved,
+        token:    token,
+    }
+}
+
+// routes are /api/<collection> and /api/<collection>/<name>
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if !h.authorized(r) {
+        w.Header().Set("WWW-Authenticate", `Bearer realm="mole admin"`)
+        http.Error(w, "invalid or missing admin token", http.StatusUnauthorized)
+        return
+    }
+    
+    path, ok := strings.CutPrefix(r.URL.Path, "/api/")
+    if !ok {
+        http.NotFound(w, r)
+        return
+    }
+    collection, name, _ := strings.Cut(path, "/")
+    switch collection {
+    case "tunnels":
+        h.serveTunnels(w, r, name)
+    case "reservations":
+        h.serveReservations(w, r, name)
+    case "tokens":
+        h.serveTokens(w, r, name)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+    value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    return ok && subtle.ConstantTimeCompare([]byte(value), []byte(h.token)) == 1
+}
+
+// GET lists tunnels, DELETE /<name> disconnects one, name being the
+// subdomain or "tcp:<port>" and "udp:<port>"
+func (h *Handler) serveTunnels(w http.ResponseWriter, r *http.Request, name string) {
+    if name == "" {
+        if allow(w, r, http.MethodGet) {
+            writeJSON(w, http.StatusOK, h.manager.Tunnels())
+        }
+        return
+    }
+    
+    if !allow(w, r, http.MethodDelete) {
+        return
+    }
+    if !h.manager.Disconnect(name) {
+        http.Error(w, "tunnel not found", http.StatusNotFound)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// GET lists reservations, POST {"subdomain", "owner"} reserves one and
+// DELETE /<subdomain> releases it
+func (h *Handler) serveReservations(w http.ResponseWriter, r *http.Request, subdomain string) {
+    if subdomain != "" {
+        if !allow(w, r, http.MethodDelete) {
+            return
+        }
+        released, err := h.reserved.Release(subdomain)
+        if errors.Is(err, auth.ErrInlineReservation) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        if !released {
+            http.Error(w, "reservation not found", http.StatusNotFound)
+            return
+        }
+        log.Printf("[ADMIN] Released subdomain %s", subdomain)
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    
+    if !allow(w, r, http.MethodGet, http.MethodPost) {
+        return
+    }
+    if r.Method == http.MethodGet {
+        writeJSON(w, http.StatusOK, h.reserved.List())
+        return
+    }
+    
+    var req struct {
+        Subdomain string `json:"subdomain"`
+        Owner     string `json:"owner"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if req.Owner == "" {
+        http.Error(w, "owner is required", http.StatusBadRequest)
+        return
+    }
+    // owners are token names, without tokens no client could ever claim
+    // the subdomain
+    if !h.tokens.Enabled() {
+        http.Error(w, "reservations need token authentication, create a token first", http.StatusConflict)
+        return
+    }
+    if !h.hasToken(req.Owner) {
+        http.Error(w, fmt.Sprintf("no token named %s", req.Owner), http.StatusBadRequest)
+        return
+    }
+    name, err := h.manager.NormalizeSubdomain(req.Subdomain)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    
+    res, err := h.reserved.Reserve(name, req.Owner)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    }
+    log.Printf("[ADMIN] Reserved subdomain %s for %s", res.Subdomain, res.Owner)
+    writeJSON(w, http.StatusCreated, res)
+}
+
+// the listed form of a token, its value is only shown when it is created
+type tokenView struct {
+    Name      string     `json:"name"`
+    CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// what revoking a token took with it
+type revocation struct {
+    Name         string             `json:"name"`
+    Disconnected int                `json:"disconnected"`
+    Released     []auth.Reservation `json:"released"`
+}
+
+// GET lists tokens, POST {"name"} creates one and DELETE /<name> revokes
+// it, disconnecting its tunnels and releasing its reservations
+func (h *Handler) serveTokens(w http.ResponseWriter, r *http.Request, name string) {
+    if name != "" {
+        if !allow(w, r, http.MethodDelete) {
+            return
+        }
+        revoked, err := h.tokens.Revoke(name)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if !revoked {
+            http.Error(w, "token not found", http.StatusNotFound)
+            return
+        }
+        count := h.manager.DisconnectOwner(name)
+        
+        // a name left reserved for a token that is gone could never be
+        // used again
+        released, err := h.reserved.ReleaseOwner(name)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("token revoked, but its reservations were kept: %v", err), http.StatusInternalServerError)
+            return
+        }
+        log.Printf("[ADMIN] Revoked token %s, disconnected %d tunnels, released %d subdomains", name, count, len(released))
+        writeJSON(w, http.StatusOK, revocation{Name: name, Disconnected: count, Released: released})
+        return
+    }
+    
+    if !allow(w, r, http.MethodGet, http.MethodPost) {
+        return
+    }
+    if r.Method == http.MethodGet {
+        tokens := h.tokens.List()
+        views := make([]tokenView, 0, len(tokens))
+        for _, t := range tokens {
+            view := tokenView{Name: t.Name}
+            if !t.CreatedAt.IsZero() {
+                view.CreatedAt = &t.CreatedAt
+            }
+            views = append(views, view)
+        }
+        writeJSON(w, http.StatusOK, views)
+        return
+    }
+    
+    var req struct {
+        Name string `json:"name"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    t, err := h.tokens.Create(req.Name)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    log.Printf("[ADMIN] Created token %s", t.Name)
+    writeJSON(w, http.StatusCreated, t)
+}
+
+func (h *Handler) hasToken(name string) bool {
+    for _, t := range h.tokens.List() {
+        if t.Name == name {
+            return true
+        }
+    }
+    return false
+}
+
+// allow answers 405 unless the request uses one of methods
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+    for _, method := range methods {
+        if r.Method == method {
+            return true
+        }
+    }
+    w.Header().Set("Allow", strings.Join(methods, ", "))
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
\ No newline at end of file
@@ -0,0 +1,292 @@
+package admin
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+    
+    "github.com/gorilla/websocket"
+    
+    "mole/internal/wire"
+    "mole/server/auth"
+    "mole/server/config"
+    "mole/server/tunnel"
+)
+
+const adminToken = "admin-secret"
+
+type testServer struct {
+    handler  *Handler
+    manager  *tunnel.Manager
+    reserved *auth.Reservations
+    wsURL    string
+}
+
+// newTestServer runs a manager for tunnel clients next to the admin api.
+// tokens and reservations are given as in MOLE_TOKENS and MOLE_RESERVATIONS.
+func newTestServer(t *testing.T, tokens, reservations []string) *testServer {
+    t.Helper()
+    store, err := auth.NewStore("", tokens)
+    if err != nil {
+        t.Fatal(err)
+    }
+    reserved, err := auth.NewReservations("", reservations)
+    if err != nil {
+        t.Fatal(err)
+    }
+    manager := tunnel.NewManager(&config.Config{Domain: "example.com", Port: 80}, store, reserved)
+    
+    srv := httptest.NewServer(http.HandlerFunc(manager.HandleWebSocket))
+    t.Cleanup(srv.Close)
+    return &testServer{
+        handler:  NewHandler(manager, store, reserved, adminToken),
+        manager:  manager,
+        reserved: reserved,
+        wsURL:    "ws" + strings.TrimPrefix(srv.URL, "http"),
+    }
+}
+
+// do sends a request to the admin api with the admin token
+func (s *testServer) do(method, path, body string) *httptest.ResponseRecorder {
+    r := httptest.NewRequest(method, path, strings.NewReader(body))
+    r.Header.Set("Authorization", "Bearer "+adminToken)
+    w := httptest.NewRecorder()
+    s.handler.ServeHTTP(w, r)
+    return w
+}
+
+// connect registers a tunnel client for subdomain, returning its connection
+// and the reply to the registration
+func (s *testServer) connect(t *testing.T, subdomain, token string) (*websocket.Conn, map[string]interface{}) {
+    t.Helper()
+    conn, _, err := websocket.DefaultDialer.Dial(s.wsURL, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    
+    err = conn.WriteJSON(map[string]interface{}{
+        "type":      "register",
+        "subdomain": subdomain,
+        "token":     token,
+        "protocols": wire.SupportedProtocols,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return conn, readMessage(t, conn)
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+    t.Helper()
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    var msg map[string]interface{}
+    if err := conn.ReadJSON(&msg); err != nil {
+        t.Fatal(err)
+    }
+    return msg
+}
+
+// waitTunnels polls the listing until it settles on want
+func (s *testServer) waitTunnels(t *testing.T, want ...string) {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        var got []string
+        for _, info := range s.manager.Tunnels() {
+            got = append(got, info.Name)
+        }
+        if strings.Join(got, ",") == strings.Join(want, ",") {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("tunnels %v, want %v", got, want)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+    s := newTestServer(t, nil, nil)
+    
+    for _, header := range []string{"", "Bearer", "Bearer wrong", "Basic " + adminToken, adminToken, "Bearer " + adminToken + "x"} {
+        r := httptest.NewRequest(http.MethodGet, "/api/tunnels", nil)
+        if header != "" {
+            r.Header.Set("Authorization", header)
+        }
+        w := httptest.NewRecorder()
+        s.handler.ServeHTTP(w, r)
+        if w.Code != http.StatusUnauthorized {
+            t.Errorf("authorization %q answered %d, want 401", header, w.Code)
+        }
+        if !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+            t.Errorf("authorization %q got no bearer challenge", header)
+        }
+    }
+    
+    if w := s.do(http.MethodGet, "/api/tunnels", ""); w.Code != http.StatusOK {
+        t.Fatalf("admin token answered %d", w.Code)
+    }
+    if w := s.do(http.MethodGet, "/api/nothing", ""); w.Code != http.StatusNotFound {
+        t.Errorf("unknown collection answered %d", w.Code)
+    }
+    if w := s.do(http.MethodPut, "/api/tunnels", ""); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodGet {
+        t.Errorf("PUT answered %d, allowing %q", w.Code, w.Header().Get("Allow"))
+    }
+}
+
+func TestAdminListAndDisconnect(t *testing.T) {
+    s := newTestServer(t, []string{"alice:alice-token"}, nil)
+    app, _ := s.connect(t, "app", "alice-token")
+    s.connect(t, "web", "alice-token")
+    s.waitTunnels(t, "app", "web")
+    
+    w := s.do(http.MethodGet, "/api/tunnels", "")
+    var list []tunnel.TunnelInfo
+    if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+        t.Fatal(err)
+    }
+    if len(list) != 2 || list[0].Name != "app" || list[1].Name != "web" {
+        t.Fatalf("listed %+v", list)
+    }
+    if info := list[0]; info.Owner != "alice" || info.Kind != tunnel.KindHTTP || info.URL != "http://app.example.com" || !info.Attached {
+        t.Errorf("app listed as %+v", info)
+    }
+    
+    if w := s.do(http.MethodDelete, "/api/tunnels/app", ""); w.Code != http.StatusNoContent {
+        t.Fatalf("disconnect answered %d: %s", w.Code, w.Body)
+    }
+    // the client is told not to come back
+    if msg := readMessage(t, app); msg["type"] != wire.FrameError || msg["code"] != wire.CodeDisconnected {
+        t.Fatalf("disconnected client got %v", msg)
+    }
+    s.waitTunnels(t, "web")
+    
+    if w := s.do(http.MethodDelete, "/api/tunnels/app", ""); w.Code != http.StatusNotFound {
+        t.Errorf("second disconnect answered %d", w.Code)
+    }
+}
+
+func TestAdminReservations(t *testing.T) {
+    s := newTestServer(t, []string{"alice:alice-token", "bob:bob-token"}, []string{"fixed:alice"})
+    
+    w := s.do(http.MethodPost, "/api/reservations", `{"subdomain": " Shop ", "owner": "alice"}`)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("reserve answered %d: %s", w.Code, w.Body)
+    }
+    var res auth.Reservation
+    json.NewDecoder(w.Body).Decode(&res)
+    if res.Subdomain != "shop" || res.Owner != "alice" {
+        t.Fatalf("reserved %+v", res)
+    }
+    
+    tests := []struct {
+        body string
+        code int
+    }{
+        // reserving your own name again is a no-op
+        {`{"subdomain": "shop", "owner": "alice"}`, http.StatusCreated},
+        {`{"subdomain": "shop", "owner": "bob"}`, http.StatusConflict},
+        {`{"subdomain": "shop"}`, http.StatusBadRequest},
+        {`{"subdomain": "other", "owner": "carol"}`, http.StatusBadRequest},
+        {`{"subdomain": "a.b", "owner": "bob"}`, http.StatusBadRequest},
+        {`{"subdomain": "www", "owner": "bob"}`, http.StatusBadRequest},
+        {`not json`, http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        if w := s.do(http.MethodPost, "/api/reservations", tt.body); w.Code != tt.code {
+            t.Errorf("reserving %s answered %d, want %d: %s", tt.body, w.Code, tt.code, w.Body)
+        }
+    }
+    
+    // a reserved name only registers for its owner
+    if _, reply := s.connect(t, "shop", "bob-token"); reply["type"] != wire.FrameError {
+        t.Fatalf("bob registered alice's reservation: %v", reply)
+    }
+    if _, reply := s.connect(t, "shop", "alice-token"); reply["type"] != "registered" {
+        t.Fatalf("alice could not register her reservation: %v", reply)
+    }
+    
+    var list []auth.Reservation
+    json.NewDecoder(s.do(http.MethodGet, "/api/reservations", "").Body).Decode(&list)
+    if len(list) != 2 || list[0].Subdomain != "fixed" || list[1].Subdomain != "shop" {
+        t.Fatalf("listed %+v", list)
+    }
+    
+    if w := s.do(http.MethodDelete, "/api/reservations/Shop", ""); w.Code != http.StatusNoContent {
+        t.Fatalf("release answered %d: %s", w.Code, w.Body)
+    }
+    if w := s.do(http.MethodDelete, "/api/reservations/shop", ""); w.Code != http.StatusNotFound {
+        t.Errorf("second release answered %d", w.Code)
+    }
+    // reservations from the environment are released there
+    if w := s.do(http.MethodDelete, "/api/reservations/fixed", ""); w.Code != http.StatusBadRequest {
+        t.Errorf("releasing an inline reservation answered %d", w.Code)
+    }
+}
+
+func TestAdminReservationsNeedTokens(t *testing.T) {
+    s := newTestServer(t, nil, nil)
+    if w := s.do(http.MethodPost, "/api/reservations", `{"subdomain": "shop", "owner": "alice"}`); w.Code != http.StatusConflict {
+        t.Fatalf("reserving without token authentication answered %d", w.Code)
+    }
+}
+
+func TestAdminRevokeCascades(t *testing.T) {
+    s := newTestServer(t, []string{"ops:ops-token"}, nil)
+    
+    w := s.do(http.MethodPost, "/api/tokens", `{"name": "bob"}`)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("create answered %d: %s", w.Code, w.Body)
+    }
+    var token auth.Token
+    json.NewDecoder(w.Body).Decode(&token)
+    if token.Name != "bob" || token.Token == "" {
+        t.Fatalf("created %+v", token)
+    }
+    
+    for _, name := range []string{"bob-site", "bob-api"} {
+        if w := s.do(http.MethodPost, "/api/reservations", `{"subdomain": "`+name+`", "owner": "bob"}`); w.Code != http.StatusCreated {
+            t.Fatalf("reserve answered %d: %s", w.Code, w.Body)
+        }
+    }
+    bob, reply := s.connect(t, "bob-site", token.Token)
+    if reply["type"] != "registered" {
+        t.Fatalf("bob could not register: %v", reply)
+    }
+    s.connect(t, "ops", "ops-token")
+    s.waitTunnels(t, "bob-site", "ops")
+    
+    w = s.do(http.MethodDelete, "/api/tokens/bob", "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("revoke answered %d: %s", w.Code, w.Body)
+    }
+    var result revocation
+    json.NewDecoder(w.Body).Decode(&result)
+    if result.Disconnected != 1 || len(result.Released) != 2 || result.Released[0].Subdomain != "bob-api" || result.Released[1].Subdomain != "bob-site" {
+        t.Fatalf("revocation took %+v", result)
+    }
+    
+    // bob's tunnel and reservations are gone, other owners keep theirs
+    if msg := readMessage(t, bob); msg["code"] != wire.CodeDisconnected {
+        t.Fatalf("revoked client got %v", msg)
+    }
+    s.waitTunnels(t, "ops")
+    if list := s.reserved.List(); len(list) != 0 {
+        t.Errorf("reservations left after revoking: %+v", list)
+    }
+    if _, reply := s.connect(t, "bob-site", token.Token); reply["type"] != wire.FrameError || reply["code"] != wire.CodeUnauthorized {
+        t.Errorf("revoked token registered: %v", reply)
+    }
+    
+    if w := s.do(http.MethodDelete, "/api/tokens/bob", ""); w.Code != http.StatusNotFound {
+        t.Errorf("second revoke answered %d", w.Code)
+    }
+    if w := s.do(http.MethodDelete, "/api/tokens/ops", ""); w.Code != http.StatusBadRequest {
+        t.Errorf("revoking an inline token answered %d", w.Code)
+    }
+    s.waitTunnels(t, "ops")
+}
\ No newline at end of file
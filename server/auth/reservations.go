@@ -0,0 +1,231 @@
+package auth
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    
+    "golang.org/x/net/idna"
+)
+
+// a subdomain held by an owner, the name of the token allowed to use it
+type Reservation struct {
+    Subdomain string    `json:"subdomain"`
+    Owner     string    `json:"owner"`
+    CreatedAt time.Time `json:"created_at,omitempty"`
+    
+    // set in the environment rather than the file, it is never saved
+    inline bool
+}
+
+// ErrInlineReservation is returned when releasing a reservation that comes
+// from MOLE_RESERVATIONS
+var ErrInlineReservation = errors.New("reserved in MOLE_RESERVATIONS, remove it there")
+
+// NormalizeSubdomain converts a subdomain to the lowercase ascii form it is
+// registered under, punycode for international names
+func NormalizeSubdomain(subdomain string) (string, error) {
+    return idna.Lookup.ToASCII(strings.TrimSpace(subdomain))
+}
+
+// reserved subdomains, loaded from the environment and an optional json
+// file. changes made at runtime are written back to the file.
+type Reservations struct {
+    mutex        sync.RWMutex
+    reservations map[string]*Reservation
+    file         string
+}
+
+// NewReservations loads reservations from file, if set, plus inline
+// reservations given as "subdomain:owner"
+func NewReservations(file string, inline []string) (*Reservations, error) {
+    r := &Reservations{
+        reservations: make(map[string]*Reservation),
+        file:         file,
+    }
+    
+    if file != "" {
+        data, err := os.ReadFile(file)
+        if err != nil && !os.IsNotExist(err) {
+            return nil, fmt.Errorf("failed to read reservations file: %v", err)
+        }
+        if len(data) > 0 {
+            var reservations []*Reservation
+            if err := json.Unmarshal(data, &reservations); err != nil {
+                return nil, fmt.Errorf("failed to parse reservations file: %v", err)
+            }
+            for _, res := range reservations {
+                if err := r.load(res); err != nil {
+                    return nil, err
+                }
+            }
+        }
+    }
+    
+    // loaded last, the environment wins over the file
+    for _, value := range inline {
+        value = strings.TrimSpace(value)
+        if value == "" {
+            continue
+        }
+        subdomain, owner, found := strings.Cut(value, ":")
+        if !found || subdomain == "" || owner == "" {
+            return nil, fmt.Errorf("invalid reservation %q, expected subdomain:owner", value)
+        }
+        if err := r.load(&Reservation{Subdomain: subdomain, Owner: owner, inline: true}); err != nil {
+            return nil, err
+        }
+    }
+    
+    return r, nil
+}
+
+// load adds a configured reservation under the name clients register
+func (r *Reservations) load(res *Reservation) error {
+    name, err := NormalizeSubdomain(res.Subdomain)
+    if err != nil || name == "" {
+        return fmt.Errorf("invalid reserved subdomain %q", res.Subdomain)
+    }
+    res.Subdomain = name
+    r.reservations[name] = res
+    return nil
+}
+
+// Owner returns who holds subdomain, if anyone
+func (r *Reservations) Owner(subdomain string) (string, bool) {
+    r.mutex.RLock()
+    defer r.mutex.RUnlock()
+    
+    res, exists := r.reservations[subdomain]
+    if !exists {
+        return "", false
+    }
+    return res.Owner, true
+}
+
+// Reserve gives subdomain to owner. it fails if someone else holds it.
+func (r *Reservations) Reserve(subdomain, owner string) (*Reservation, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    
+    if res, exists := r.reservations[subdomain]; exists {
+        if res.Owner != owner {
+            return nil, fmt.Errorf("subdomain %s is reserved by %s", subdomain, res.Owner)
+        }
+        return res, nil
+    }
+    
+    res := &Reservation{Subdomain: subdomain, Owner: owner, CreatedAt: time.Now().UTC()}
+    r.reservations[subdomain] = res
+    if err := r.save(); err != nil {
+        delete(r.reservations, subdomain)
+        return nil, err
+    }
+    return res, nil
+}
+
+// Release frees subdomain, reporting whether it was reserved
+func (r *Reservations) Release(subdomain string) (bool, error) {
+    if name, err := NormalizeSubdomain(subdomain); err == nil {
+        subdomain = name
+    }
+    
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    
+    res, exists := r.reservations[subdomain]
+    if !exists {
+        return false, nil
+    }
+    if res.inline {
+        return false, fmt.Errorf("subdomain %s is %w", subdomain, ErrInlineReservation)
+    }
+    
+    delete(r.reservations, subdomain)
+    if err := r.save(); err != nil {
+        r.reservations[subdomain] = res
+        return false, err
+    }
+    return true, nil
+}
+
+// ReleaseOwner frees every subdomain owner holds and returns them, sorted
+// by subdomain. reservations from the environment are kept.
+func (r *Reservations) ReleaseOwner(owner string) ([]Reservation, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    
+    released := make([]Reservation, 0)
+    for subdomain, res := range r.reservations {
+        if res.Owner == owner && !res.inline {
+            released = append(released, *res)
+            delete(r.reservations, subdomain)
+        }
+    }
+    if len(released) == 0 {
+        return released, nil
+    }
+    if err := r.save(); err != nil {
+        for i := range released {
+            r.reservations[released[i].Subdomain] = &released[i]
+        }
+        return nil, err
+    }
+    sort.Slice(released, func(i, j int) bool {
+        return released[i].Subdomain < released[j].Subdomain
+    })
+    return released, nil
+}
+
+// List returns every reservation sorted by subdomain
+func (r *Reservations) List() []Reservation {
+    r.mutex.RLock()
+    defer r.mutex.RUnlock()
+    
+    list := make([]Reservation, 0, len(r.reservations))
+    for _, res := range r.reservations {
+        list = append(list, *res)
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Subdomain < list[j].Subdomain
+    })
+    return list
+}
+
+// save writes the reservations that came from the file or were made at
+// runtime, replacing the file in one step so a crash never leaves it half
+// written. callers hold the mutex.
+func (r *Reservations) save() error {
+    if r.file == "" {
+        return nil
+    }
+    
+    list := make([]*Reservation, 0, len(r.reservations))
+    for _, res := range r.reservations {
+        if !res.inline {
+            list = append(list, res)
+        }
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Subdomain < list[j].Subdomain
+    })
+    
+    data, err := json.MarshalIndent(list, "", "    ")
+    if err != nil {
+        return err
+    }
+    
+    tmp := r.file + ".tmp"
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return fmt.Errorf("failed to write reservations file: %v", err)
+    }
+    if err := os.Rename(tmp, r.file); err != nil {
+        return fmt.Errorf("failed to write reservations file: %v", err)
+    }
+    return nil
+}
\ No newline at end of file
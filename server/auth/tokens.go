@@ -0,0 +1,186 @@
+package auth
+
+import (
+    "crypto/rand"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// an api token allowed to register tunnels. Name identifies the owner.
+type Token struct {
+    Name      string    `json:"name"`
+    Token     string    `json:"token"`
+    CreatedAt time.Time `json:"created_at,omitempty"`
+    
+    // set in the environment rather than the file, it is never saved
+    inline bool
+}
+
+// set of api tokens, loaded from the environment and an optional json
+// file. tokens created at runtime are written back to the file.
+type Store struct {
+    mutex  sync.RWMutex
+    tokens []*Token
+    file   string
+}
+
+// NewStore loads tokens from file, if set, plus inline tokens given as
+// "name:token" or a bare token
+func NewStore(file string, inline []string) (*Store, error) {
+    s := &Store{file: file}
+    
+    for i, value := range inline {
+        value = strings.TrimSpace(value)
+        if value == "" {
+            continue
+        }
+        name, token, found := strings.Cut(value, ":")
+        if !found {
+            name, token = fmt.Sprintf("token-%d", i+1), value
+        }
+        s.tokens = append(s.tokens, &Token{Name: name, Token: token, inline: true})
+    }
+    
+    if file != "" {
+        data, err := os.ReadFile(file)
+        if err != nil && !os.IsNotExist(err) {
+            return nil, fmt.Errorf("failed to read token file: %v", err)
+        }
+        if len(data) > 0 {
+            var tokens []*Token
+            if err := json.Unmarshal(data, &tokens); err != nil {
+                return nil, fmt.Errorf("failed to parse token file: %v", err)
+            }
+            s.tokens = append(s.tokens, tokens...)
+        }
+    }
+    
+    return s, nil
+}
+
+// Enabled reports whether registration requires a token
+func (s *Store) Enabled() bool {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    return len(s.tokens) > 0 || s.file != ""
+}
+
+// Authenticate returns the token matching value, comparing in constant time
+func (s *Store) Authenticate(value string) (*Token, bool) {
+    if value == "" {
+        return nil, false
+    }
+    
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    
+    var match *Token
+    for _, t := range s.tokens {
+        if subtle.ConstantTimeCompare([]byte(t.Token), []byte(value)) == 1 {
+            match = t
+        }
+    }
+    return match, match != nil
+}
+
+// List returns every token in the order they were added
+func (s *Store) List() []Token {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    
+    list := make([]Token, 0, len(s.tokens))
+    for _, t := range s.tokens {
+        list = append(list, *t)
+    }
+    return list
+}
+
+// Create adds a token with a random value for a new owner
+func (s *Store) Create(name string) (*Token, error) {
+    if name == "" || strings.ContainsAny(name, ":,") {
+        return nil, fmt.Errorf("invalid token name %q", name)
+    }
+    
+    bytes := make([]byte, 24)
+    if _, err := rand.Read(bytes); err != nil {
+        return nil, err
+    }
+    
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    
+    for _, t := range s.tokens {
+        if t.Name == name {
+            return nil, fmt.Errorf("token %s already exists", name)
+        }
+    }
+    
+    t := &Token{Name: name, Token: hex.EncodeToString(bytes), CreatedAt: time.Now().UTC()}
+    s.tokens = append(s.tokens, t)
+    if err := s.save(); err != nil {
+        s.tokens = s.tokens[:len(s.tokens)-1]
+        return nil, err
+    }
+    return t, nil
+}
+
+// Revoke removes the token of an owner, reporting whether there was one.
+// tokens from the environment have to be removed there.
+func (s *Store) Revoke(name string) (bool, error) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    
+    for i, t := range s.tokens {
+        if t.Name != name {
+            continue
+        }
+        if t.inline {
+            return false, fmt.Errorf("token %s is set in MOLE_TOKENS, remove it there", name)
+        }
+        
+        tokens := append(append([]*Token{}, s.tokens[:i]...), s.tokens[i+1:]...)
+        previous := s.tokens
+        s.tokens = tokens
+        if err := s.save(); err != nil {
+            s.tokens = previous
+            return false, err
+        }
+        return true, nil
+    }
+    return false, nil
+}
+
+// save writes the tokens that came from the file or were created at
+// runtime, replacing the file in one step. callers hold the mutex.
+func (s *Store) save() error {
+    if s.file == "" {
+        return nil
+    }
+    
+    list := make([]*Token, 0, len(s.tokens))
+    for _, t := range s.tokens {
+        if !t.inline {
+            list = append(list, t)
+        }
+    }
+    
+    data, err := json.MarshalIndent(list, "", "    ")
+    if err != nil {
+        return err
+    }
+    
+    tmp := s.file + ".tmp"
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return fmt.Errorf("failed to write token file: %v", err)
+    }
+    if err := os.Rename(tmp, s.file); err != nil {
+        return fmt.Errorf("failed to write token file: %v", err)
+    }
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,336 @@
+package certs
+
+import (
+    "bytes"
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "os/exec"
+    "time"
+    
+    "golang.org/x/crypto/acme"
+)
+
+// renew the wildcard certificate once it has less than this left
+const renewBefore = 30 * 24 * time.Hour
+
+// dueForRenewal reports whether cert should be replaced. short lived
+// certificates are renewed once a third of their lifetime is left, so
+// they are not ordered again on every check.
+func dueForRenewal(cert *x509.Certificate) bool {
+    before := cert.NotAfter.Sub(cert.NotBefore) / 3
+    if before > renewBefore {
+        before = renewBefore
+    }
+    return time.Until(cert.NotAfter) < before
+}
+
+// DNSProvider publishes the TXT records that prove control of a domain for
+// dns-01 challenges. Present should only return once the record is
+// visible to the ca.
+type DNSProvider interface {
+    Present(ctx context.Context, fqdn, value string) error
+    CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// ExecProvider hands records to an external program, called as
+// "<path> present <fqdn> <value>" and "<path> cleanup <fqdn> <value>", so
+// any dns host can be scripted without building it into the server
+type ExecProvider struct {
+    path string
+}
+
+func NewExecProvider(path string) *ExecProvider {
+    return &ExecProvider{path: path}
+}
+
+func (p *ExecProvider) Present(ctx context.Context, fqdn, value string) error {
+    return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *ExecProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+    return p.run(ctx, "cleanup", fqdn, value)
+}
+
+func (p *ExecProvider) run(ctx context.Context, action, fqdn, value string) error {
+    var output bytes.Buffer
+    cmd := exec.CommandContext(ctx, p.path, action, fqdn, value)
+    cmd.Stdout = &output
+    cmd.Stderr = &output
+    if err := cmd.Run(); err != nil {
+        return fmt.Errorf("dns hook %s failed: %v: %s", action, err, bytes.TrimSpace(output.Bytes()))
+    }
+    return nil
+}
+
+// cache keys, kept apart from the entries autocert writes
+func (m *Manager) wildcardKey() string {
+    return "wildcard+" + m.domain
+}
+
+const accountKey = "wildcard+account"
+
+// loadWildcard serves the cached wildcard certificate, obtaining a new one
+// when there is none or it is due for renewal
+func (m *Manager) loadWildcard(ctx context.Context) error {
+    if cert, err := m.cachedWildcard(ctx); err == nil {
+        m.setWildcard(cert)
+        if !dueForRenewal(cert.Leaf) {
+            m.logf("using cached wildcard certificate for %s, valid until %s", m.domain, cert.Leaf.NotAfter.Format(time.RFC3339))
+            return nil
+        }
+    }
+    
+    cert, err := m.obtainWildcard(ctx)
+    if err != nil {
+        // an old certificate that still works beats none at all
+        if m.currentWildcard() != nil {
+            m.logf("failed to renew wildcard certificate, keeping the cached one: %v", err)
+            return nil
+        }
+        return err
+    }
+    m.setWildcard(cert)
+    return nil
+}
+
+// renewWildcard checks the wildcard certificate twice a day
+func (m *Manager) renewWildcard() {
+    ticker := time.NewTicker(12 * time.Hour)
+    defer ticker.Stop()
+    
+    for range ticker.C {
+        cert := m.currentWildcard()
+        if cert != nil && !dueForRenewal(cert.Leaf) {
+            continue
+        }
+        
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+        renewed, err := m.obtainWildcard(ctx)
+        cancel()
+        if err != nil {
+            m.logf("failed to renew wildcard certificate: %v", err)
+            continue
+        }
+        m.setWildcard(renewed)
+    }
+}
+
+func (m *Manager) currentWildcard() *tls.Certificate {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+    return m.wildcard
+}
+
+func (m *Manager) setWildcard(cert *tls.Certificate) {
+    m.mutex.Lock()
+    m.wildcard = cert
+    m.mutex.Unlock()
+}
+
+// obtainWildcard orders a certificate for the domain and all of its
+// subdomains, answering the dns-01 challenges through the provider
+func (m *Manager) obtainWildcard(ctx context.Context) (*tls.Certificate, error) {
+    m.logf("requesting wildcard certificate for *.%s", m.domain)
+    
+    if err := m.register(ctx); err != nil {
+        return nil, err
+    }
+    
+    order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs("*."+m.domain, m.domain))
+    if err != nil {
+        return nil, fmt.Errorf("failed to create order: %v", err)
+    }
+    
+    // both names share one TXT record name, solve them one at a time so a
+    // provider only ever has to publish a single value
+    for _, authzURL := range order.AuthzURLs {
+        if err := m.authorize(ctx, authzURL); err != nil {
+            return nil, err
+        }
+    }
+    
+    order, err = m.client.WaitOrder(ctx, order.URI)
+    if err != nil {
+        return nil, fmt.Errorf("order failed: %v", err)
+    }
+    
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return nil, err
+    }
+    csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+        DNSNames: []string{"*." + m.domain, m.domain},
+    }, key)
+    if err != nil {
+        return nil, err
+    }
+    
+    chain, err := m.finalize(ctx, order, csr)
+    if err != nil {
+        return nil, fmt.Errorf("failed to finalize order: %v", err)
+    }
+    
+    cert, err := newCertificate(key, chain)
+    if err != nil {
+        return nil, err
+    }
+    if err := m.cache.Put(ctx, m.wildcardKey(), encodeCertificate(key, chain)); err != nil {
+        m.logf("failed to cache wildcard certificate: %v", err)
+    }
+    
+    m.logf("obtained wildcard certificate for *.%s, valid until %s", m.domain, cert.Leaf.NotAfter.Format(time.RFC3339))
+    return cert, nil
+}
+
+// finalize submits the csr and downloads the certificate chain
+func (m *Manager) finalize(ctx context.Context, order *acme.Order, csr []byte) ([][]byte, error) {
+    chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+    if err == nil {
+        return chain, nil
+    }
+    
+    // cas that issue in the background may leave the order location out of
+    // the finalize response, poll the order we already know instead
+    issued, waitErr := m.client.WaitOrder(ctx, order.URI)
+    if waitErr != nil || issued.CertURL == "" {
+        return nil, err
+    }
+    return m.client.FetchCert(ctx, issued.CertURL, true)
+}
+
+func (m *Manager) authorize(ctx context.Context, authzURL string) error {
+    authz, err := m.client.GetAuthorization(ctx, authzURL)
+    if err != nil {
+        return fmt.Errorf("failed to fetch authorization: %v", err)
+    }
+    if authz.Status == acme.StatusValid {
+        return nil
+    }
+    
+    var challenge *acme.Challenge
+    for _, c := range authz.Challenges {
+        if c.Type == "dns-01" {
+            challenge = c
+            break
+        }
+    }
+    if challenge == nil {
+        return fmt.Errorf("ca offered no dns-01 challenge for %s", authz.Identifier.Value)
+    }
+    
+    value, err := m.client.DNS01ChallengeRecord(challenge.Token)
+    if err != nil {
+        return err
+    }
+    fqdn := "_acme-challenge." + authz.Identifier.Value
+    
+    if err := m.dns.Present(ctx, fqdn, value); err != nil {
+        return err
+    }
+    defer func() {
+        if err := m.dns.CleanUp(context.Background(), fqdn, value); err != nil {
+            m.logf("failed to clean up %s: %v", fqdn, err)
+        }
+    }()
+    
+    if _, err := m.client.Accept(ctx, challenge); err != nil {
+        return fmt.Errorf("failed to accept challenge: %v", err)
+    }
+    if _, err := m.client.WaitAuthorization(ctx, authz.URI); err != nil {
+        return fmt.Errorf("dns-01 validation for %s failed: %v", authz.Identifier.Value, err)
+    }
+    return nil
+}
+
+// register loads or creates the acme account used for wildcard orders
+func (m *Manager) register(ctx context.Context) error {
+    if m.client.Key != nil {
+        return nil
+    }
+    
+    var key crypto.Signer
+    if data, err := m.cache.Get(ctx, accountKey); err == nil {
+        block, _ := pem.Decode(data)
+        if block == nil {
+            return errors.New("invalid cached acme account key")
+        }
+        if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+            return fmt.Errorf("invalid cached acme account key: %v", err)
+        }
+    } else {
+        ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+        if err != nil {
+            return err
+        }
+        der, err := x509.MarshalECPrivateKey(ecKey)
+        if err != nil {
+            return err
+        }
+        if err := m.cache.Put(ctx, accountKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+            return fmt.Errorf("failed to cache acme account key: %v", err)
+        }
+        key = ecKey
+    }
+    m.client.Key = key
+    
+    account := &acme.Account{}
+    if m.email != "" {
+        account.Contact = []string{"mailto:" + m.email}
+    }
+    _, err := m.client.Register(ctx, account, acme.AcceptTOS)
+    if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+        m.client.Key = nil
+        return fmt.Errorf("failed to register acme account: %v", err)
+    }
+    return nil
+}
+
+func (m *Manager) cachedWildcard(ctx context.Context) (*tls.Certificate, error) {
+    data, err := m.cache.Get(ctx, m.wildcardKey())
+    if err != nil {
+        return nil, err
+    }
+    cert, err := tls.X509KeyPair(data, data)
+    if err != nil {
+        return nil, err
+    }
+    if cert.Leaf == nil {
+        if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+            return nil, err
+        }
+    }
+    return &cert, nil
+}
+
+// newCertificate builds a tls certificate from a key and the der chain
+// returned by the ca
+func newCertificate(key crypto.Signer, chain [][]byte) (*tls.Certificate, error) {
+    if len(chain) == 0 {
+        return nil, errors.New("ca returned an empty certificate chain")
+    }
+    leaf, err := x509.ParseCertificate(chain[0])
+    if err != nil {
+        return nil, err
+    }
+    return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// encodeCertificate stores key and chain in one pem file, the layout
+// autocert uses for its own cache entries
+func encodeCertificate(key *ecdsa.PrivateKey, chain [][]byte) []byte {
+    var buf bytes.Buffer
+    der, _ := x509.MarshalECPrivateKey(key)
+    pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+    for _, cert := range chain {
+        pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert})
+    }
+    return buf.Bytes()
+}
\ No newline at end of file
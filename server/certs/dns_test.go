@@ -0,0 +1,690 @@
+package certs
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "fmt"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    
+    "golang.org/x/crypto/acme"
+    "golang.org/x/crypto/acme/autocert"
+)
+
+// fakeDNS is the zone the fake ca validates against
+type fakeDNS struct {
+    mutex    sync.Mutex
+    records  map[string]string
+    presents int
+    cleanups int
+    // a second value for a name that is still published
+    overlap bool
+    // publish values the ca will not accept
+    corrupt bool
+}
+
+func newFakeDNS() *fakeDNS {
+    return &fakeDNS{records: make(map[string]string)}
+}
+
+func (d *fakeDNS) Present(ctx context.Context, fqdn, value string) error {
+    d.mutex.Lock()
+    defer d.mutex.Unlock()
+    if _, ok := d.records[fqdn]; ok {
+        d.overlap = true
+    }
+    if d.corrupt {
+        value = "not-" + value
+    }
+    d.records[fqdn] = value
+    d.presents++
+    return nil
+}
+
+func (d *fakeDNS) CleanUp(ctx context.Context, fqdn, value string) error {
+    d.mutex.Lock()
+    defer d.mutex.Unlock()
+    delete(d.records, fqdn)
+    d.cleanups++
+    return nil
+}
+
+func (d *fakeDNS) lookup(fqdn string) string {
+    d.mutex.Lock()
+    defer d.mutex.Unlock()
+    return d.records[fqdn]
+}
+
+// fakeCA speaks just enough acme for the wildcard flow. dns-01 challenges
+// are checked against the fake zone as soon as they are accepted, and
+// certificates are signed by a throwaway root.
+type fakeCA struct {
+    t        *testing.T
+    server   *httptest.Server
+    dns      *fakeDNS
+    root     *x509.Certificate
+    rootKey  *ecdsa.PrivateKey
+    validity time.Duration
+    // how long ago issued certificates start being valid
+    age time.Duration
+    
+    mutex    sync.Mutex
+    refuse   bool
+    accounts map[string]string // kid to key thumbprint
+    orders   []*fakeOrder
+    authzs   []*fakeAuthz
+}
+
+type fakeOrder struct {
+    names  []string
+    authzs []int
+    cert   [][]byte
+}
+
+type fakeAuthz struct {
+    domain     string
+    wildcard   bool
+    token      string
+    thumbprint string
+    status     string
+}
+
+func newFakeCA(t *testing.T, dns *fakeDNS) *fakeCA {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "fake acme root"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+        KeyUsage:              x509.KeyUsageCertSign,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    root, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    ca := &fakeCA{
+        t:        t,
+        dns:      dns,
+        root:     root,
+        rootKey:  key,
+        validity: 90 * 24 * time.Hour,
+        age:      time.Hour,
+        accounts: make(map[string]string),
+    }
+    ca.server = httptest.NewTLSServer(http.HandlerFunc(ca.serveHTTP))
+    t.Cleanup(ca.server.Close)
+    return ca
+}
+
+func (ca *fakeCA) client() *acme.Client {
+    return &acme.Client{DirectoryURL: ca.server.URL + "/directory", HTTPClient: ca.server.Client()}
+}
+
+func (ca *fakeCA) orderCount() int {
+    ca.mutex.Lock()
+    defer ca.mutex.Unlock()
+    return len(ca.orders)
+}
+
+func (ca *fakeCA) accountCount() int {
+    ca.mutex.Lock()
+    defer ca.mutex.Unlock()
+    return len(ca.accounts)
+}
+
+func (ca *fakeCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Replay-Nonce", strconv.FormatInt(time.Now().UnixNano(), 36))
+    if r.URL.Path == "/directory" {
+        ca.reply(w, http.StatusOK, "", map[string]string{
+            "newNonce":   ca.server.URL + "/nonce",
+            "newAccount": ca.server.URL + "/account",
+            "newOrder":   ca.server.URL + "/order",
+            "revokeCert": ca.server.URL + "/revoke",
+        })
+        return
+    }
+    if r.URL.Path == "/nonce" {
+        return
+    }
+    
+    kid, jwk, payload, err := ca.readJWS(r)
+    if err != nil {
+        ca.problem(w, http.StatusBadRequest, "malformed", err.Error())
+        return
+    }
+    
+    ca.mutex.Lock()
+    defer ca.mutex.Unlock()
+    
+    kind, n := r.URL.Path, -1
+    if i := strings.LastIndex(r.URL.Path, "/"); i > 0 {
+        kind = r.URL.Path[:i]
+        if n, err = strconv.Atoi(r.URL.Path[i+1:]); err != nil {
+            http.NotFound(w, r)
+            return
+        }
+    }
+    
+    switch kind {
+    case "/account":
+        ca.newAccount(w, jwk)
+    case "/order":
+        if n < 0 {
+            ca.newOrder(w, kid, payload)
+        } else if n < len(ca.orders) {
+            ca.reply(w, http.StatusOK, ca.orderURL(n), ca.orderJSON(n))
+        } else {
+            http.NotFound(w, r)
+        }
+    case "/authz":
+        if n < 0 || n >= len(ca.authzs) {
+            http.NotFound(w, r)
+            return
+        }
+        ca.reply(w, http.StatusOK, "", ca.authzJSON(n))
+    case "/challenge":
+        if n < 0 || n >= len(ca.authzs) {
+            http.NotFound(w, r)
+            return
+        }
+        ca.validate(n)
+        ca.reply(w, http.StatusOK, "", ca.challengeJSON(n))
+    case "/finalize":
+        if n < 0 || n >= len(ca.orders) {
+            http.NotFound(w, r)
+            return
+        }
+        ca.finalize(w, n, payload)
+    case "/cert":
+        if n < 0 || n >= len(ca.orders) || ca.orders[n].cert == nil {
+            http.NotFound(w, r)
+            return
+        }
+        w.Header().Set("Content-Type", "application/pem-certificate-chain")
+        for _, der := range ca.orders[n].cert {
+            pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+        }
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+// readJWS unpacks a signed request. signatures are not checked, the fake
+// only needs the account key and the payload.
+func (ca *fakeCA) readJWS(r *http.Request) (kid string, jwk json.RawMessage, payload []byte, err error) {
+    var jws struct {
+        Protected string `json:"protected"`
+        Payload   string `json:"payload"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+        return "", nil, nil, err
+    }
+    protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+    if err != nil {
+        return "", nil, nil, err
+    }
+    var header struct {
+        KID string          `json:"kid"`
+        JWK json.RawMessage `json:"jwk"`
+        URL string          `json:"url"`
+    }
+    if err := json.Unmarshal(protected, &header); err != nil {
+        return "", nil, nil, err
+    }
+    if header.URL != ca.server.URL+r.URL.Path {
+        return "", nil, nil, fmt.Errorf("signed for %s, sent to %s", header.URL, r.URL.Path)
+    }
+    payload, err = base64.RawURLEncoding.DecodeString(jws.Payload)
+    return header.KID, header.JWK, payload, err
+}
+
+func (ca *fakeCA) newAccount(w http.ResponseWriter, jwk json.RawMessage) {
+    var key struct {
+        Crv string `json:"crv"`
+        Kty string `json:"kty"`
+        X   string `json:"x"`
+        Y   string `json:"y"`
+    }
+    if err := json.Unmarshal(jwk, &key); err != nil || key.Kty != "EC" {
+        ca.problem(w, http.StatusBadRequest, "badPublicKey", "expected an ec key")
+        return
+    }
+    // rfc 7638, members in lexical order without whitespace
+    sum := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, key.Crv, key.Kty, key.X, key.Y)))
+    thumbprint := base64.RawURLEncoding.EncodeToString(sum[:])
+    
+    for kid, known := range ca.accounts {
+        if known == thumbprint {
+            ca.reply(w, http.StatusOK, kid, map[string]string{"status": "valid"})
+            return
+        }
+    }
+    kid := fmt.Sprintf("%s/account/%d", ca.server.URL, len(ca.accounts))
+    ca.accounts[kid] = thumbprint
+    ca.reply(w, http.StatusCreated, kid, map[string]string{"status": "valid"})
+}
+
+func (ca *fakeCA) newOrder(w http.ResponseWriter, kid string, payload []byte) {
+    thumbprint, ok := ca.accounts[kid]
+    if !ok {
+        ca.problem(w, http.StatusUnauthorized, "accountDoesNotExist", "unknown account")
+        return
+    }
+    if ca.refuse {
+        ca.problem(w, http.StatusForbidden, "rejectedIdentifier", "orders are refused")
+        return
+    }
+    var req struct {
+        Identifiers []struct {
+            Value string `json:"value"`
+        } `json:"identifiers"`
+    }
+    if err := json.Unmarshal(payload, &req); err != nil {
+        ca.problem(w, http.StatusBadRequest, "malformed", err.Error())
+        return
+    }
+    
+    order := &fakeOrder{}
+    for _, id := range req.Identifiers {
+        order.names = append(order.names, id.Value)
+        order.authzs = append(order.authzs, len(ca.authzs))
+        ca.authzs = append(ca.authzs, &fakeAuthz{
+            domain:     strings.TrimPrefix(id.Value, "*."),
+            wildcard:   strings.HasPrefix(id.Value, "*."),
+            token:      fmt.Sprintf("token-%d", len(ca.authzs)),
+            thumbprint: thumbprint,
+            status:     acme.StatusPending,
+        })
+    }
+    ca.orders = append(ca.orders, order)
+    n := len(ca.orders) - 1
+    ca.reply(w, http.StatusCreated, ca.orderURL(n), ca.orderJSON(n))
+}
+
+// validate checks the TXT record for an accepted challenge right away
+func (ca *fakeCA) validate(n int) {
+    authz := ca.authzs[n]
+    if authz.status != acme.StatusPending {
+        return
+    }
+    sum := sha256.Sum256([]byte(authz.token + "." + authz.thumbprint))
+    if ca.dns.lookup("_acme-challenge."+authz.domain) == base64.RawURLEncoding.EncodeToString(sum[:]) {
+        authz.status = acme.StatusValid
+    } else {
+        authz.status = acme.StatusInvalid
+    }
+}
+
+func (ca *fakeCA) finalize(w http.ResponseWriter, n int, payload []byte) {
+    order := ca.orders[n]
+    if status := ca.orderStatus(n); status != acme.StatusReady {
+        ca.problem(w, http.StatusForbidden, "orderNotReady", "order is "+status)
+        return
+    }
+    var req struct {
+        CSR string `json:"csr"`
+    }
+    if err := json.Unmarshal(payload, &req); err != nil {
+        ca.problem(w, http.StatusBadRequest, "malformed", err.Error())
+        return
+    }
+    der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+    if err != nil {
+        ca.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+        return
+    }
+    csr, err := x509.ParseCertificateRequest(der)
+    if err == nil {
+        err = csr.CheckSignature()
+    }
+    if err != nil {
+        ca.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+        return
+    }
+    names := append([]string(nil), csr.DNSNames...)
+    ordered := append([]string(nil), order.names...)
+    sort.Strings(names)
+    sort.Strings(ordered)
+    if strings.Join(names, ",") != strings.Join(ordered, ",") {
+        ca.problem(w, http.StatusBadRequest, "badCSR", "csr names do not match the order")
+        return
+    }
+    
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(int64(n + 2)),
+        Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+        DNSNames:     csr.DNSNames,
+        NotBefore:    time.Now().Add(-ca.age),
+        NotAfter:     time.Now().Add(ca.validity - ca.age),
+        KeyUsage:     x509.KeyUsageDigitalSignature,
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    }
+    leaf, err := x509.CreateCertificate(rand.Reader, template, ca.root, csr.PublicKey, ca.rootKey)
+    if err != nil {
+        ca.t.Errorf("fake ca failed to sign: %v", err)
+        ca.problem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+        return
+    }
+    order.cert = [][]byte{leaf, ca.root.Raw}
+    ca.reply(w, http.StatusOK, ca.orderURL(n), ca.orderJSON(n))
+}
+
+func (ca *fakeCA) orderURL(n int) string {
+    return fmt.Sprintf("%s/order/%d", ca.server.URL, n)
+}
+
+func (ca *fakeCA) orderStatus(n int) string {
+    order := ca.orders[n]
+    if order.cert != nil {
+        return acme.StatusValid
+    }
+    status := acme.StatusReady
+    for _, i := range order.authzs {
+        switch ca.authzs[i].status {
+        case acme.StatusInvalid:
+            return acme.StatusInvalid
+        case acme.StatusPending:
+            status = acme.StatusPending
+        }
+    }
+    return status
+}
+
+func (ca *fakeCA) orderJSON(n int) map[string]interface{} {
+    order := ca.orders[n]
+    identifiers := make([]map[string]string, 0, len(order.names))
+    authzs := make([]string, 0, len(order.authzs))
+    for i, name := range order.names {
+        identifiers = append(identifiers, map[string]string{"type": "dns", "value": name})
+        authzs = append(authzs, fmt.Sprintf("%s/authz/%d", ca.server.URL, order.authzs[i]))
+    }
+    body := map[string]interface{}{
+        "status":         ca.orderStatus(n),
+        "identifiers":    identifiers,
+        "authorizations": authzs,
+        "finalize":       fmt.Sprintf("%s/finalize/%d", ca.server.URL, n),
+    }
+    if order.cert != nil {
+        body["certificate"] = fmt.Sprintf("%s/cert/%d", ca.server.URL, n)
+    }
+    return body
+}
+
+func (ca *fakeCA) authzJSON(n int) map[string]interface{} {
+    authz := ca.authzs[n]
+    return map[string]interface{}{
+        "identifier": map[string]string{"type": "dns", "value": authz.domain},
+        "status":     authz.status,
+        "wildcard":   authz.wildcard,
+        "challenges": []interface{}{ca.challengeJSON(n)},
+    }
+}
+
+func (ca *fakeCA) challengeJSON(n int) map[string]interface{} {
+    authz := ca.authzs[n]
+    status := authz.status
+    body := map[string]interface{}{
+        "type":   "dns-01",
+        "url":    fmt.Sprintf("%s/challenge/%d", ca.server.URL, n),
+        "token":  authz.token,
+        "status": status,
+    }
+    if status == acme.StatusInvalid {
+        body["error"] = map[string]string{
+            "type":   "urn:ietf:params:acme:error:unauthorized",
+            "detail": "incorrect TXT record",
+        }
+    }
+    return body
+}
+
+func (ca *fakeCA) reply(w http.ResponseWriter, status int, location string, body interface{}) {
+    if location != "" {
+        w.Header().Set("Location", location)
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(body)
+}
+
+func (ca *fakeCA) problem(w http.ResponseWriter, status int, kind, detail string) {
+    w.Header().Set("Content-Type", "application/problem+json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(map[string]string{
+        "type":   "urn:ietf:params:acme:error:" + kind,
+        "detail": detail,
+    })
+}
+
+func newWildcardManager(ca *fakeCA, dns DNSProvider, cache autocert.Cache) *Manager {
+    return &Manager{
+        domain: "example.com",
+        client: ca.client(),
+        cache:  cache,
+        dns:    dns,
+    }
+}
+
+func TestObtainWildcard(t *testing.T) {
+    dns := newFakeDNS()
+    ca := newFakeCA(t, dns)
+    cache := autocert.DirCache(t.TempDir())
+    m := newWildcardManager(ca, dns, cache)
+    
+    if err := m.loadWildcard(context.Background()); err != nil {
+        t.Fatalf("loadWildcard: %v", err)
+    }
+    cert := m.currentWildcard()
+    if cert == nil {
+        t.Fatal("no wildcard certificate after loading")
+    }
+    
+    // the chain verifies for the apex and any single label subdomain
+    roots := x509.NewCertPool()
+    roots.AddCert(ca.root)
+    for _, name := range []string{"example.com", "app.example.com"} {
+        if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+            t.Errorf("certificate does not verify for %s: %v", name, err)
+        }
+    }
+    if _, ok := cert.PrivateKey.(*ecdsa.PrivateKey); !ok || len(cert.Certificate) != 2 {
+        t.Errorf("certificate has key %T and %d chain entries, want an ecdsa key and the full chain", cert.PrivateKey, len(cert.Certificate))
+    }
+    
+    // both authorizations share a record name, they are solved one after
+    // the other and every record is cleaned up
+    if dns.presents != 2 || dns.cleanups != 2 || dns.overlap || len(dns.records) != 0 {
+        t.Errorf("dns presents %d, cleanups %d, overlap %v, left %v", dns.presents, dns.cleanups, dns.overlap, dns.records)
+    }
+    
+    if _, err := cache.Get(context.Background(), "wildcard+example.com"); err != nil {
+        t.Errorf("certificate not cached: %v", err)
+    }
+    if _, err := cache.Get(context.Background(), accountKey); err != nil {
+        t.Errorf("account key not cached: %v", err)
+    }
+    
+    served, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "App.Example.com"})
+    if err != nil || served != cert {
+        t.Errorf("GetCertificate did not serve the wildcard: %v", err)
+    }
+}
+
+func TestWildcardFromCache(t *testing.T) {
+    dns := newFakeDNS()
+    ca := newFakeCA(t, dns)
+    cache := autocert.DirCache(t.TempDir())
+    
+    if err := newWildcardManager(ca, dns, cache).loadWildcard(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    
+    // a restart serves the cached certificate without a new order
+    m := newWildcardManager(ca, dns, cache)
+    if err := m.loadWildcard(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    if m.currentWildcard() == nil {
+        t.Fatal("cached certificate not loaded")
+    }
+    if n := ca.orderCount(); n != 1 {
+        t.Fatalf("%d orders, want the cached certificate to be reused", n)
+    }
+}
+
+func TestWildcardRenewal(t *testing.T) {
+    dns := newFakeDNS()
+    ca := newFakeCA(t, dns)
+    ca.age = 70 * 24 * time.Hour
+    cache := autocert.DirCache(t.TempDir())
+    
+    if err := newWildcardManager(ca, dns, cache).loadWildcard(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    
+    // the cached certificate is due for renewal, a new one is ordered with
+    // the cached account
+    ca.age = time.Hour
+    m := newWildcardManager(ca, dns, cache)
+    if err := m.loadWildcard(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    if n := ca.orderCount(); n != 2 {
+        t.Fatalf("%d orders, want a renewal", n)
+    }
+    if n := ca.accountCount(); n != 1 {
+        t.Fatalf("%d accounts, want the cached account reused", n)
+    }
+    if left := time.Until(m.currentWildcard().Leaf.NotAfter); left < renewBefore {
+        t.Fatalf("renewed certificate only valid for %s", left)
+    }
+}
+
+func TestDueForRenewal(t *testing.T) {
+    day := 24 * time.Hour
+    tests := []struct {
+        lifetime time.Duration
+        left     time.Duration
+        due      bool
+    }{
+        {90 * day, 60 * day, false},
+        {90 * day, 31 * day, false},
+        {90 * day, 29 * day, true},
+        // six day certificates are kept until two days are left
+        {6 * day, 5 * day, false},
+        {6 * day, 3 * day, false},
+        {6 * day, day, true},
+        {6 * day, -day, true},
+    }
+    for _, tt := range tests {
+        notAfter := time.Now().Add(tt.left)
+        cert := &x509.Certificate{NotBefore: notAfter.Add(-tt.lifetime), NotAfter: notAfter}
+        if got := dueForRenewal(cert); got != tt.due {
+            t.Errorf("%s certificate with %s left: due %v, want %v", tt.lifetime, tt.left, got, tt.due)
+        }
+    }
+}
+
+func TestWildcardKeepsCachedCertificateWhenRenewalFails(t *testing.T) {
+    dns := newFakeDNS()
+    ca := newFakeCA(t, dns)
+    ca.age = 70 * 24 * time.Hour
+    cache := autocert.DirCache(t.TempDir())
+    
+    if err := newWildcardManager(ca, dns, cache).loadWildcard(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    
+    ca.mutex.Lock()
+    ca.refuse = true
+    ca.mutex.Unlock()
+    m := newWildcardManager(ca, dns, cache)
+    if err := m.loadWildcard(context.Background()); err != nil {
+        t.Fatalf("loadWildcard failed with a usable cached certificate: %v", err)
+    }
+    if m.currentWildcard() == nil {
+        t.Fatal("cached certificate dropped after a failed renewal")
+    }
+    
+    // without a cached certificate the failure is fatal
+    m = newWildcardManager(ca, dns, autocert.DirCache(t.TempDir()))
+    if err := m.loadWildcard(context.Background()); err == nil {
+        t.Fatal("no error when the ca refuses the order")
+    }
+}
+
+func TestWildcardWrongRecord(t *testing.T) {
+    dns := newFakeDNS()
+    dns.corrupt = true
+    ca := newFakeCA(t, dns)
+    m := newWildcardManager(ca, dns, autocert.DirCache(t.TempDir()))
+    
+    err := m.loadWildcard(context.Background())
+    if err == nil || !strings.Contains(err.Error(), "dns-01 validation for example.com failed") {
+        t.Fatalf("loadWildcard error = %v, want a failed validation", err)
+    }
+    if m.currentWildcard() != nil {
+        t.Fatal("wildcard set after a failed validation")
+    }
+    if dns.cleanups != dns.presents || len(dns.records) != 0 {
+        t.Fatalf("records left behind: %v", dns.records)
+    }
+}
+
+func TestExecProvider(t *testing.T) {
+    dir := t.TempDir()
+    hook := dir + "/hook.sh"
+    script := "#!/bin/sh\necho \"$@\" >> " + dir + "/calls\n[ \"$3\" != fail ] || { echo no such zone; exit 1; }\n"
+    if err := os.WriteFile(hook, []byte(script), 0755); err != nil {
+        t.Fatal(err)
+    }
+    
+    p := NewExecProvider(hook)
+    ctx := context.Background()
+    if err := p.Present(ctx, "_acme-challenge.example.com", "value"); err != nil {
+        t.Fatal(err)
+    }
+    if err := p.CleanUp(ctx, "_acme-challenge.example.com", "value"); err != nil {
+        t.Fatal(err)
+    }
+    err := p.Present(ctx, "_acme-challenge.example.com", "fail")
+    if err == nil || !strings.Contains(err.Error(), "no such zone") {
+        t.Fatalf("Present error = %v, want the hook's output", err)
+    }
+    
+    calls, err := os.ReadFile(dir + "/calls")
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := "present _acme-challenge.example.com value\ncleanup _acme-challenge.example.com value\npresent _acme-challenge.example.com fail\n"
+    if string(calls) != want {
+        t.Fatalf("hook called with\n%s\nwant\n%s", calls, want)
+    }
+}
\ No newline at end of file
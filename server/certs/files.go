@@ -0,0 +1,168 @@
+package certs
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+    
+    "mole/server/config"
+)
+
+// Reloader serves certificates from pem files and picks up new ones, such
+// as those left by a certbot renewal, without a restart. several pairs can
+// be loaded, the handshake's server name selects between them.
+type Reloader struct {
+    pairs []config.CertPair
+    
+    mutex    sync.RWMutex
+    certs    []*tls.Certificate
+    modTimes []time.Time
+}
+
+// NewReloader loads every pair, failing if any of them cannot be used
+func NewReloader(pairs []config.CertPair) (*Reloader, error) {
+    if len(pairs) == 0 {
+        return nil, fmt.Errorf("no certificates configured")
+    }
+    r := &Reloader{pairs: pairs}
+    if err := r.Reload(); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+// Reload reads every pair again. the new set replaces the old one only when
+// all of them load, so a half written renewal keeps the current certificates.
+func (r *Reloader) Reload() error {
+    certs := make([]*tls.Certificate, 0, len(r.pairs))
+    modTimes := make([]time.Time, 0, len(r.pairs))
+    for _, pair := range r.pairs {
+        modTime, err := pairModTime(pair)
+        if err != nil {
+            return err
+        }
+        cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+        if err != nil {
+            return fmt.Errorf("failed to load %s: %v", pair.CertFile, err)
+        }
+        if cert.Leaf == nil {
+            cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+            if err != nil {
+                return fmt.Errorf("failed to parse %s: %v", pair.CertFile, err)
+            }
+        }
+        certs = append(certs, &cert)
+        modTimes = append(modTimes, modTime)
+    }
+    
+    r.mutex.Lock()
+    r.certs = certs
+    r.modTimes = modTimes
+    r.mutex.Unlock()
+    
+    for _, cert := range certs {
+        log.Printf("loaded certificate for %s, valid until %s", strings.Join(cert.Leaf.DNSNames, ", "), cert.Leaf.NotAfter.Format(time.RFC3339))
+    }
+    return nil
+}
+
+// Watch reloads the certificates when their files change, checked every
+// interval, and whenever the process gets SIGHUP. a zero interval leaves
+// only SIGHUP.
+func (r *Reloader) Watch(interval time.Duration) {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    
+    var tick <-chan time.Time
+    if interval > 0 {
+        ticker := time.NewTicker(interval)
+        tick = ticker.C
+    }
+    
+    go func() {
+        for {
+            select {
+            case <-hup:
+                log.Printf("got SIGHUP, reloading certificates")
+            case <-tick:
+                if !r.changed() {
+                    continue
+                }
+                log.Printf("certificate files changed, reloading")
+            }
+            if err := r.Reload(); err != nil {
+                log.Printf("[ERROR] keeping current certificates: %v", err)
+            }
+        }
+    }()
+}
+
+// changed reports whether any file was modified since the last reload. a
+// missing file counts as a change so the error gets logged.
+func (r *Reloader) changed() bool {
+    r.mutex.RLock()
+    defer r.mutex.RUnlock()
+    
+    for i, pair := range r.pairs {
+        modTime, err := pairModTime(pair)
+        if err != nil || !modTime.Equal(r.modTimes[i]) {
+            return true
+        }
+    }
+    return false
+}
+
+// GetCertificate implements tls.Config.GetCertificate. names are matched
+// exactly, then against wildcards, and the first pair is the fallback for
+// clients that send no server name.
+func (r *Reloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+    r.mutex.RLock()
+    defer r.mutex.RUnlock()
+    
+    name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+    if name == "" {
+        return r.certs[0], nil
+    }
+    for _, cert := range r.certs {
+        for _, dnsName := range cert.Leaf.DNSNames {
+            if strings.EqualFold(dnsName, name) {
+                return cert, nil
+            }
+        }
+    }
+    for _, cert := range r.certs {
+        if cert.Leaf.VerifyHostname(name) == nil {
+            return cert, nil
+        }
+    }
+    return r.certs[0], nil
+}
+
+// TLSConfig returns a server config that always serves the current certificates
+func (r *Reloader) TLSConfig() *tls.Config {
+    return &tls.Config{GetCertificate: r.GetCertificate}
+}
+
+// pairModTime is the latest modification time of the pair's files. certbot
+// swaps symlinks, stat follows them to the new files.
+func pairModTime(pair config.CertPair) (time.Time, error) {
+    cert, err := os.Stat(pair.CertFile)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("failed to read %s: %v", pair.CertFile, err)
+    }
+    key, err := os.Stat(pair.KeyFile)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("failed to read %s: %v", pair.KeyFile, err)
+    }
+    if key.ModTime().After(cert.ModTime()) {
+        return key.ModTime(), nil
+    }
+    return cert.ModTime(), nil
+}
\ No newline at end of file
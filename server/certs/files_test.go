@@ -0,0 +1,178 @@
+package certs
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "math/big"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    
+    "mole/server/config"
+)
+
+// writePair writes a self signed certificate for names into dir and returns
+// the pair pointing at it
+func writePair(t *testing.T, dir, file string, names ...string) config.CertPair {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(time.Now().UnixNano()),
+        Subject:      pkix.Name{CommonName: names[0]},
+        DNSNames:     names,
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(24 * time.Hour),
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    pair := config.CertPair{
+        CertFile: filepath.Join(dir, file+".crt"),
+        KeyFile:  filepath.Join(dir, file+".key"),
+    }
+    if err := os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+        t.Fatal(err)
+    }
+    return pair
+}
+
+func servedName(t *testing.T, r *Reloader, serverName string) string {
+    t.Helper()
+    cert, err := r.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+    if err != nil {
+        t.Fatalf("GetCertificate(%q): %v", serverName, err)
+    }
+    return cert.Leaf.DNSNames[0]
+}
+
+func TestReloaderSelectsByServerName(t *testing.T) {
+    dir := t.TempDir()
+    r, err := NewReloader([]config.CertPair{
+        writePair(t, dir, "main", "example.com"),
+        writePair(t, dir, "wildcard", "*.example.com"),
+        writePair(t, dir, "exact", "api.example.com"),
+        writePair(t, dir, "other", "other.org"),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    tests := []struct {
+        serverName string
+        want       string
+    }{
+        {"example.com", "example.com"},
+        {"app.example.com", "*.example.com"},
+        // an exact name wins over a wildcard that was loaded first
+        {"api.example.com", "api.example.com"},
+        {"API.Example.com.", "api.example.com"},
+        {"other.org", "other.org"},
+        // wildcards cover a single label only
+        {"a.b.example.com", "example.com"},
+        {"unknown.net", "example.com"},
+        {"", "example.com"},
+    }
+    for _, tt := range tests {
+        if got := servedName(t, r, tt.serverName); got != tt.want {
+            t.Errorf("server name %q got the certificate for %s, want %s", tt.serverName, got, tt.want)
+        }
+    }
+}
+
+func TestNewReloaderFailsOnBrokenPair(t *testing.T) {
+    dir := t.TempDir()
+    good := writePair(t, dir, "good", "example.com")
+    
+    if _, err := NewReloader(nil); err == nil {
+        t.Error("no error without certificates")
+    }
+    if _, err := NewReloader([]config.CertPair{good, {CertFile: filepath.Join(dir, "missing.crt"), KeyFile: good.KeyFile}}); err == nil {
+        t.Error("no error for a missing certificate file")
+    }
+    
+    // a key that does not belong to the certificate
+    other := writePair(t, dir, "other", "other.org")
+    if _, err := NewReloader([]config.CertPair{{CertFile: good.CertFile, KeyFile: other.KeyFile}}); err == nil {
+        t.Error("no error for a mismatched key")
+    }
+}
+
+func TestReloaderReload(t *testing.T) {
+    dir := t.TempDir()
+    first := writePair(t, dir, "first", "example.com")
+    second := writePair(t, dir, "second", "other.org")
+    r, err := NewReloader([]config.CertPair{first, second})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if r.changed() {
+        t.Fatal("changed right after loading")
+    }
+    
+    // a renewal writes new files in place
+    writePair(t, dir, "second", "renewed.org")
+    later := time.Now().Add(time.Minute)
+    os.Chtimes(second.CertFile, later, later)
+    if !r.changed() {
+        t.Fatal("new files not detected")
+    }
+    if err := r.Reload(); err != nil {
+        t.Fatal(err)
+    }
+    if got := servedName(t, r, "renewed.org"); got != "renewed.org" {
+        t.Fatalf("after reload got the certificate for %s, want renewed.org", got)
+    }
+    if r.changed() {
+        t.Fatal("changed right after reloading")
+    }
+}
+
+func TestReloaderKeepsCertificatesOnFailedReload(t *testing.T) {
+    dir := t.TempDir()
+    first := writePair(t, dir, "first", "example.com")
+    second := writePair(t, dir, "second", "other.org")
+    r, err := NewReloader([]config.CertPair{first, second})
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    // the first pair is renewed but the second is half written, none of
+    // the new set may be served
+    writePair(t, dir, "first", "renewed.com")
+    if err := os.WriteFile(second.KeyFile, []byte("garbage"), 0600); err != nil {
+        t.Fatal(err)
+    }
+    if err := r.Reload(); err == nil {
+        t.Fatal("reload succeeded with a broken key")
+    }
+    if got := servedName(t, r, "renewed.com"); got != "example.com" {
+        t.Fatalf("got the certificate for %s after a failed reload, want the old example.com", got)
+    }
+    if got := servedName(t, r, "other.org"); got != "other.org" {
+        t.Fatalf("got the certificate for %s, want other.org", got)
+    }
+    
+    // a missing file counts as a change so the failure keeps getting logged
+    os.Remove(second.CertFile)
+    if !r.changed() {
+        t.Fatal("missing file not reported as a change")
+    }
+}
\ No newline at end of file
@@ -0,0 +1,155 @@
+package certs
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+    
+    "golang.org/x/crypto/acme"
+    "golang.org/x/crypto/acme/autocert"
+    
+    "mole/server/config"
+)
+
+// Manager obtains and renews certificates from an acme ca. hosts get their
+// own certificate on their first tls handshake through http-01 or
+// tls-alpn-01, unless a dns provider is set up, in which case one wildcard
+// certificate covers the domain and every subdomain.
+type Manager struct {
+    domain   string
+    autocert *autocert.Manager
+    client   *acme.Client
+    cache    autocert.Cache
+    dns      DNSProvider
+    email    string
+    
+    mutex    sync.RWMutex
+    wildcard *tls.Certificate
+}
+
+// NewManager sets up acme from the config. allowed reports whether a
+// subdomain may get a certificate, on demand issuance is limited to it so
+// random hostnames cannot burn through the ca rate limits.
+func NewManager(cfg *config.Config, allowed func(subdomain string) bool) (*Manager, error) {
+    httpClient := http.DefaultClient
+    if cfg.ACMECAFile != "" {
+        // a private ca, such as a local test server, is only trusted when asked to
+        pem, err := os.ReadFile(cfg.ACMECAFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read acme ca file: %v", err)
+        }
+        roots := x509.NewCertPool()
+        if !roots.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("no certificates found in %s", cfg.ACMECAFile)
+        }
+        httpClient = &http.Client{
+            Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+            Timeout:   30 * time.Second,
+        }
+    }
+    
+    cache := autocert.DirCache(cfg.ACMECache)
+    client := &acme.Client{DirectoryURL: cfg.ACMEDirectory, HTTPClient: httpClient}
+    
+    m := &Manager{
+        domain: cfg.Domain,
+        client: client,
+        cache:  cache,
+        email:  cfg.Email,
+    }
+    m.autocert = &autocert.Manager{
+        Prompt: autocert.AcceptTOS,
+        Cache:  cache,
+        Email:  cfg.Email,
+        Client: &acme.Client{DirectoryURL: cfg.ACMEDirectory, HTTPClient: httpClient},
+        HostPolicy: func(ctx context.Context, host string) error {
+            // challenge requests pass the Host header, port included
+            if name, _, err := net.SplitHostPort(host); err == nil {
+                host = name
+            }
+            host = strings.ToLower(host)
+            if host == m.domain {
+                return nil
+            }
+            subdomain := strings.TrimSuffix(host, "."+m.domain)
+            if subdomain == host || strings.Contains(subdomain, ".") || !allowed(subdomain) {
+                return fmt.Errorf("no tunnel for host %s", host)
+            }
+            return nil
+        },
+    }
+    
+    if cfg.ACMEDNSHook != "" {
+        m.dns = NewExecProvider(cfg.ACMEDNSHook)
+    }
+    return m, nil
+}
+
+// Start obtains the wildcard certificate when a dns provider is configured
+// and keeps it renewed. on demand certificates need no setup.
+func (m *Manager) Start() error {
+    if m.dns == nil {
+        return nil
+    }
+    
+    if err := m.loadWildcard(context.Background()); err != nil {
+        return err
+    }
+    go m.renewWildcard()
+    return nil
+}
+
+// GetCertificate serves the wildcard certificate for the hosts it covers and
+// falls back to on demand certificates for everything else
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+    m.mutex.RLock()
+    wildcard := m.wildcard
+    m.mutex.RUnlock()
+    
+    if wildcard != nil && m.covers(strings.ToLower(hello.ServerName)) && !isChallenge(hello) {
+        return wildcard, nil
+    }
+    return m.autocert.GetCertificate(hello)
+}
+
+// TLSConfig returns a tls config that serves acme certificates and answers
+// tls-alpn-01 challenges
+func (m *Manager) TLSConfig() *tls.Config {
+    return &tls.Config{
+        GetCertificate: m.GetCertificate,
+        NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+    }
+}
+
+// HTTPHandler answers http-01 challenges and passes every other request
+// to fallback, or redirects it to https when fallback is nil
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+    return m.autocert.HTTPHandler(fallback)
+}
+
+// covers reports whether the wildcard certificate is valid for host
+func (m *Manager) covers(host string) bool {
+    if host == m.domain {
+        return true
+    }
+    subdomain := strings.TrimSuffix(host, "."+m.domain)
+    return subdomain != host && subdomain != "" && !strings.Contains(subdomain, ".")
+}
+
+// isChallenge reports whether the handshake is a tls-alpn-01 validation,
+// which autocert has to answer itself
+func isChallenge(hello *tls.ClientHelloInfo) bool {
+    return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+func (m *Manager) logf(format string, args ...interface{}) {
+    log.Printf("[ACME] "+format, args...)
+}
\ No newline at end of file
@@ -0,0 +1,143 @@
+package certs
+
+import (
+    "context"
+    "crypto/tls"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    
+    "golang.org/x/crypto/acme"
+    
+    "mole/server/config"
+)
+
+func newTestManager(t *testing.T, subdomains ...string) *Manager {
+    t.Helper()
+    cfg := &config.Config{Domain: "example.com", ACMECache: t.TempDir()}
+    allowed := func(subdomain string) bool {
+        for _, s := range subdomains {
+            if s == subdomain {
+                return true
+            }
+        }
+        return false
+    }
+    m, err := NewManager(cfg, allowed)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return m
+}
+
+func TestManagerHostPolicy(t *testing.T) {
+    m := newTestManager(t, "app")
+    
+    tests := []struct {
+        host  string
+        allow bool
+    }{
+        {"example.com", true},
+        {"app.example.com", true},
+        {"APP.Example.com", true},
+        {"app.example.com:443", true},
+        // only subdomains with a tunnel may use up the rate limits
+        {"random.example.com", false},
+        {"a.app.example.com", false},
+        {"example.com.evil.org", false},
+        {"evil.org", false},
+        {".example.com", false},
+    }
+    for _, tt := range tests {
+        err := m.autocert.HostPolicy(context.Background(), tt.host)
+        if allowed := err == nil; allowed != tt.allow {
+            t.Errorf("host policy for %q allowed %v, want %v (%v)", tt.host, allowed, tt.allow, err)
+        }
+    }
+}
+
+// TestManagerOnDemandPolicy checks that handshakes for hosts without a
+// tunnel fail before anything is sent to the ca
+func TestManagerOnDemandPolicy(t *testing.T) {
+    var requests atomic.Int32
+    ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requests.Add(1)
+        // a client error, server errors would be retried
+        http.Error(w, "no directory", http.StatusBadRequest)
+    }))
+    defer ca.Close()
+    
+    connected := map[string]bool{}
+    cfg := &config.Config{Domain: "example.com", ACMEDirectory: ca.URL, ACMECache: t.TempDir()}
+    m, err := NewManager(cfg, func(subdomain string) bool { return connected[subdomain] })
+    if err != nil {
+        t.Fatal(err)
+    }
+    hello := &tls.ClientHelloInfo{ServerName: "app.example.com", SupportedProtos: []string{"http/1.1"}}
+    
+    if _, err := m.GetCertificate(hello); err == nil {
+        t.Fatal("certificate for a subdomain without a tunnel")
+    }
+    if n := requests.Load(); n != 0 {
+        t.Fatalf("refused host sent %d requests to the ca", n)
+    }
+    
+    // once the tunnel connects the same name goes to the ca
+    connected["app"] = true
+    if _, err := m.GetCertificate(hello); err == nil {
+        t.Fatal("certificate from a failing ca")
+    }
+    if requests.Load() == 0 {
+        t.Fatal("allowed host never reached the ca")
+    }
+}
+
+func TestManagerCovers(t *testing.T) {
+    m := newTestManager(t)
+    
+    tests := []struct {
+        host   string
+        covers bool
+    }{
+        {"example.com", true},
+        {"app.example.com", true},
+        {"a.b.example.com", false},
+        {".example.com", false},
+        {"notexample.com", false},
+        {"example.org", false},
+    }
+    for _, tt := range tests {
+        if got := m.covers(tt.host); got != tt.covers {
+            t.Errorf("covers(%q) = %v, want %v", tt.host, got, tt.covers)
+        }
+    }
+}
+
+func TestManagerGetCertificate(t *testing.T) {
+    m := newTestManager(t)
+    wildcard := &tls.Certificate{}
+    m.setWildcard(wildcard)
+    
+    cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "App.example.com", SupportedProtos: []string{"h2", "http/1.1"}})
+    if err != nil || cert != wildcard {
+        t.Fatalf("covered host did not get the wildcard: %v", err)
+    }
+    
+    // tls-alpn-01 validations are left to autocert, which has no token
+    // for them here
+    cert, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.example.com", SupportedProtos: []string{acme.ALPNProto}})
+    if err == nil || cert == wildcard {
+        t.Fatal("tls-alpn-01 handshake got the wildcard certificate")
+    }
+}
+
+func TestManagerStartWithoutDNS(t *testing.T) {
+    m := newTestManager(t)
+    if err := m.Start(); err != nil {
+        t.Fatalf("Start without a dns hook: %v", err)
+    }
+    if m.currentWildcard() != nil {
+        t.Fatal("wildcard certificate without a dns hook")
+    }
+}
\ No newline at end of file
@@ -0,0 +1,151 @@
+package certs
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "net"
+    "net/http"
+    "os"
+    "testing"
+    "time"
+    
+    "mole/server/config"
+)
+
+// TestPebbleWildcard runs the dns-01 flow against a real acme server. it
+// needs pebble started with -dnsserver pointing at pebble-challtestsrv,
+// and a hook that publishes records through challtestsrv's management api:
+//
+//	MOLE_PEBBLE_DIRECTORY=https://127.0.0.1:14000/dir
+//	MOLE_PEBBLE_CA_FILE=test/certs/pebble.minica.pem
+//	MOLE_PEBBLE_DNS_HOOK=/path/to/hook.sh
+func TestPebbleWildcard(t *testing.T) {
+    directory := os.Getenv("MOLE_PEBBLE_DIRECTORY")
+    if directory == "" {
+        t.Skip("MOLE_PEBBLE_DIRECTORY not set")
+    }
+    
+    // a fresh domain each run so pebble cannot reuse earlier authorizations
+    cfg := &config.Config{
+        Domain:        fmt.Sprintf("t%d.test", time.Now().UnixNano()),
+        ACMEDirectory: directory,
+        ACMECache:     t.TempDir(),
+        ACMECAFile:    os.Getenv("MOLE_PEBBLE_CA_FILE"),
+        ACMEDNSHook:   os.Getenv("MOLE_PEBBLE_DNS_HOOK"),
+    }
+    if cfg.ACMEDNSHook == "" {
+        t.Skip("MOLE_PEBBLE_DNS_HOOK not set")
+    }
+    m, err := NewManager(cfg, func(string) bool { return false })
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+    defer cancel()
+    if err := m.loadWildcard(ctx); err != nil {
+        t.Fatalf("loadWildcard: %v", err)
+    }
+    
+    cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "app." + cfg.Domain})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, name := range []string{cfg.Domain, "app." + cfg.Domain} {
+        if err := cert.Leaf.VerifyHostname(name); err != nil {
+            t.Errorf("certificate does not cover %s: %v", name, err)
+        }
+    }
+    
+    // a restart picks the certificate up from the cache
+    again, err := NewManager(cfg, func(string) bool { return false })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := again.loadWildcard(ctx); err != nil {
+        t.Fatal(err)
+    }
+    if !again.currentWildcard().Leaf.Equal(cert.Leaf) {
+        t.Fatal("restart ordered a new certificate instead of using the cache")
+    }
+}
+
+// TestPebbleHTTP01 issues an on demand certificate through http-01. pebble
+// has to resolve every name to this host, pebble-challtestsrv does that by
+// default, and nothing else may listen on the http-01 port:
+//
+//	pebble-challtestsrv -http01 "" -tlsalpn01 "" -https01 "" -doh ""
+//	MOLE_PEBBLE_DIRECTORY=https://127.0.0.1:14000/dir
+//	MOLE_PEBBLE_CA_FILE=test/certs/pebble.minica.pem
+//	MOLE_PEBBLE_HTTP_PORT=5002
+func TestPebbleHTTP01(t *testing.T) {
+    directory := os.Getenv("MOLE_PEBBLE_DIRECTORY")
+    if directory == "" {
+        t.Skip("MOLE_PEBBLE_DIRECTORY not set")
+    }
+    port := os.Getenv("MOLE_PEBBLE_HTTP_PORT")
+    if port == "" {
+        port = "5002"
+    }
+    
+    cfg := &config.Config{
+        Domain:        fmt.Sprintf("t%d.test", time.Now().UnixNano()),
+        ACMEDirectory: directory,
+        ACMECache:     t.TempDir(),
+        ACMECAFile:    os.Getenv("MOLE_PEBBLE_CA_FILE"),
+    }
+    // only app has a tunnel connected
+    m, err := NewManager(cfg, func(subdomain string) bool { return subdomain == "app" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    // pebble fetches the challenge from the http port of the name
+    challenges, err := net.Listen("tcp", ":"+port)
+    if err != nil {
+        t.Fatal(err)
+    }
+    challengeServer := &http.Server{Handler: m.HTTPHandler(nil)}
+    go challengeServer.Serve(challenges)
+    defer challengeServer.Close()
+    
+    tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", m.TLSConfig())
+    if err != nil {
+        t.Fatal(err)
+    }
+    tlsServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+    go tlsServer.Serve(tlsListener)
+    defer tlsServer.Close()
+    
+    handshake := func(subdomain string) (*tls.Conn, error) {
+        dialer := &net.Dialer{Timeout: 2 * time.Minute}
+        return tls.DialWithDialer(dialer, "tcp", tlsListener.Addr().String(), &tls.Config{
+            ServerName: subdomain + "." + cfg.Domain,
+            // pebble generates a new root at every start, the leaf is checked below
+            InsecureSkipVerify: true,
+        })
+    }
+    
+    conn, err := handshake("app")
+    if err != nil {
+        t.Fatalf("handshake for a connected subdomain: %v", err)
+    }
+    leaf := conn.ConnectionState().PeerCertificates[0]
+    conn.Close()
+    if err := leaf.VerifyHostname("app." + cfg.Domain); err != nil {
+        t.Fatal(err)
+    }
+    if leaf.Issuer.String() == leaf.Subject.String() {
+        t.Fatalf("self signed certificate for %s", leaf.Subject)
+    }
+    
+    // hosts without a tunnel never reach the ca
+    if conn, err := handshake("unknown"); err == nil {
+        conn.Close()
+        t.Fatal("handshake for a subdomain without a tunnel succeeded")
+    }
+    if _, err := m.autocert.Cache.Get(context.Background(), "unknown."+cfg.Domain); err == nil {
+        t.Fatal("certificate cached for a subdomain without a tunnel")
+    }
+}
\ No newline at end of file
@@ -2,10 +2,15 @@ package config
 
 import (
     "flag"
+    "fmt"
     "os"
+    "regexp"
     "strconv"
+    "strings"
+    "time"
     
     "github.com/joho/godotenv"
+    "golang.org/x/net/idna"
 )
 
 type Config struct {
@@ -14,6 +19,84 @@ type Config struct {
     CertFile string
     KeyFile  string
     UseHTTPS bool
+    Email    string
+    
+    // every certificate served when acme is off, CertFile and KeyFile first.
+    // the files are checked for changes every CertReloadInterval and on
+    // SIGHUP, the right pair is picked by sni.
+    Certificates       []CertPair
+    CertReloadInterval time.Duration
+    
+    // with https on, plain http is served on HTTPPort as well, zero turns
+    // it off. HTTPPolicy is what tunnels that do not pick one do there:
+    // "redirect" to https, serve "both", or "http-only". HSTSMaxAge, when
+    // set, is sent over https by tunnels that redirect.
+    HTTPPort   int
+    HTTPPolicy string
+    HSTSMaxAge time.Duration
+    
+    // certificates obtained from an acme ca instead of CertFile and KeyFile.
+    // http-01 challenges are answered on HTTPPort, ACMEDNSHook enables a
+    // dns-01 wildcard certificate.
+    ACME          bool
+    ACMEDirectory string
+    ACMECache     string
+    ACMECAFile    string
+    ACMEDNSHook   string
+    
+    // public port ranges for tcp and udp tunnels, zero disables them
+    TCPPortMin int
+    TCPPortMax int
+    UDPPortMin int
+    UDPPortMax int
+    
+    // udp peers silent for this long lose their session
+    UDPIdleTimeout time.Duration
+    
+    // most peers a udp tunnel relays at once, the least recently active
+    // session is closed to make room for a new one
+    UDPMaxSessions int
+    
+    // how long a dropped tunnel waits for its client to reconnect and
+    // resume it, zero closes it right away
+    ResumeGrace time.Duration
+    
+    // heartbeat pings, a client that does not answer within the timeout is
+    // evicted. a zero interval disables them.
+    PingInterval time.Duration
+    PingTimeout  time.Duration
+    
+    // how long a shutting down server waits for in-flight requests
+    ShutdownTimeout time.Duration
+    
+    // the admin api listens on AdminAddr, empty disables it. every call
+    // needs AdminToken as a bearer token.
+    AdminAddr  string
+    AdminToken string
+    
+    // prometheus metrics are served on MetricsAddr, empty disables them
+    MetricsAddr string
+    
+    // api tokens required to register tunnels, none leaves the server open
+    Tokens     []string
+    TokensFile string
+    
+    // subdomains held by token owners, as "subdomain:owner"
+    Reservations     []string
+    ReservationsFile string
+    
+    // subdomain policy. nil SubdomainReserved keeps the built in list,
+    // patterns, when set, are an allow list
+    SubdomainReserved  []string
+    SubdomainBlocked   []string
+    SubdomainPatterns  []*regexp.Regexp
+    SubdomainMaxLength int
+}
+
+// a certificate and its private key, both pem encoded files
+type CertPair struct {
+    CertFile string
+    KeyFile  string
 }
 
 func Load() (*Config, error) {
@@ -50,6 +133,163 @@ func Load() (*Config, error) {
         cfg.UseHTTPS = true
     }
     
+    // extra pairs for other names, as "cert.pem:key.pem"
+    var extraCerts []CertPair
+    if pairs := os.Getenv("MOLE_CERTS"); pairs != "" {
+        for _, pair := range strings.Split(pairs, ",") {
+            cert, key, found := strings.Cut(strings.TrimSpace(pair), ":")
+            if !found || cert == "" || key == "" {
+                return nil, fmt.Errorf("invalid MOLE_CERTS: %q is not cert:key", pair)
+            }
+            extraCerts = append(extraCerts, CertPair{CertFile: cert, KeyFile: key})
+        }
+    }
+    cfg.CertReloadInterval = time.Minute
+    if interval := os.Getenv("MOLE_CERT_RELOAD_INTERVAL"); interval != "" {
+        d, err := time.ParseDuration(interval)
+        if err != nil {
+            return nil, fmt.Errorf("invalid MOLE_CERT_RELOAD_INTERVAL: %v", err)
+        }
+        cfg.CertReloadInterval = d
+    }
+    
+    cfg.Email = os.Getenv("MOLE_EMAIL")
+    if acme := os.Getenv("MOLE_ACME"); acme == "true" {
+        cfg.ACME = true
+        cfg.UseHTTPS = true
+    }
+    cfg.ACMEDirectory = os.Getenv("MOLE_ACME_DIRECTORY")
+    cfg.ACMECache = os.Getenv("MOLE_ACME_CACHE")
+    cfg.ACMECAFile = os.Getenv("MOLE_ACME_CA_FILE")
+    cfg.ACMEDNSHook = os.Getenv("MOLE_ACME_DNS_HOOK")
+    
+    httpPort, httpPortSet := os.LookupEnv("MOLE_HTTP_PORT")
+    if httpPortSet {
+        p, err := strconv.Atoi(httpPort)
+        if err != nil || p < 0 || p > 65535 {
+            return nil, fmt.Errorf("invalid MOLE_HTTP_PORT: %q", httpPort)
+        }
+        cfg.HTTPPort = p
+    }
+    cfg.HTTPPolicy = os.Getenv("MOLE_HTTP_POLICY")
+    switch cfg.HTTPPolicy {
+    case "":
+        cfg.HTTPPolicy = "redirect"
+    case "redirect", "both", "http-only":
+    default:
+        return nil, fmt.Errorf("invalid MOLE_HTTP_POLICY: must be redirect, both or http-only")
+    }
+    if maxAge := os.Getenv("MOLE_HSTS_MAX_AGE"); maxAge != "" {
+        d, err := time.ParseDuration(maxAge)
+        if err != nil {
+            return nil, fmt.Errorf("invalid MOLE_HSTS_MAX_AGE: %v", err)
+        }
+        cfg.HSTSMaxAge = d
+    }
+    
+    if ports := os.Getenv("MOLE_TCP_PORTS"); ports != "" {
+        min, max, err := parsePortRange(ports)
+        if err != nil {
+            return nil, fmt.Errorf("invalid MOLE_TCP_PORTS: %v", err)
+        }
+        cfg.TCPPortMin, cfg.TCPPortMax = min, max
+    }
+    if ports := os.Getenv("MOLE_UDP_PORTS"); ports != "" {
+        min, max, err := parsePortRange(ports)
+        if err != nil {
+            return nil, fmt.Errorf("invalid MOLE_UDP_PORTS: %v", err)
+        }
+        cfg.UDPPortMin, cfg.UDPPortMax = min, max
+    }
+    if tokens := os.Getenv("MOLE_TOKENS"); tokens != "" {
+        cfg.Tokens = strings.Split(tokens, ",")
+    }
+    cfg.TokensFile = os.Getenv("MOLE_TOKENS_FILE")
+    if reservations := os.Getenv("MOLE_RESERVATIONS"); reservations != "" {
+        cfg.Reservations = strings.Split(reservations, ",")
+    }
+    cfg.ReservationsFile = os.Getenv("MOLE_RESERVATIONS_FILE")
+    
+    if reserved, ok := os.LookupEnv("MOLE_SUBDOMAIN_RESERVED"); ok {
+        cfg.SubdomainReserved = strings.Split(reserved, ",")
+    }
+    if blocked := os.Getenv("MOLE_SUBDOMAIN_BLOCKED"); blocked != "" {
+        cfg.SubdomainBlocked = strings.Split(blocked, ",")
+    }
+    // patterns are separated by whitespace since commas are valid in a regexp
+    for _, pattern := range strings.Fields(os.Getenv("MOLE_SUBDOMAIN_PATTERNS")) {
+        re, err := regexp.Compile(pattern)
+        if err != nil {
+            return nil, fmt.Errorf("invalid MOLE_SUBDOMAIN_PATTERNS: %v", err)
+        }
+        cfg.SubdomainPatterns = append(cfg.SubdomainPatterns, re)
+    }
+    if length := os.Getenv("MOLE_SUBDOMAIN_MAX_LENGTH"); length != "" {
+        n, err := strconv.Atoi(length)
+        if err != nil || n < 1 || n > 63 {
+            return nil, fmt.Errorf("invalid MOLE_SUBDOMAIN_MAX_LENGTH: must be between 1 and 63")
+        }
+        cfg.SubdomainMaxLength = n
+    }
+    
+    if timeout := os.Getenv("MOLE_UDP_IDLE_TIMEOUT"); timeout != "" {
+        d, err := time.ParseDuration(timeout)
+        if err != nil {
+            return nil, fmt.Errorf("invalid MOLE_UDP_IDLE_TIMEOUT: %v", err)
+        }
+        cfg.UDPIdleTimeout = d
+    }
+    if limit := os.Getenv("MOLE_UDP_MAX_SESSIONS"); limit != "" {
+        n, err := strconv.Atoi(limit)
+        if err != nil || n < 1 {
+            return nil, fmt.Errorf("invalid MOLE_UDP_MAX_SESSIONS: must be a positive number")
+        }
+        cfg.UDPMaxSessions = n
+    }
+    
+    cfg.ResumeGrace = 10 * time.Second
+    if grace := os.Getenv("MOLE_RESUME_GRACE"); grace != "" {
+        d, err := time.ParseDuration(grace)
+        if err != nil {
+            return nil, fmt.Errorf("invalid MOLE_RESUME_GRACE: %v", err)
+        }
+        cfg.ResumeGrace = d
+    }
+    
+    cfg.PingInterval = 20 * time.Second
+    if interval := os.Getenv("MOLE_PING_INTERVAL"); interval != "" {
+        d, err := time.ParseDuration(interval)
+        if err != nil {
+            return nil, fmt.Errorf("invalid MOLE_PING_INTERVAL: %v", err)
+        }
+        cfg.PingInterval = d
+    }
+    cfg.PingTimeout = 10 * time.Second
+    if timeout := os.Getenv("MOLE_PING_TIMEOUT"); timeout != "" {
+        d, err := time.ParseDuration(timeout)
+        if err != nil || d <= 0 {
+            return nil, fmt.Errorf("invalid MOLE_PING_TIMEOUT: %q", timeout)
+        }
+        cfg.PingTimeout = d
+    }
+    
+    cfg.AdminAddr = os.Getenv("MOLE_ADMIN_ADDR")
+    cfg.AdminToken = os.Getenv("MOLE_ADMIN_TOKEN")
+    if cfg.AdminAddr != "" && cfg.AdminToken == "" {
+        return nil, fmt.Errorf("MOLE_ADMIN_ADDR needs MOLE_ADMIN_TOKEN to be set")
+    }
+    
+    cfg.MetricsAddr = os.Getenv("MOLE_METRICS_ADDR")
+    
+    cfg.ShutdownTimeout = 30 * time.Second
+    if timeout := os.Getenv("MOLE_SHUTDOWN_TIMEOUT"); timeout != "" {
+        d, err := time.ParseDuration(timeout)
+        if err != nil {
+            return nil, fmt.Errorf("invalid MOLE_SHUTDOWN_TIMEOUT: %v", err)
+        }
+        cfg.ShutdownTimeout = d
+    }
+    
     // set defaults
     if cfg.Port == 0 {
         cfg.Port = 80
@@ -57,14 +297,67 @@ func Load() (*Config, error) {
     if cfg.Domain == "" {
         cfg.Domain = "localhost"
     }
+    // hosts are matched in lowercase ascii, punycode for international names
+    domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(strings.TrimSpace(cfg.Domain), "."))
+    if err != nil || domain == "" {
+        return nil, fmt.Errorf("invalid domain %q", cfg.Domain)
+    }
+    cfg.Domain = domain
+    if cfg.UDPIdleTimeout == 0 {
+        cfg.UDPIdleTimeout = 60 * time.Second
+    }
+    if cfg.UDPMaxSessions == 0 {
+        cfg.UDPMaxSessions = 256
+    }
+    
+    if cfg.ACMEDirectory == "" {
+        cfg.ACMEDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+    }
+    if cfg.ACMECache == "" {
+        cfg.ACMECache = "/var/lib/mole/acme"
+    }
+    // plain http sits on port 80 next to https, unless https already took it
+    if !httpPortSet && cfg.Port != 80 {
+        cfg.HTTPPort = 80
+    }
+    if !cfg.UseHTTPS {
+        cfg.HTTPPort = 0
+    }
+    if cfg.HTTPPort == cfg.Port {
+        return nil, fmt.Errorf("MOLE_HTTP_PORT must differ from the https port %d", cfg.Port)
+    }
     
     // automatically set certificate paths if HTTPS is enabled but paths not specified
-    if cfg.UseHTTPS && cfg.CertFile == "" {
+    if cfg.UseHTTPS && !cfg.ACME && cfg.CertFile == "" {
         cfg.CertFile = "/etc/letsencrypt/live/" + cfg.Domain + "/fullchain.pem"
     }
-    if cfg.UseHTTPS && cfg.KeyFile == "" {
+    if cfg.UseHTTPS && !cfg.ACME && cfg.KeyFile == "" {
         cfg.KeyFile = "/etc/letsencrypt/live/" + cfg.Domain + "/privkey.pem"
     }
+    if cfg.UseHTTPS && !cfg.ACME {
+        cfg.Certificates = append([]CertPair{{CertFile: cfg.CertFile, KeyFile: cfg.KeyFile}}, extraCerts...)
+    }
     
     return cfg, nil
+}
+
+// parsePortRange parses "10000-10100", or a single port
+func parsePortRange(value string) (int, int, error) {
+    lo, hi, found := strings.Cut(value, "-")
+    if !found {
+        hi = lo
+    }
+    
+    min, err := strconv.Atoi(strings.TrimSpace(lo))
+    if err != nil {
+        return 0, 0, err
+    }
+    max, err := strconv.Atoi(strings.TrimSpace(hi))
+    if err != nil {
+        return 0, 0, err
+    }
+    if min < 1 || max > 65535 || min > max {
+        return 0, 0, fmt.Errorf("range %d-%d is not valid", min, max)
+    }
+    return min, max, nil
 }
\ No newline at end of file
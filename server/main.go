@@ -1,12 +1,19 @@
 package main
 
 import (
-    "encoding/json"
+    "crypto/tls"
     "fmt"
     "log"
     "net/http"
+    "os"
+    "os/signal"
+    "syscall"
     "time"
     
+    "mole/internal/metrics"
+    "mole/server/admin"
+    "mole/server/auth"
+    "mole/server/certs"
     "mole/server/config"
     "mole/server/proxy"
     "mole/server/tunnel"
@@ -20,13 +27,33 @@ func main() {
     
     log.Printf("starting mole server on port %d for domain %s", cfg.Port, cfg.Domain)
     log.Printf("https enabled: %v", cfg.UseHTTPS)
-    if cfg.UseHTTPS {
-        log.Printf("cert file: %s, key file: %s", cfg.CertFile, cfg.KeyFile)
+    if cfg.TCPPortMin != 0 {
+        log.Printf("tcp tunnels enabled on ports %d-%d", cfg.TCPPortMin, cfg.TCPPortMax)
+    }
+    if cfg.UDPPortMin != 0 {
+        log.Printf("udp tunnels enabled on ports %d-%d", cfg.UDPPortMin, cfg.UDPPortMax)
+    }
+    for _, pair := range cfg.Certificates {
+        log.Printf("cert file: %s, key file: %s", pair.CertFile, pair.KeyFile)
     }
     log.Printf("verbose logging enabled - all requests will be logged")
     
-    manager := tunnel.NewManager()
-    handler := proxy.NewHandler(manager, cfg.Domain)
+    tokens, err := auth.NewStore(cfg.TokensFile, cfg.Tokens)
+    if err != nil {
+        log.Fatalf("failed to load tokens: %v", err)
+    }
+    if !tokens.Enabled() {
+        log.Printf("warning: no tokens configured, anyone can register tunnels")
+    }
+    
+    reserved, err := auth.NewReservations(cfg.ReservationsFile, cfg.Reservations)
+    if err != nil {
+        log.Fatalf("failed to load reservations: %v", err)
+    }
+    
+    manager := tunnel.NewManager(cfg, tokens, reserved)
+    handler := proxy.NewHandler(manager, cfg)
+    manager.SetHandler(handler)
     
     // logging middleware
     loggingHandler := func(next http.HandlerFunc) http.HandlerFunc {
@@ -47,36 +74,100 @@ func main() {
     // websocket endpoint for tunnel connections
     http.HandleFunc("/tunnel", loggingHandler(manager.HandleWebSocket))
     
-    // response handler for client responses
-    http.HandleFunc("/response", loggingHandler(func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != "POST" {
-            log.Printf("[ERROR] Invalid method %s for /response endpoint", r.Method)
-            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-            return
+    // catch-all handler for proxying requests
+    http.HandleFunc("/", loggingHandler(handler.ServeHTTP))
+    
+    // start servers
+    var servers []*http.Server
+    addr := fmt.Sprintf(":%d", cfg.Port)
+    if !cfg.UseHTTPS {
+        log.Printf("starting http server on %s", addr)
+        server := &http.Server{Addr: addr}
+        servers = append(servers, server)
+        go serve(server.ListenAndServe)
+    } else {
+        // plain http runs next to https, tunnels choose whether it redirects.
+        // clients only connect over https, their tokens must not cross the
+        // network in the clear.
+        var tlsConfig *tls.Config
+        var plain http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if r.URL.Path == "/tunnel" {
+                log.Printf("[ERROR] refused tunnel connection over plain http from %s", r.RemoteAddr)
+                http.Error(w, fmt.Sprintf("tunnels connect over https on port %d", cfg.Port), http.StatusForbidden)
+                return
+            }
+            http.DefaultServeMux.ServeHTTP(w, r)
+        })
+        if cfg.ACME {
+            certManager, err := certs.NewManager(cfg, func(subdomain string) bool {
+                return manager.GetTunnel(subdomain) != nil
+            })
+            if err != nil {
+                log.Fatalf("failed to set up acme: %v", err)
+            }
+            if err := certManager.Start(); err != nil {
+                log.Fatalf("failed to obtain certificates: %v", err)
+            }
+            tlsConfig = certManager.TLSConfig()
+            
+            // http-01 challenges arrive on the plain listener
+            plain = certManager.HTTPHandler(plain)
+            if cfg.HTTPPort == 0 {
+                log.Printf("warning: plain http is off, acme can only validate with tls-alpn-01")
+            }
+            log.Printf("using acme certificates from %s", cfg.ACMEDirectory)
+        } else {
+            reloader, err := certs.NewReloader(cfg.Certificates)
+            if err != nil {
+                log.Fatalf("failed to load certificates: %v", err)
+            }
+            reloader.Watch(cfg.CertReloadInterval)
+            tlsConfig = reloader.TLSConfig()
         }
         
-        var resp proxy.Response
-        if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
-            log.Printf("[ERROR] Failed to decode response JSON: %v", err)
-            http.Error(w, "invalid json", http.StatusBadRequest)
-            return
+        if cfg.HTTPPort != 0 {
+            httpAddr := fmt.Sprintf(":%d", cfg.HTTPPort)
+            log.Printf("starting http server on %s, default policy %s", httpAddr, cfg.HTTPPolicy)
+            plainServer := &http.Server{Addr: httpAddr, Handler: plain}
+            servers = append(servers, plainServer)
+            go serve(plainServer.ListenAndServe)
         }
         
-        log.Printf("[RESPONSE] Handling response for request ID: %s", resp.ID)
-        handler.HandleResponse(&resp)
-        w.WriteHeader(http.StatusOK)
-    }))
+        log.Printf("starting https server on %s", addr)
+        server := &http.Server{Addr: addr, TLSConfig: tlsConfig}
+        servers = append(servers, server)
+        go serve(func() error { return server.ListenAndServeTLS("", "") })
+    }
     
-    // catch-all handler for proxying requests
-    http.HandleFunc("/", loggingHandler(handler.ServeHTTP))
+    // the admin api has a listener of its own, keep it off the internet
+    if cfg.AdminAddr != "" {
+        log.Printf("starting admin api on %s", cfg.AdminAddr)
+        adminServer := &http.Server{
+            Addr:    cfg.AdminAddr,
+            Handler: admin.NewHandler(manager, tokens, reserved, cfg.AdminToken),
+        }
+        servers = append(servers, adminServer)
+        go serve(adminServer.ListenAndServe)
+    }
     
-    // start server
-    addr := fmt.Sprintf(":%d", cfg.Port)
-    if cfg.UseHTTPS && cfg.CertFile != "" && cfg.KeyFile != "" {
-        log.Printf("starting https server on %s", addr)
-        log.Fatal(http.ListenAndServeTLS(addr, cfg.CertFile, cfg.KeyFile, nil))
-    } else {
-        log.Printf("starting http server on %s", addr)
-        log.Fatal(http.ListenAndServe(addr, nil))
+    // metrics are kept off the public listener so no tunnel loses /metrics
+    if cfg.MetricsAddr != "" {
+        log.Printf("serving metrics on %s/metrics", cfg.MetricsAddr)
+        mux := http.NewServeMux()
+        mux.Handle("/metrics", metrics.Default)
+        metricsServer := &http.Server{Addr: cfg.MetricsAddr, Handler: mux}
+        servers = append(servers, metricsServer)
+        go serve(metricsServer.ListenAndServe)
     }
+    
+    // a deploy sends SIGTERM, finish what is in flight before exiting
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+    sig := <-stop
+    go func() {
+        <-stop
+        log.Fatalf("got a second signal, exiting without waiting")
+    }()
+    log.Printf("got %s, shutting down", sig)
+    shutdown(servers, manager, cfg.ShutdownTimeout)
 }
\ No newline at end of file
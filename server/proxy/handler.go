@@ -4,43 +4,63 @@ import (
     "crypto/rand"
     "encoding/hex"
     "io"
+    "log"
     "net/http"
     "strings"
     "time"
     
+    "mole/internal/wire"
+    "mole/server/config"
     "mole/server/tunnel"
 )
 
-type Handler struct {
-    manager    *tunnel.Manager
-    baseDomain string
-    requests   map[string]chan *Response
-}
+// how long the client has to answer once the whole request was sent, like
+// the transport's ResponseHeaderTimeout
+const responseHeaderTimeout = 30 * time.Second
 
-type Request struct {
-    ID      string            `json:"id"`
-    Method  string            `json:"method"`
-    URL     string            `json:"url"`
-    Headers map[string]string `json:"headers"`
-    Body    []byte            `json:"body"`
-}
-
-type Response struct {
-    ID         string            `json:"id"`
-    StatusCode int               `json:"status_code"`
-    Headers    map[string]string `json:"headers"`
-    Body       []byte            `json:"body"`
+type Handler struct {
+    manager        *tunnel.Manager
+    baseDomain     string
+    pending        *pendingRequests
+    udpIdleTimeout time.Duration
+    udpMaxSessions int
+    
+    // listeners, for sending requests to the scheme a tunnel wants
+    useHTTPS   bool
+    httpsPort  int
+    httpPort   int
+    hstsMaxAge time.Duration
 }
 
-func NewHandler(manager *tunnel.Manager, baseDomain string) *Handler {
+func NewHandler(manager *tunnel.Manager, cfg *config.Config) *Handler {
     return &Handler{
-        manager:    manager,
-        baseDomain: baseDomain,
-        requests:   make(map[string]chan *Response),
+        manager:        manager,
+        baseDomain:     cfg.Domain,
+        pending:        newPendingRequests(),
+        udpIdleTimeout: cfg.UDPIdleTimeout,
+        udpMaxSessions: cfg.UDPMaxSessions,
+        useHTTPS:       cfg.UseHTTPS,
+        httpsPort:      cfg.Port,
+        httpPort:       cfg.HTTPPort,
+        hstsMaxAge:     cfg.HSTSMaxAge,
     }
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    start := time.Now()
+    
+    // only subdomains with a registered tunnel get their own series, they
+    // are dropped when the tunnel closes
+    sw := &statusWriter{ResponseWriter: w}
+    w = sw
+    label := ""
+    defer func() {
+        if label != "" && h.manager.GetTunnel(label) == nil {
+            label = ""
+        }
+        requestsTotal.Inc(label, statusClass(sw.status))
+    }()
+    
     // extract subdomain from host
     host := r.Host
     subdomain := h.extractSubdomain(host)
@@ -51,78 +71,318 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
     }
     
     // find the tunnel connection
-    conn := h.manager.GetTunnel(subdomain)
-    if conn == nil {
+    t := h.manager.GetTunnel(subdomain)
+    if t == nil {
         http.Error(w, "tunnel not found", http.StatusNotFound)
         return
     }
+    label = subdomain
+    
+    if t.Draining() {
+        http.Error(w, "tunnel is shutting down", http.StatusServiceUnavailable)
+        return
+    }
+    
+    if h.redirectScheme(w, r, t) {
+        return
+    }
+    
+    if t.Legacy {
+        h.serveLegacy(w, r, t, start)
+        return
+    }
     
     // generate request id
     requestID := h.generateID()
     
-    // read request body
-    body, err := io.ReadAll(r.Body)
+    // register before sending so a fast response cannot be missed
+    pending := h.open(t, requestID)
+    defer h.pending.remove(requestID)
+    
+    // send request headers to client
+    err := t.WriteFrame(&wire.Frame{
+        Type:     wire.FrameRequest,
+        ID:       requestID,
+        Method:   r.Method,
+        URL:      r.URL.String(),
+        Headers:  r.Header.Clone(),
+        Trailers: declaredTrailers(r.Trailer),
+    })
     if err != nil {
-        http.Error(w, "failed to read request body", http.StatusInternalServerError)
+        http.Error(w, "failed to forward request", http.StatusInternalServerError)
         return
     }
     
-    // prepare headers
-    headers := make(map[string]string)
-    for key, values := range r.Header {
-        if len(values) > 0 {
-            headers[key] = values[0]
+    // stream the request body while the response comes back. upgrade
+    // requests have no body, their stream starts after the handshake.
+    upgrade := isUpgradeRequest(r)
+    rc := http.NewResponseController(w)
+    timer := time.NewTimer(responseHeaderTimeout)
+    defer timer.Stop()
+    
+    var uploaded chan struct{}
+    if !upgrade {
+        rc.EnableFullDuplex()
+        uploaded = make(chan struct{})
+        go func() {
+            defer close(uploaded)
+            h.sendBody(t, pending, r)
+        }()
+        
+        // the body must not be read once the handler returns, a stalled
+        // upload is stopped by failing its next read
+        defer func(uploaded chan struct{}) {
+            h.pending.remove(requestID)
+            select {
+            case <-uploaded:
+            default:
+                rc.SetReadDeadline(time.Now())
+                <-uploaded
+            }
+        }(uploaded)
+        
+        // the timeout starts once the end frame is sent, a slow upload is
+        // not the client's delay
+        timer.Stop()
+    }
+    
+    var resp *wire.Frame
+    for resp == nil {
+        select {
+        case <-uploaded:
+            uploaded = nil
+            timer.Reset(responseHeaderTimeout)
+        
+        case f := <-pending.frames:
+            if f.Type != wire.FrameResponse {
+                // the client answers with the response first, anything
+                // else means the stream is broken
+                log.Printf("[ERROR] Unexpected %s frame before response for request %s", f.Type, requestID)
+                h.cancel(t, requestID)
+                http.Error(w, "invalid response from tunnel client", http.StatusBadGateway)
+                return
+            }
+            resp = f
+            responseSeconds.Observe(time.Since(start).Seconds())
+        
+        case <-pending.done:
+            log.Printf("[ERROR] Request %s failed: %v", requestID, pending.err)
+            http.Error(w, "tunnel closed", http.StatusBadGateway)
+            return
+        
+        case <-r.Context().Done():
+            log.Printf("[ERROR] Request %s cancelled by caller", requestID)
+            h.cancel(t, requestID)
+            return
+        
+        case <-timer.C:
+            timeoutsTotal.Inc()
+            h.cancel(t, requestID)
+            http.Error(w, "request timeout", http.StatusGatewayTimeout)
+            return
         }
     }
     
-    // create request object
-    req := &Request{
-        ID:      requestID,
-        Method:  r.Method,
-        URL:     r.URL.String(),
-        Headers: headers,
-        Body:    body,
+    if upgrade && resp.StatusCode == http.StatusSwitchingProtocols {
+        // the handshake is written on the hijacked connection
+        sw.status = resp.StatusCode
+        h.serveUpgrade(w, t, pending, resp)
+        return
+    }
+    if upgrade {
+        // the local side refused to switch. no end frame follows an upgrade
+        // request, so the client is told the stream is over once its answer
+        // is relayed
+        defer h.cancel(t, requestID)
     }
     
-    // create response channel
-    respChan := make(chan *Response, 1)
-    h.requests[requestID] = respChan
+    // write response headers, keeping every value of repeated fields
+    for key, values := range resp.Headers {
+        for _, value := range values {
+            w.Header().Add(key, value)
+        }
+    }
+    for key := range resp.Trailers {
+        w.Header().Add("Trailer", key)
+    }
+    h.setHSTS(w, r, t)
+    w.WriteHeader(resp.StatusCode)
     
-    // send request to client
-    if err := conn.WriteJSON(req); err != nil {
-        delete(h.requests, requestID)
-        http.Error(w, "failed to forward request", http.StatusInternalServerError)
+    // stream the response body, flushing every chunk so the caller gets
+    // the first bytes without waiting for the last
+    for {
+        select {
+        case f := <-pending.frames:
+            pending.take(f)
+            switch f.Type {
+            case wire.FrameData:
+                if _, err := w.Write(f.Body); err != nil {
+                    h.cancel(t, requestID)
+                    return
+                }
+                rc.Flush()
+            
+            case wire.FrameEnd:
+                if f.Error != "" {
+                    // the status line is already sent, abort so the caller
+                    // sees a broken response rather than a truncated one
+                    log.Printf("[ERROR] Response body for request %s failed: %s", requestID, f.Error)
+                    panic(http.ErrAbortHandler)
+                }
+                writeTrailers(w, resp.Trailers, f.Trailers)
+                return
+            }
+        
+        case <-pending.done:
+            log.Printf("[ERROR] Request %s failed mid-response: %v", requestID, pending.err)
+            panic(http.ErrAbortHandler)
+        
+        case <-r.Context().Done():
+            log.Printf("[ERROR] Request %s cancelled by caller", requestID)
+            h.cancel(t, requestID)
+            return
+        }
+    }
+}
+
+// sendBody streams the public request body to the client as data frames,
+// with the request trailers on the end frame
+func (h *Handler) sendBody(t *tunnel.Tunnel, pending *pendingRequest, r *http.Request) {
+    if _, err := copyToTunnel(t, pending, r.Body); err != nil {
+        t.WriteFrame(&wire.Frame{Type: wire.FrameEnd, ID: pending.id, Error: err.Error()})
         return
     }
     
-    // wait for response with timeout
-    select {
-    case resp := <-respChan:
-        // write response headers
-        for key, value := range resp.Headers {
-            w.Header().Set(key, value)
+    // trailers are only populated once the body is fully read
+    t.WriteFrame(&wire.Frame{Type: wire.FrameEnd, ID: pending.id, Trailers: r.Trailer.Clone()})
+}
+
+// copyToTunnel sends everything read from src as data frames, as fast as
+// the client grants credit for them. it returns the number of bytes sent
+// and the error that stopped it, nil at EOF.
+func copyToTunnel(t *tunnel.Tunnel, pending *pendingRequest, src io.Reader) (int64, error) {
+    var size int64
+    buf := make([]byte, wire.ChunkSize)
+    for {
+        n, err := src.Read(buf)
+        if n > 0 {
+            size += int64(n)
+            if !pending.window.Take(pending.done) {
+                return size, io.ErrClosedPipe
+            }
+            if err := t.WriteFrame(&wire.Frame{Type: wire.FrameData, ID: pending.id, Body: buf[:n]}); err != nil {
+                return size, err
+            }
         }
-        w.WriteHeader(resp.StatusCode)
-        w.Write(resp.Body)
         
-    case <-time.After(30 * time.Second):
-        http.Error(w, "request timeout", http.StatusGatewayTimeout)
+        if err == io.EOF {
+            return size, nil
+        }
+        if err != nil {
+            return size, err
+        }
     }
-    
-    // cleanup
-    delete(h.requests, requestID)
 }
 
-func (h *Handler) HandleResponse(resp *Response) {
-    if respChan, exists := h.requests[resp.ID]; exists {
-        select {
-        case respChan <- resp:
-        default:
+// declaredTrailers returns the trailer names announced before the body
+func declaredTrailers(trailer http.Header) http.Header {
+    if len(trailer) == 0 {
+        return nil
+    }
+    declared := make(http.Header, len(trailer))
+    for key := range trailer {
+        declared[key] = nil
+    }
+    return declared
+}
+
+// writeTrailers sets trailer values after the body has been written. names
+// not announced in the Trailer header need the TrailerPrefix to be sent.
+func writeTrailers(w http.ResponseWriter, declared, trailers http.Header) {
+    for key, values := range trailers {
+        name := key
+        if _, ok := declared[key]; !ok {
+            name = http.TrailerPrefix + key
+        }
+        for _, value := range values {
+            w.Header().Add(name, value)
+        }
+    }
+}
+
+// cancel tells the client to abort a request the public caller gave up on
+func (h *Handler) cancel(t *tunnel.Tunnel, requestID string) {
+    t.WriteFrame(&wire.Frame{Type: wire.FrameCancel, ID: requestID})
+}
+
+// open registers a stream on the tunnel, the client gets credit back as the
+// public side consumes its data frames
+func (h *Handler) open(t *tunnel.Tunnel, id string) *pendingRequest {
+    pending := h.pending.add(t.ID, id)
+    pending.grant = func(credit int) {
+        t.WriteFrame(&wire.Frame{Type: wire.FrameWindow, ID: id, Credit: credit})
+    }
+    return pending
+}
+
+// HandleFrame routes a frame read from the tunnel websocket to its request.
+// it runs on the tunnel's read loop, so it never waits on a single stream.
+func (h *Handler) HandleFrame(t *tunnel.Tunnel, f *wire.Frame) {
+    switch f.Type {
+    case wire.FrameResponse, wire.FrameData, wire.FrameEnd:
+        err := h.pending.deliver(t.ID, f)
+        switch {
+        case err == errWindowExceeded:
+            log.Printf("[ERROR] Tunnel %s overran the window of stream %s, resetting it", t.Name(), f.ID)
+            go h.cancel(t, f.ID)
+        case err != nil && f.Type == wire.FrameResponse:
+            log.Printf("[ERROR] No pending request for response ID: %s", f.ID)
         }
+    case wire.FrameWindow:
+        h.pending.grant(t.ID, f)
+    default:
+        log.Printf("[ERROR] Unexpected %s frame from tunnel %s", f.Type, t.Name())
     }
 }
 
+// TunnelOpened starts serving the public side of raw tunnels
+func (h *Handler) TunnelOpened(t *tunnel.Tunnel) {
+    switch t.Kind {
+    case tunnel.KindTCP:
+        go h.serveTCP(t)
+    case tunnel.KindUDP:
+        go h.serveUDP(t)
+    }
+}
+
+// TunnelDetached runs when a tunnel's client connection drops. its requests
+// wait for the client to resume, they fail once the tunnel is closed.
+func (h *Handler) TunnelDetached(t *tunnel.Tunnel) {
+    if n := h.pending.count(t.ID); n > 0 {
+        log.Printf("[ERROR] Tunnel %s lost its connection with %d requests in flight, holding them for a resume", t.Name(), n)
+    }
+}
+
+// TunnelClosed fails the tunnel's in-flight requests instead of letting them
+// time out, and drops its request series unless another tunnel took its place
+func (h *Handler) TunnelClosed(t *tunnel.Tunnel) {
+    if n := h.pending.cancelTunnel(t.ID); n > 0 {
+        log.Printf("[ERROR] Tunnel %s closed with %d requests in flight", t.Name(), n)
+    }
+    if t.Kind == tunnel.KindHTTP && h.manager.GetTunnel(t.Subdomain) == nil {
+        requestsTotal.Delete(t.Subdomain)
+    }
+}
+
+// InFlight reports how many requests are still being served by the tunnel
+func (h *Handler) InFlight(t *tunnel.Tunnel) int {
+    return h.pending.count(t.ID)
+}
+
 func (h *Handler) extractSubdomain(host string) string {
+    // host names are case insensitive, subdomains are registered lowercase
+    host = strings.ToLower(host)
+    
     // remove port if present
     if colonIndex := strings.Index(host, ":"); colonIndex != -1 {
         host = host[:colonIndex]
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+    "io"
+    "log"
+    "net/http"
+    "time"
+    
+    "mole/internal/wire"
+    "mole/server/tunnel"
+)
+
+// largest request body sent to a legacy client, it gets the whole body in
+// one message
+const maxLegacyBody = 16 << 20
+
+// serveLegacy relays a request to a client from before frames were
+// introduced. the request goes out in one message with its body, and the
+// answer comes back the same way, turned into frames by the manager.
+func (h *Handler) serveLegacy(w http.ResponseWriter, r *http.Request, t *tunnel.Tunnel, start time.Time) {
+    if isUpgradeRequest(r) {
+        http.Error(w, "tunnel client does not support protocol upgrades", http.StatusNotImplemented)
+        return
+    }
+    
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLegacyBody))
+    if err != nil {
+        http.Error(w, "request body too large for this tunnel client", http.StatusRequestEntityTooLarge)
+        return
+    }
+    
+    // legacy clients know no window frames, their answer is delivered whole
+    requestID := h.generateID()
+    pending := h.pending.add(t.ID, requestID)
+    defer h.pending.remove(requestID)
+    
+    if err := t.WriteJSON(wire.NewLegacyRequest(requestID, r.Method, r.URL.String(), r.Header, body)); err != nil {
+        http.Error(w, "failed to forward request", http.StatusInternalServerError)
+        return
+    }
+    
+    timer := time.NewTimer(responseHeaderTimeout)
+    defer timer.Stop()
+    
+    var resp *wire.Frame
+    select {
+    case resp = <-pending.frames:
+        responseSeconds.Observe(time.Since(start).Seconds())
+    case <-pending.done:
+        log.Printf("[ERROR] Request %s failed: %v", requestID, pending.err)
+        http.Error(w, "tunnel closed", http.StatusBadGateway)
+        return
+    case <-r.Context().Done():
+        log.Printf("[ERROR] Request %s cancelled by caller", requestID)
+        return
+    case <-timer.C:
+        timeoutsTotal.Inc()
+        http.Error(w, "request timeout", http.StatusGatewayTimeout)
+        return
+    }
+    
+    for key, values := range resp.Headers {
+        for _, value := range values {
+            w.Header().Add(key, value)
+        }
+    }
+    h.setHSTS(w, r, t)
+    w.WriteHeader(resp.StatusCode)
+    
+    // the body and end frames were delivered together with the response
+    for {
+        select {
+        case f := <-pending.frames:
+            if f.Type == wire.FrameEnd {
+                return
+            }
+            w.Write(f.Body)
+        case <-pending.done:
+            return
+        }
+    }
+}
\ No newline at end of file
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+    "net/http"
+    "strconv"
+    
+    "mole/internal/metrics"
+)
+
+var (
+    requestsTotal   = metrics.Default.Counter("mole_http_requests_total", "Public http requests, by tunnel subdomain and status class. Requests for no tunnel have an empty subdomain, a subdomain's series go away with its tunnel.", "subdomain", "class")
+    responseSeconds = metrics.Default.Histogram("mole_http_response_seconds", "Time from a public request arriving to the tunnel's response headers.", metrics.DefaultBuckets)
+    timeoutsTotal   = metrics.Default.Counter("mole_http_timeouts_total", "Public requests the tunnel did not answer in time.")
+)
+
+// statusWriter remembers the status sent for the request metrics. Unwrap
+// keeps flushing and hijacking through http.ResponseController working.
+type statusWriter struct {
+    http.ResponseWriter
+    status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+    if s.status == 0 {
+        s.status = status
+    }
+    s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(data []byte) (int, error) {
+    if s.status == 0 {
+        s.status = http.StatusOK
+    }
+    return s.ResponseWriter.Write(data)
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+    return s.ResponseWriter
+}
+
+// statusClass groups a status as "2xx", "4xx" and so on. a request given up
+// before any response has none.
+func statusClass(status int) string {
+    if status == 0 {
+        return "none"
+    }
+    return strconv.Itoa(status/100) + "xx"
+}
\ No newline at end of file
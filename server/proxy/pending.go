@@ -0,0 +1,205 @@
+package proxy
+
+import (
+    "errors"
+    "io"
+    "sync"
+    
+    "mole/internal/wire"
+)
+
+// frames buffered per request, the client's window of data frames plus its
+// response and end frames
+const streamBufferSize = wire.StreamWindow + 2
+
+var (
+    errTunnelClosed   = errors.New("tunnel closed")
+    errNotPending     = errors.New("no pending request")
+    errWindowExceeded = errors.New("client sent past its window")
+)
+
+// an in-flight request waiting for response frames from a tunnel client
+type pendingRequest struct {
+    id       string
+    tunnelID string
+    frames   chan *wire.Frame
+    done     chan struct{}
+    stopOnce sync.Once
+    err      error
+    
+    // credit for the data frames sent to the client, and the data frames
+    // consumed here that grant sends back to it
+    window *wire.Window
+    credit wire.Credit
+    grant  func(credit int)
+    
+    // read side for streams relayed as raw bytes
+    buf     []byte
+    readErr error
+}
+
+// stop closes done once; err is nil when the request finished normally
+func (req *pendingRequest) stop(err error) {
+    req.stopOnce.Do(func() {
+        req.err = err
+        close(req.done)
+    })
+}
+
+// Read returns the bytes of the data frames sent by the client until its end frame
+func (req *pendingRequest) Read(p []byte) (int, error) {
+    for len(req.buf) == 0 {
+        if req.readErr != nil {
+            return 0, req.readErr
+        }
+        
+        select {
+        case f := <-req.frames:
+            req.take(f)
+            switch f.Type {
+            case wire.FrameData:
+                req.buf = f.Body
+            case wire.FrameEnd:
+                req.readErr = io.EOF
+                if f.Error != "" {
+                    req.readErr = errors.New(f.Error)
+                }
+            }
+        case <-req.done:
+            req.readErr = req.err
+            if req.readErr == nil {
+                req.readErr = io.ErrClosedPipe
+            }
+        }
+    }
+    
+    n := copy(p, req.buf)
+    req.buf = req.buf[n:]
+    return n, nil
+}
+
+// take is called for every frame taken off the buffer, the client gets its
+// data frames back as credit in batches
+func (req *pendingRequest) take(f *wire.Frame) {
+    if f.Type != wire.FrameData || req.grant == nil {
+        return
+    }
+    if n := req.credit.Consume(); n > 0 {
+        req.grant(n)
+    }
+}
+
+// registry correlating request ids with waiting public requests, scoped
+// per tunnel so a dropped tunnel can fail its requests immediately
+type pendingRequests struct {
+    mutex    sync.Mutex
+    requests map[string]*pendingRequest
+    byTunnel map[string]map[string]*pendingRequest
+}
+
+func newPendingRequests() *pendingRequests {
+    return &pendingRequests{
+        requests: make(map[string]*pendingRequest),
+        byTunnel: make(map[string]map[string]*pendingRequest),
+    }
+}
+
+func (p *pendingRequests) add(tunnelID, requestID string) *pendingRequest {
+    req := &pendingRequest{
+        id:       requestID,
+        tunnelID: tunnelID,
+        frames:   make(chan *wire.Frame, streamBufferSize),
+        done:     make(chan struct{}),
+        window:   wire.NewWindow(),
+    }
+    
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    
+    p.requests[requestID] = req
+    if p.byTunnel[tunnelID] == nil {
+        p.byTunnel[tunnelID] = make(map[string]*pendingRequest)
+    }
+    p.byTunnel[tunnelID][requestID] = req
+    return req
+}
+
+// deliver hands a frame to its waiting request without blocking the tunnel
+// read loop. the buffer holds the client's whole window, a client that
+// sends past it fails the request with errWindowExceeded.
+func (p *pendingRequests) deliver(tunnelID string, f *wire.Frame) error {
+    req := p.get(tunnelID, f.ID)
+    if req == nil {
+        return errNotPending
+    }
+    
+    select {
+    case req.frames <- f:
+        return nil
+    case <-req.done:
+        return errNotPending
+    default:
+        req.stop(errWindowExceeded)
+        return errWindowExceeded
+    }
+}
+
+// grant adds the credit of a window frame to the request it names
+func (p *pendingRequests) grant(tunnelID string, f *wire.Frame) {
+    if req := p.get(tunnelID, f.ID); req != nil {
+        req.window.Grant(f.Credit)
+    }
+}
+
+// get returns a request, only the tunnel it was sent to may answer it
+func (p *pendingRequests) get(tunnelID, requestID string) *pendingRequest {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    
+    req, exists := p.requests[requestID]
+    if !exists || req.tunnelID != tunnelID {
+        return nil
+    }
+    return req
+}
+
+func (p *pendingRequests) remove(requestID string) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    
+    req, exists := p.requests[requestID]
+    if !exists {
+        return
+    }
+    delete(p.requests, requestID)
+    req.stop(nil)
+    
+    if reqs := p.byTunnel[req.tunnelID]; reqs != nil {
+        delete(reqs, requestID)
+        if len(reqs) == 0 {
+            delete(p.byTunnel, req.tunnelID)
+        }
+    }
+}
+
+// cancelTunnel fails every request waiting on the given tunnel and returns
+// how many were cancelled
+func (p *pendingRequests) cancelTunnel(tunnelID string) int {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    
+    reqs := p.byTunnel[tunnelID]
+    for id, req := range reqs {
+        req.stop(errTunnelClosed)
+        delete(p.requests, id)
+    }
+    delete(p.byTunnel, tunnelID)
+    return len(reqs)
+}
+
+// count returns how many requests are waiting on the given tunnel
+func (p *pendingRequests) count(tunnelID string) int {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    return len(p.byTunnel[tunnelID])
+}
\ No newline at end of file
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "sync"
+    "testing"
+    
+    "mole/internal/wire"
+)
+
+func TestPendingReadsDataUntilEnd(t *testing.T) {
+    p := newPendingRequests()
+    req := p.add("t1", "r1")
+    
+    for _, body := range []string{"hello ", "world"} {
+        if err := p.deliver("t1", &wire.Frame{Type: wire.FrameData, ID: "r1", Body: []byte(body)}); err != nil {
+            t.Fatalf("deliver data: %v", err)
+        }
+    }
+    if err := p.deliver("t1", &wire.Frame{Type: wire.FrameEnd, ID: "r1"}); err != nil {
+        t.Fatalf("deliver end: %v", err)
+    }
+    
+    body, err := io.ReadAll(req)
+    if err != nil {
+        t.Fatalf("read: %v", err)
+    }
+    if string(body) != "hello world" {
+        t.Fatalf("body = %q, want %q", body, "hello world")
+    }
+}
+
+func TestPendingEndErrorFailsRead(t *testing.T) {
+    p := newPendingRequests()
+    req := p.add("t1", "r1")
+    p.deliver("t1", &wire.Frame{Type: wire.FrameEnd, ID: "r1", Error: "local service went away"})
+    
+    if _, err := io.ReadAll(req); err == nil || err.Error() != "local service went away" {
+        t.Fatalf("read error = %v, want the end frame's error", err)
+    }
+}
+
+func TestPendingDeliverChecksTunnel(t *testing.T) {
+    p := newPendingRequests()
+    p.add("t1", "r1")
+    
+    // another tunnel guessing the id must not answer the request
+    if err := p.deliver("t2", &wire.Frame{Type: wire.FrameResponse, ID: "r1"}); err != errNotPending {
+        t.Fatalf("deliver from other tunnel = %v, want errNotPending", err)
+    }
+    if err := p.deliver("t1", &wire.Frame{Type: wire.FrameResponse, ID: "unknown"}); err != errNotPending {
+        t.Fatalf("deliver unknown id = %v, want errNotPending", err)
+    }
+}
+
+func TestPendingDeliverAfterRemove(t *testing.T) {
+    p := newPendingRequests()
+    p.add("t1", "r1")
+    p.remove("r1")
+    p.remove("r1")
+    
+    if err := p.deliver("t1", &wire.Frame{Type: wire.FrameData, ID: "r1"}); err != errNotPending {
+        t.Fatalf("deliver after remove = %v, want errNotPending", err)
+    }
+    if n := p.count("t1"); n != 0 {
+        t.Fatalf("count = %d, want 0", n)
+    }
+}
+
+func TestPendingWindowExceeded(t *testing.T) {
+    p := newPendingRequests()
+    req := p.add("t1", "r1")
+    
+    // the buffer takes the whole window plus the response and end frames
+    for i := 0; i < streamBufferSize; i++ {
+        if err := p.deliver("t1", &wire.Frame{Type: wire.FrameData, ID: "r1"}); err != nil {
+            t.Fatalf("deliver %d: %v", i, err)
+        }
+    }
+    if err := p.deliver("t1", &wire.Frame{Type: wire.FrameData, ID: "r1"}); err != errWindowExceeded {
+        t.Fatalf("deliver past the window = %v, want errWindowExceeded", err)
+    }
+    
+    <-req.done
+    if req.err != errWindowExceeded {
+        t.Fatalf("request error = %v, want errWindowExceeded", req.err)
+    }
+}
+
+func TestPendingGrantsCreditInBatches(t *testing.T) {
+    p := newPendingRequests()
+    req := p.add("t1", "r1")
+    
+    var granted []int
+    req.grant = func(credit int) {
+        granted = append(granted, credit)
+    }
+    
+    for i := 0; i < wire.StreamWindow; i++ {
+        p.deliver("t1", &wire.Frame{Type: wire.FrameData, ID: "r1", Body: []byte("x")})
+    }
+    p.deliver("t1", &wire.Frame{Type: wire.FrameEnd, ID: "r1"})
+    
+    body, err := io.ReadAll(req)
+    if err != nil || len(body) != wire.StreamWindow {
+        t.Fatalf("read %d bytes, err %v", len(body), err)
+    }
+    
+    total := 0
+    for _, credit := range granted {
+        total += credit
+    }
+    if len(granted) < 2 || total != wire.StreamWindow {
+        t.Fatalf("granted %v, want batches adding up to %d", granted, wire.StreamWindow)
+    }
+}
+
+func TestPendingWindowFrameAddsCredit(t *testing.T) {
+    p := newPendingRequests()
+    req := p.add("t1", "r1")
+    
+    for i := 0; i < wire.StreamWindow; i++ {
+        if !req.window.TryTake() {
+            t.Fatalf("no credit for frame %d of the initial window", i)
+        }
+    }
+    if req.window.TryTake() {
+        t.Fatal("credit left past the initial window")
+    }
+    
+    // only the tunnel the request was sent to may grant credit
+    p.grant("t2", &wire.Frame{Type: wire.FrameWindow, ID: "r1", Credit: 1})
+    if req.window.TryTake() {
+        t.Fatal("credit granted by another tunnel")
+    }
+    p.grant("t1", &wire.Frame{Type: wire.FrameWindow, ID: "r1", Credit: 1})
+    if !req.window.TryTake() {
+        t.Fatal("no credit after a window frame")
+    }
+}
+
+func TestPendingCancelTunnel(t *testing.T) {
+    p := newPendingRequests()
+    a := p.add("t1", "r1")
+    b := p.add("t1", "r2")
+    other := p.add("t2", "r3")
+    
+    if n := p.cancelTunnel("t1"); n != 2 {
+        t.Fatalf("cancelled %d requests, want 2", n)
+    }
+    for _, req := range []*pendingRequest{a, b} {
+        if _, err := req.Read(make([]byte, 1)); err != errTunnelClosed {
+            t.Fatalf("read on cancelled request = %v, want errTunnelClosed", err)
+        }
+    }
+    
+    select {
+    case <-other.done:
+        t.Fatal("request on another tunnel was cancelled")
+    default:
+    }
+    if n := p.count("t2"); n != 1 {
+        t.Fatalf("count t2 = %d, want 1", n)
+    }
+}
+
+// run with -race: requests come and go on several tunnels while the read
+// loops deliver frames and tunnels are cancelled
+func TestPendingConcurrent(t *testing.T) {
+    p := newPendingRequests()
+    tunnels := []string{"t1", "t2", "t3", "t4"}
+    
+    var wg sync.WaitGroup
+    for i := 0; i < 64; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            tunnelID := tunnels[i%len(tunnels)]
+            
+            for j := 0; j < 50; j++ {
+                id := fmt.Sprintf("r-%d-%d", i, j)
+                req := p.add(tunnelID, id)
+                
+                // the tunnel read loop delivers while the handler reads
+                read := make(chan error, 1)
+                go func() {
+                    _, err := io.Copy(io.Discard, req)
+                    read <- err
+                }()
+                for k := 0; k < 3; k++ {
+                    p.deliver(tunnelID, &wire.Frame{Type: wire.FrameData, ID: id, Body: []byte("data")})
+                    p.grant(tunnelID, &wire.Frame{Type: wire.FrameWindow, ID: id, Credit: 1})
+                }
+                p.deliver(tunnelID, &wire.Frame{Type: wire.FrameEnd, ID: id})
+                
+                err := <-read
+                if err != nil && !errors.Is(err, errTunnelClosed) {
+                    t.Errorf("read %s: %v", id, err)
+                }
+                p.remove(id)
+            }
+        }(i)
+    }
+    
+    // tunnels drop while their requests are in flight
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            for j := 0; j < 100; j++ {
+                p.cancelTunnel(tunnels[(i+j)%len(tunnels)])
+                p.count(tunnels[i])
+            }
+        }(i)
+    }
+    wg.Wait()
+    
+    for _, tunnelID := range tunnels {
+        if n := p.count(tunnelID); n != 0 {
+            t.Errorf("count %s = %d after every request was removed", tunnelID, n)
+        }
+    }
+}
\ No newline at end of file
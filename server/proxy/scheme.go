@@ -0,0 +1,57 @@
+package proxy
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "strconv"
+    
+    "mole/server/tunnel"
+)
+
+// redirectScheme sends the request to the listener the tunnel's http policy
+// wants it on, reporting whether it did. with only one listener there is
+// nothing to choose.
+func (h *Handler) redirectScheme(w http.ResponseWriter, r *http.Request, t *tunnel.Tunnel) bool {
+    if !h.useHTTPS || h.httpPort == 0 {
+        return false
+    }
+    
+    // both are temporary, the policy belongs to whichever tunnel holds the
+    // subdomain and browsers must not remember it past that. HSTS is how a
+    // tunnel asks for https to stick.
+    secure := r.TLS != nil
+    switch {
+    case !secure && t.HTTPPolicy == tunnel.HTTPRedirect:
+        http.Redirect(w, r, schemeURL(r, "https", h.httpsPort, 443), http.StatusTemporaryRedirect)
+    case secure && t.HTTPPolicy == tunnel.HTTPOnly:
+        http.Redirect(w, r, schemeURL(r, "http", h.httpPort, 80), http.StatusTemporaryRedirect)
+    default:
+        return false
+    }
+    return true
+}
+
+// setHSTS adds a Strict-Transport-Security header to https responses of
+// tunnels that redirect plain http, unless the service sent its own
+func (h *Handler) setHSTS(w http.ResponseWriter, r *http.Request, t *tunnel.Tunnel) {
+    if h.hstsMaxAge <= 0 || r.TLS == nil || t.HTTPPolicy != tunnel.HTTPRedirect {
+        return
+    }
+    if w.Header().Get("Strict-Transport-Security") != "" {
+        return
+    }
+    w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", int64(h.hstsMaxAge.Seconds())))
+}
+
+// schemeURL is the request's url on another scheme and port
+func schemeURL(r *http.Request, scheme string, port, defaultPort int) string {
+    host, _, err := net.SplitHostPort(r.Host)
+    if err != nil {
+        host = r.Host
+    }
+    if port != defaultPort {
+        host = net.JoinHostPort(host, strconv.Itoa(port))
+    }
+    return scheme + "://" + host + r.URL.RequestURI()
+}
\ No newline at end of file
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+    "log"
+    "net"
+    
+    "mole/internal/wire"
+    "mole/server/tunnel"
+)
+
+// serveTCP accepts public connections on a tcp tunnel's port and relays each
+// one as its own stream until the tunnel closes its listener
+func (h *Handler) serveTCP(t *tunnel.Tunnel) {
+    for {
+        conn, err := t.Listener.Accept()
+        if err != nil {
+            return
+        }
+        if t.Draining() {
+            conn.Close()
+            continue
+        }
+        go h.handleTCP(t, conn)
+    }
+}
+
+func (h *Handler) handleTCP(t *tunnel.Tunnel, conn net.Conn) {
+    defer conn.Close()
+    
+    streamID := h.generateID()
+    pending := h.open(t, streamID)
+    defer h.pending.remove(streamID)
+    
+    log.Printf("[TCP] Connection %s from %s on port %d", streamID, conn.RemoteAddr(), t.RemotePort)
+    
+    // the client dials its local service when it sees the open frame and
+    // answers with an end frame if that fails
+    err := t.WriteFrame(&wire.Frame{
+        Type: wire.FrameOpen,
+        ID:   streamID,
+        Addr: conn.RemoteAddr().String(),
+    })
+    if err != nil {
+        log.Printf("[ERROR] Failed to open stream %s: %v", streamID, err)
+        return
+    }
+    
+    h.pipe(t, pending, conn, conn)
+    log.Printf("[TCP] Connection %s closed", streamID)
+}
\ No newline at end of file
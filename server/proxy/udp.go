@@ -0,0 +1,202 @@
+package proxy
+
+import (
+    "log"
+    "net"
+    "sync"
+    "sync/atomic"
+    "time"
+    
+    "mole/internal/wire"
+    "mole/server/tunnel"
+)
+
+const (
+    // largest payload a udp datagram can carry
+    maxDatagramSize = 65535
+    
+    // datagrams waiting per session for the tunnel, as many as the client
+    // takes before granting more credit
+    udpQueueSize = wire.StreamWindow
+)
+
+// a public peer of a udp tunnel, relayed as its own stream
+type udpSession struct {
+    id       string
+    addr     net.Addr
+    pending  *pendingRequest
+    lastSeen atomic.Int64
+    
+    // datagrams from the peer on their way to the client
+    queue   chan []byte
+    evicted atomic.Bool
+}
+
+func (s *udpSession) touch() {
+    s.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (s *udpSession) idle() time.Duration {
+    return time.Since(time.Unix(0, s.lastSeen.Load()))
+}
+
+// serveUDP reads datagrams from a udp tunnel's port, opening a session per
+// peer address, until the tunnel closes its socket
+func (h *Handler) serveUDP(t *tunnel.Tunnel) {
+    var mutex sync.Mutex
+    sessions := make(map[string]*udpSession)
+    
+    buf := make([]byte, maxDatagramSize)
+    for {
+        n, addr, err := t.PacketConn.ReadFrom(buf)
+        if err != nil {
+            return
+        }
+        
+        mutex.Lock()
+        key := addr.String()
+        session := sessions[key]
+        if session == nil && !t.Draining() {
+            if len(sessions) >= h.udpMaxSessions {
+                h.evictUDPSession(t, sessions)
+            }
+            session = h.openUDPSession(t, addr)
+            sessions[key] = session
+            go h.sendUDP(t, session)
+            go func() {
+                h.relayUDP(t, session)
+                mutex.Lock()
+                // the peer may already have a newer session
+                if sessions[key] == session {
+                    delete(sessions, key)
+                }
+                mutex.Unlock()
+            }()
+        }
+        mutex.Unlock()
+        
+        if session == nil {
+            continue
+        }
+        
+        // the read loop never waits on the tunnel. a peer sending faster
+        // than the client takes them loses datagrams, as it would on a slow
+        // link, rather than holding up other peers.
+        session.touch()
+        select {
+        case session.queue <- append([]byte(nil), buf[:n]...):
+        default:
+        }
+    }
+}
+
+// evictUDPSession closes the session that went longest without traffic, so
+// a flood of new peers cannot grow a tunnel's sessions without bound
+func (h *Handler) evictUDPSession(t *tunnel.Tunnel, sessions map[string]*udpSession) {
+    var oldest *udpSession
+    for _, session := range sessions {
+        if oldest == nil || session.lastSeen.Load() < oldest.lastSeen.Load() {
+            oldest = session
+        }
+    }
+    if oldest == nil {
+        return
+    }
+    delete(sessions, oldest.addr.String())
+    
+    // its sender tells the client, after the datagrams already on their way
+    log.Printf("[UDP] Session %s evicted, port %d is at its limit of %d sessions", oldest.id, t.RemotePort, h.udpMaxSessions)
+    oldest.evicted.Store(true)
+    h.pending.remove(oldest.id)
+}
+
+func (h *Handler) openUDPSession(t *tunnel.Tunnel, addr net.Addr) *udpSession {
+    session := &udpSession{
+        id:    h.generateID(),
+        addr:  addr,
+        queue: make(chan []byte, udpQueueSize),
+    }
+    session.touch()
+    session.pending = h.open(t, session.id)
+    
+    log.Printf("[UDP] Session %s for %s on port %d", session.id, addr, t.RemotePort)
+    return session
+}
+
+// sendUDP opens the session on the client and relays the peer's datagrams
+// to it, one per data frame to keep message boundaries intact. it waits on
+// the tunnel so the read loop does not have to.
+func (h *Handler) sendUDP(t *tunnel.Tunnel, session *udpSession) {
+    // the client opens a local socket for the peer when it sees the open frame
+    err := t.WriteFrame(&wire.Frame{
+        Type: wire.FrameOpen,
+        ID:   session.id,
+        Addr: session.addr.String(),
+    })
+    if err != nil {
+        log.Printf("[ERROR] Failed to open session %s: %v", session.id, err)
+        h.pending.remove(session.id)
+        return
+    }
+    
+    for {
+        select {
+        case datagram := <-session.queue:
+            if !session.pending.window.Take(session.pending.done) {
+                continue
+            }
+            frame := &wire.Frame{Type: wire.FrameData, ID: session.id, Body: datagram}
+            if err := t.WriteFrame(frame); err != nil {
+                log.Printf("[ERROR] Failed to relay datagram for session %s: %v", session.id, err)
+            }
+        
+        case <-session.pending.done:
+            if session.evicted.Load() {
+                t.WriteFrame(&wire.Frame{Type: wire.FrameEnd, ID: session.id})
+            }
+            return
+        }
+    }
+}
+
+// relayUDP writes the client's datagrams back to the peer until the session
+// ends or goes idle
+func (h *Handler) relayUDP(t *tunnel.Tunnel, session *udpSession) {
+    defer h.pending.remove(session.id)
+    
+    timer := time.NewTimer(h.udpIdleTimeout)
+    defer timer.Stop()
+    
+    for {
+        select {
+        case f := <-session.pending.frames:
+            session.pending.take(f)
+            switch f.Type {
+            case wire.FrameData:
+                session.touch()
+                if _, err := t.PacketConn.WriteTo(f.Body, session.addr); err != nil {
+                    log.Printf("[ERROR] Failed to write datagram for session %s: %v", session.id, err)
+                }
+            case wire.FrameEnd:
+                if f.Error != "" {
+                    log.Printf("[ERROR] Session %s failed: %s", session.id, f.Error)
+                }
+                log.Printf("[UDP] Session %s closed by client", session.id)
+                return
+            }
+        
+        case <-session.pending.done:
+            return
+        
+        case <-timer.C:
+            // traffic in either direction keeps the session alive
+            if idle := session.idle(); idle < h.udpIdleTimeout {
+                timer.Reset(h.udpIdleTimeout - idle)
+                continue
+            }
+            log.Printf("[UDP] Session %s idle, closing", session.id)
+            t.WriteFrame(&wire.Frame{Type: wire.FrameEnd, ID: session.id})
+            return
+        }
+    }
+}
\ No newline at end of file
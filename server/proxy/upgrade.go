@@ -0,0 +1,98 @@
+package proxy
+
+import (
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+    
+    "mole/internal/wire"
+    "mole/server/tunnel"
+)
+
+// how long the second direction of a piped connection may stay open after
+// the first one finished
+const pipeCloseGrace = 10 * time.Second
+
+// isUpgradeRequest reports whether the caller asks to switch protocols, for
+// example to a websocket
+func isUpgradeRequest(r *http.Request) bool {
+    if r.Header.Get("Upgrade") == "" {
+        return false
+    }
+    for _, value := range r.Header.Values("Connection") {
+        for _, token := range strings.Split(value, ",") {
+            if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// serveUpgrade takes over the public connection once the local server agreed
+// to switch protocols and pipes raw bytes both ways through the tunnel
+func (h *Handler) serveUpgrade(w http.ResponseWriter, t *tunnel.Tunnel, pending *pendingRequest, resp *wire.Frame) {
+    conn, brw, err := http.NewResponseController(w).Hijack()
+    if err != nil {
+        log.Printf("[ERROR] Cannot upgrade request %s: %v", pending.id, err)
+        h.cancel(t, pending.id)
+        http.Error(w, "upgrade not supported", http.StatusInternalServerError)
+        return
+    }
+    defer conn.Close()
+    
+    // the connection is ours now, so the handshake response is written by hand
+    brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+    resp.Headers.Write(brw)
+    brw.WriteString("\r\n")
+    if err := brw.Flush(); err != nil {
+        h.cancel(t, pending.id)
+        return
+    }
+    
+    log.Printf("[UPGRADE] Request %s switched to %s", pending.id, resp.Headers.Get("Upgrade"))
+    
+    // reading through brw keeps any bytes the server already buffered
+    h.pipe(t, pending, conn, brw.Reader)
+    log.Printf("[UPGRADE] Connection closed for request %s", pending.id)
+}
+
+// pipe relays raw bytes between a public connection and a tunnel stream
+// until both directions are done. src is what gets read from conn.
+func (h *Handler) pipe(t *tunnel.Tunnel, pending *pendingRequest, conn net.Conn, src io.Reader) {
+    // public caller -> client
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        end := &wire.Frame{Type: wire.FrameEnd, ID: pending.id}
+        if _, err := copyToTunnel(t, pending, src); err != nil {
+            end.Error = err.Error()
+        }
+        t.WriteFrame(end)
+    }()
+    
+    // client -> public caller, half-closing once the client side is done
+    if _, err := io.Copy(conn, pending); err != nil {
+        conn.Close()
+    } else {
+        closeWrite(conn)
+    }
+    
+    select {
+    case <-done:
+    case <-time.After(pipeCloseGrace):
+        // the caller kept its side open, drop it and let the client know
+        h.cancel(t, pending.id)
+    }
+}
+
+func closeWrite(conn net.Conn) {
+    if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+        cw.CloseWrite()
+        return
+    }
+    conn.Close()
+}
\ No newline at end of file
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+    
+    "mole/server/tunnel"
+)
+
+// serve runs a listener until it fails or is shut down
+func serve(listen func() error) {
+    if err := listen(); !errors.Is(err, http.ErrServerClosed) {
+        log.Fatal(err)
+    }
+}
+
+// shutdown stops accepting public requests, gives the ones in flight until
+// the timeout to finish, then tells tunnel clients the server is going
+// away and closes everything
+func shutdown(servers []*http.Server, manager *tunnel.Manager, timeout time.Duration) {
+    log.Printf("waiting up to %s for in-flight requests", timeout)
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    
+    // tcp and udp ports stop accepting along with the http listeners
+    manager.CloseListeners()
+    
+    // tunnel websockets are hijacked, Shutdown leaves them open so the
+    // requests still in flight can be answered through them
+    var wg sync.WaitGroup
+    for _, server := range servers {
+        wg.Add(1)
+        go func(server *http.Server) {
+            defer wg.Done()
+            server.Shutdown(ctx)
+        }(server)
+    }
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        manager.WaitTCP(ctx)
+    }()
+    wg.Wait()
+    if ctx.Err() != nil {
+        log.Printf("shutdown timeout passed, dropping the requests still in flight")
+    }
+    
+    manager.Shutdown()
+    for _, server := range servers {
+        server.Close()
+    }
+    log.Printf("server stopped")
+}
\ No newline at end of file
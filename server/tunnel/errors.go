@@ -0,0 +1,17 @@
+package tunnel
+
+import "fmt"
+
+// a rejected registration, sent to the client as an error frame
+type RegistrationError struct {
+    Code    string
+    Message string
+}
+
+func (e *RegistrationError) Error() string {
+    return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+func registrationError(code, format string, args ...interface{}) *RegistrationError {
+    return &RegistrationError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
\ No newline at end of file
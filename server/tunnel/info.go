@@ -0,0 +1,117 @@
+package tunnel
+
+import (
+    "log"
+    "net"
+    "sort"
+    "time"
+    
+    "mole/internal/wire"
+)
+
+// TunnelInfo describes a tunnel for the admin api
+type TunnelInfo struct {
+    Name        string    `json:"name"`
+    Kind        string    `json:"kind"`
+    Subdomain   string    `json:"subdomain,omitempty"`
+    RemotePort  int       `json:"remote_port,omitempty"`
+    URL         string    `json:"url"`
+    Owner       string    `json:"owner"`
+    ClientIP    string    `json:"client_ip"`
+    ConnectedAt time.Time `json:"connected_at"`
+    Protocol    string    `json:"protocol"`
+    BytesIn     int64     `json:"bytes_in"`
+    BytesOut    int64     `json:"bytes_out"`
+    InFlight    int       `json:"in_flight"`
+    RTTMS       float64   `json:"rtt_ms"`
+    Attached    bool      `json:"attached"`
+    Draining    bool      `json:"draining"`
+}
+
+// Tunnels describes every registered tunnel, those held for a resume
+// included, sorted by name
+func (m *Manager) Tunnels() []TunnelInfo {
+    tunnels := m.registered()
+    list := make([]TunnelInfo, 0, len(tunnels))
+    for _, t := range tunnels {
+        ip, _, err := net.SplitHostPort(t.ClientAddr())
+        if err != nil {
+            ip = t.ClientAddr()
+        }
+        list = append(list, TunnelInfo{
+            Name:        t.Name(),
+            Kind:        t.Kind,
+            Subdomain:   t.Subdomain,
+            RemotePort:  t.RemotePort,
+            URL:         m.publicURL(t),
+            Owner:       t.Owner,
+            ClientIP:    ip,
+            ConnectedAt: t.ConnectedAt,
+            Protocol:    t.Protocol,
+            BytesIn:     t.BytesIn(),
+            BytesOut:    t.BytesOut(),
+            InFlight:    m.inFlight(t),
+            RTTMS:       float64(t.RTT()) / float64(time.Millisecond),
+            Attached:    t.isAttached(),
+            Draining:    t.Draining(),
+        })
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Name < list[j].Name
+    })
+    return list
+}
+
+// Disconnect closes the tunnel with the given name for good, telling its
+// client not to reconnect. it reports whether there was such a tunnel.
+func (m *Manager) Disconnect(name string) bool {
+    for _, t := range m.registered() {
+        if t.Name() == name {
+            m.disconnect(t)
+            return true
+        }
+    }
+    return false
+}
+
+// DisconnectOwner closes every tunnel of an owner, returning how many
+func (m *Manager) DisconnectOwner(owner string) int {
+    count := 0
+    for _, t := range m.registered() {
+        if t.Owner == owner {
+            m.disconnect(t)
+            count++
+        }
+    }
+    return count
+}
+
+// NormalizeSubdomain checks a subdomain against the subdomain policy and
+// returns it in the form tunnels register it
+func (m *Manager) NormalizeSubdomain(name string) (string, error) {
+    return m.policy.normalize(name)
+}
+
+func (m *Manager) disconnect(t *Tunnel) {
+    if t.isAttached() {
+        t.WriteControl(map[string]interface{}{
+            "type":  wire.FrameError,
+            "code":  wire.CodeDisconnected,
+            "error": "tunnel disconnected by the server administrator",
+        })
+    }
+    log.Printf("tunnel %s disconnected by the administrator", t.Name())
+    m.retire(t)
+}
+
+// registered returns every tunnel with a session
+func (m *Manager) registered() []*Tunnel {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+    
+    tunnels := make([]*Tunnel, 0, len(m.sessions))
+    for _, t := range m.sessions {
+        tunnels = append(tunnels, t)
+    }
+    return tunnels
+}
\ No newline at end of file
@@ -1,28 +1,106 @@
 package tunnel
 
 import (
+    "context"
+    "errors"
+    "fmt"
     "log"
+    "net"
     "net/http"
+    "strings"
     "sync"
+    "time"
     
     "github.com/gorilla/websocket"
+    
+    "mole/internal/wire"
+    "mole/server/auth"
+    "mole/server/config"
 )
 
+// handler for frames sent by tunnel clients over the websocket
+type FrameHandler interface {
+    HandleFrame(t *Tunnel, f *wire.Frame)
+    TunnelOpened(t *Tunnel)
+    TunnelDetached(t *Tunnel)
+    TunnelClosed(t *Tunnel)
+    InFlight(t *Tunnel) int
+}
+
+// how long a replaced tunnel may keep serving its in-flight requests
+const takeoverGrace = 30 * time.Second
+
+// register message sent by the client as the first websocket message
+type registerMessage struct {
+    Type       string   `json:"type"`
+    Kind       string   `json:"kind"`
+    Subdomain  string   `json:"subdomain"`
+    RemotePort int      `json:"remote_port"`
+    Protocols  []string `json:"protocols"`
+    Token      string   `json:"token"`
+    Takeover   bool     `json:"takeover"`
+    Resume     string   `json:"resume"`
+    Received   uint64   `json:"received"`
+    HTTPPolicy string   `json:"http_policy"`
+}
+
 type Manager struct {
-    tunnels  map[string]*websocket.Conn
-    mutex    sync.RWMutex
-    upgrader websocket.Upgrader
+    tunnels    map[string]*Tunnel
+    tcpTunnels map[int]*Tunnel
+    udpTunnels map[int]*Tunnel
+    sessions   map[string]*Tunnel
+    mutex      sync.RWMutex
+    upgrader   websocket.Upgrader
+    handler    FrameHandler
+    domain     string
+    port       int
+    httpPort   int
+    useHTTPS   bool
+    httpPolicy string
+    tokens     *auth.Store
+    reserved   *auth.Reservations
+    policy     *subdomainPolicy
+    grace      time.Duration
+    ping       time.Duration
+    pong       time.Duration
+    tcpPorts   *portPool
+    udpPorts   *portPool
+    
+    // set once shutdown begins, raw tunnels get no new public sockets
+    closing bool
 }
 
-func NewManager() *Manager {
-    return &Manager{
-        tunnels: make(map[string]*websocket.Conn),
+func NewManager(cfg *config.Config, tokens *auth.Store, reserved *auth.Reservations) *Manager {
+    m := &Manager{
+        tunnels:    make(map[string]*Tunnel),
+        tcpTunnels: make(map[int]*Tunnel),
+        udpTunnels: make(map[int]*Tunnel),
+        sessions:   make(map[string]*Tunnel),
         upgrader: websocket.Upgrader{
             CheckOrigin: func(r *http.Request) bool {
                 return true // allow all origins for development
             },
         },
+        domain:     cfg.Domain,
+        port:       cfg.Port,
+        httpPort:   cfg.HTTPPort,
+        useHTTPS:   cfg.UseHTTPS,
+        httpPolicy: cfg.HTTPPolicy,
+        tokens:     tokens,
+        reserved:   reserved,
+        policy:     newSubdomainPolicy(cfg),
+        grace:      cfg.ResumeGrace,
+        ping:       cfg.PingInterval,
+        pong:       cfg.PingTimeout,
+        tcpPorts:   newPortPool("tcp", cfg.TCPPortMin, cfg.TCPPortMax),
+        udpPorts:   newPortPool("udp", cfg.UDPPortMin, cfg.UDPPortMax),
     }
+    m.registerMetrics()
+    return m
+}
+
+func (m *Manager) SetHandler(handler FrameHandler) {
+    m.handler = handler
 }
 
 func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -33,12 +111,8 @@ func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
     }
     defer conn.Close()
     
-    // expect first message to contain subdomain
-    var msg struct {
-        Type      string `json:"type"`
-        Subdomain string `json:"subdomain"`
-    }
-    
+    // expect first message to register the tunnel
+    var msg registerMessage
     if err := conn.ReadJSON(&msg); err != nil {
         log.Printf("failed to read initial message: %v", err)
         return
@@ -49,41 +123,601 @@ func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    subdomain := msg.Subdomain
-    if subdomain == "" {
-        log.Printf("subdomain is required")
+    // the token may come in the register message or on the websocket dial
+    if msg.Token == "" {
+        msg.Token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+    }
+    
+    if msg.Kind == "" {
+        msg.Kind = KindHTTP
+    }
+    
+    if wire.NegotiateProtocol(msg.Protocols) == "" {
+        err := registrationError(wire.CodeUnsupportedVersion, "client speaks none of the protocols %s and %s, upgrade mole", wire.ProtocolBinary, wire.ProtocolJSON)
+        log.Printf("registration rejected from %s: %v", r.RemoteAddr, err)
+        m.rejectRegistration(conn, err)
         return
     }
     
-    // register the tunnel
-    m.mutex.Lock()
-    m.tunnels[subdomain] = conn
-    m.mutex.Unlock()
+    owner, err := m.authenticate(msg.Token)
+    if err != nil {
+        log.Printf("registration rejected from %s: %v", r.RemoteAddr, err)
+        m.rejectRegistration(conn, err)
+        return
+    }
+    
+    // a reconnecting client picks its tunnel back up, in-flight requests
+    // included, otherwise register a new one
+    t := m.resume(&msg, owner, conn)
+    resumed := t != nil
+    if resumed {
+        resumesTotal.Inc()
+        log.Printf("tunnel resumed: %s (protocol %s)", t.Name(), t.Protocol)
+    } else {
+        t, err = m.register(&msg, owner, conn)
+        if err != nil {
+            log.Printf("registration rejected from %s: %v", r.RemoteAddr, err)
+            m.rejectRegistration(conn, err)
+            return
+        }
+        
+        m.mutex.Lock()
+        m.sessions[t.ID] = t
+        m.mutex.Unlock()
+        registrationsTotal.Inc(t.Kind)
+        log.Printf("tunnel registered: %s (protocol %s, owner %s)", t.Name(), t.Protocol, t.Owner)
+    }
+    
+    // one read loop per tunnel at a time, a resumed connection waits for
+    // the one it replaced so the count of frames received is final
+    t.reader.Lock()
+    defer t.reader.Unlock()
+    
+    // send confirmation, the session lets the client resume after a drop.
+    // a resuming client also learns how many of its frames arrived.
+    reply := map[string]interface{}{
+        "type":     "registered",
+        "kind":     t.Kind,
+        "protocol": t.Protocol,
+        "url":      m.publicURL(t),
+        "session":  t.ID,
+        "resumed":  resumed,
+        "received": t.link.Received(),
+    }
+    if t.Kind == KindTCP || t.Kind == KindUDP {
+        reply["remote_port"] = t.RemotePort
+    } else {
+        reply["subdomain"] = t.Subdomain
+    }
+    t.currentWriter().WriteControlJSON(reply)
+    
+    // stream frames follow the confirmation, starting with the ones a
+    // resuming client missed
+    var peerReceived uint64
+    if resumed {
+        peerReceived = msg.Received
+    }
+    if err := t.link.Attach(t.currentWriter(), peerReceived); err != nil {
+        log.Printf("tunnel %s cannot be resumed: %v", t.Name(), err)
+        m.retire(t)
+        m.disconnected(t, conn)
+        return
+    }
+    
+    if m.handler != nil && !resumed {
+        m.handler.TunnelOpened(t)
+    }
+    
+    // pings detect clients that vanished without closing the connection
+    hb := wire.StartHeartbeat(conn, m.ping, m.pong, t.setRTT)
+    defer hb.Stop()
+    
+    // read loop: dispatch every frame from the client until the connection closes
+    for {
+        hb.Extend()
+        messageType, data, err := conn.ReadMessage()
+        if err != nil {
+            var netErr net.Error
+            if errors.As(err, &netErr) && netErr.Timeout() {
+                heartbeatTimeouts.Inc()
+                log.Printf("tunnel %s missed its heartbeat, evicting", t.Name())
+            }
+            break
+        }
+        t.bytesIn.Add(int64(len(data)))
+        bytesTotal.Add(float64(len(data)), "in")
+        
+        if t.Legacy {
+            m.handleLegacy(t, data)
+            continue
+        }
+        
+        frame, err := wire.DecodeFrame(messageType, data)
+        if err != nil {
+            log.Printf("invalid frame from tunnel %s: %v", t.Name(), err)
+            continue
+        }
+        
+        if frame.Type == wire.FrameAck {
+            t.link.Acknowledge(frame.Ack)
+            continue
+        }
+        t.link.Receive(frame)
+        
+        if frame.Type == wire.FrameDraining {
+            log.Printf("tunnel %s is draining, %d requests in flight", t.Name(), m.inFlight(t))
+            t.setDraining()
+            continue
+        }
+        
+        if m.handler == nil {
+            continue
+        }
+        m.handler.HandleFrame(t, frame)
+    }
     
-    log.Printf("tunnel registered for subdomain: %s", subdomain)
+    m.disconnected(t, conn)
+}
+
+// handleLegacy passes the answer of a client from before frames on as the
+// frames a current client would have sent
+func (m *Manager) handleLegacy(t *Tunnel, data []byte) {
+    frames, err := wire.DecodeLegacyResponse(data)
+    if err != nil {
+        log.Printf("invalid message from legacy tunnel %s: %v", t.Name(), err)
+        return
+    }
+    if m.handler == nil {
+        return
+    }
+    for _, f := range frames {
+        m.handler.HandleFrame(t, f)
+    }
+}
+
+// disconnected runs when one of the tunnel's connections ends. the tunnel
+// is held for the resume grace period before it is closed for good.
+func (m *Manager) disconnected(t *Tunnel, conn *websocket.Conn) {
+    t.mutex.Lock()
+    if t.conn != conn {
+        // a newer connection already resumed the tunnel
+        t.mutex.Unlock()
+        return
+    }
+    t.detach()
+    // a draining client closed on purpose and is not coming back
+    hold := m.grace > 0 && !t.retired && !t.draining
+    if hold {
+        t.expiry = time.AfterFunc(m.grace, func() { m.expire(t) })
+    }
+    t.mutex.Unlock()
+    
+    if hold {
+        log.Printf("tunnel %s disconnected, holding it %s for the client to resume", t.Name(), m.grace)
+        if m.handler != nil {
+            m.handler.TunnelDetached(t)
+        }
+        return
+    }
+    m.closeTunnel(t)
+}
+
+// expire closes a tunnel whose client did not come back in time
+func (m *Manager) expire(t *Tunnel) {
+    t.mutex.Lock()
+    if t.attached || t.retired {
+        t.mutex.Unlock()
+        return
+    }
+    t.retired = true
+    t.mutex.Unlock()
+    
+    m.closeTunnel(t)
+}
+
+// retire closes a tunnel for good, skipping the resume grace period
+func (m *Manager) retire(t *Tunnel) {
+    t.mutex.Lock()
+    if t.retired {
+        t.mutex.Unlock()
+        return
+    }
+    t.retired = true
+    if t.expiry != nil {
+        t.expiry.Stop()
+    }
+    attached, conn := t.attached, t.conn
+    t.mutex.Unlock()
+    
+    // a connected tunnel is cleaned up by its read loop
+    if attached {
+        conn.Close()
+        return
+    }
+    m.closeTunnel(t)
+}
+
+func (m *Manager) closeTunnel(t *Tunnel) {
+    m.unregister(t)
+    t.close()
+    if m.handler != nil {
+        m.handler.TunnelClosed(t)
+    }
+    
+    log.Printf("tunnel closed: %s", t.Name())
+}
+
+// authenticate returns the name of the token owner, empty when the server
+// does not require tokens
+func (m *Manager) authenticate(token string) (string, error) {
+    // without configured tokens the server stays open, as before
+    if !m.tokens.Enabled() {
+        return "", nil
+    }
+    
+    t, ok := m.tokens.Authenticate(token)
+    if !ok {
+        return "", registrationError(wire.CodeUnauthorized, "invalid or missing token")
+    }
+    return t.Name, nil
+}
+
+// resume hands a tunnel to the reconnecting client that owns it, returning
+// nil when there is nothing to resume. the streams it carried carry on, the
+// frames either side missed are sent again once the new connection is
+// confirmed.
+func (m *Manager) resume(msg *registerMessage, owner string, conn *websocket.Conn) *Tunnel {
+    if msg.Resume == "" {
+        return nil
+    }
+    
+    m.mutex.RLock()
+    t := m.sessions[msg.Resume]
+    m.mutex.RUnlock()
+    
+    // the session id is only ever sent to the client that registered the
+    // tunnel, it has to come back with the same owner and shape
+    if t == nil || t.Owner != owner || t.Kind != msg.Kind || t.Protocol != wire.NegotiateProtocol(msg.Protocols) {
+        return nil
+    }
+    if !t.link.CanResume(msg.Received) {
+        log.Printf("tunnel %s cannot be resumed, the frames its client missed are gone", t.Name())
+        return nil
+    }
+    if !t.attach(conn) {
+        return nil
+    }
+    return t
+}
+
+// rejectRegistration tells the client why it was turned away, the
+// connection is closed right after
+func (m *Manager) rejectRegistration(conn *websocket.Conn, err error) {
+    code, message := wire.CodeInvalidRequest, err.Error()
+    if regErr, ok := err.(*RegistrationError); ok {
+        code, message = regErr.Code, regErr.Message
+    }
+    rejectionsTotal.Inc(code)
     
-    // send confirmation
     conn.WriteJSON(map[string]interface{}{
-        "type":    "registered",
-        "subdomain": subdomain,
+        "type":  wire.FrameError,
+        "code":  code,
+        "error": message,
     })
+}
+
+func (m *Manager) register(msg *registerMessage, owner string, conn *websocket.Conn) (*Tunnel, error) {
+    protocol := wire.NegotiateProtocol(msg.Protocols)
+    
+    switch msg.Kind {
+    case KindHTTP:
+        if msg.Subdomain != "" {
+            name, err := m.policy.normalize(msg.Subdomain)
+            if err != nil {
+                return nil, err
+            }
+            msg.Subdomain = name
+            
+            if holder, ok := m.reserved.Owner(msg.Subdomain); ok && holder != owner {
+                return nil, registrationError(wire.CodeReserved, "subdomain %s is reserved", msg.Subdomain)
+            }
+        }
+        
+        httpPolicy, err := m.resolveHTTPPolicy(msg.HTTPPolicy)
+        if err != nil {
+            return nil, err
+        }
+        
+        t := newTunnel(KindHTTP, protocol, conn)
+        t.Owner = owner
+        t.Subdomain = msg.Subdomain
+        t.HTTPPolicy = httpPolicy
+        t.Legacy = len(msg.Protocols) == 0
+        
+        m.mutex.Lock()
+        if t.Subdomain == "" {
+            // pick a free name under the lock so no one else can claim it first
+            name, err := m.freeName()
+            if err != nil {
+                m.mutex.Unlock()
+                return nil, err
+            }
+            t.Subdomain = name
+        }
+        existing := m.tunnels[t.Subdomain]
+        if existing != nil && !existing.isAttached() && owner != "" && existing.Owner == owner {
+            // the previous client is gone and only held for a resume that
+            // is not coming, a restarted client does not have to wait.
+            // anonymous clients cannot prove they are that client, they
+            // wait for the grace period like everyone else
+            m.tunnels[t.Subdomain] = t
+            m.mutex.Unlock()
+            m.retire(existing)
+            return t, nil
+        }
+        if existing != nil {
+            // only the owner of a live tunnel may take it over, anonymous
+            // clients cannot prove they are the same owner
+            if !msg.Takeover {
+                m.mutex.Unlock()
+                return nil, registrationError(wire.CodeInUse, "subdomain %s is already connected", t.Subdomain)
+            }
+            if owner == "" || existing.Owner != owner {
+                m.mutex.Unlock()
+                return nil, registrationError(wire.CodeInUse, "subdomain %s is connected by another owner", t.Subdomain)
+            }
+        }
+        m.tunnels[t.Subdomain] = t
+        m.mutex.Unlock()
+        
+        if existing != nil {
+            log.Printf("tunnel %s taken over, draining previous connection", t.Subdomain)
+            go m.drain(existing)
+        }
+        return t, nil
+    
+    case KindTCP:
+        var listener net.Listener
+        port, err := m.tcpPorts.claim(msg.RemotePort, func(port int) (err error) {
+            listener, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
+            return err
+        })
+        if err != nil {
+            return nil, registrationError(wire.CodeUnavailable, "%v", err)
+        }
+        
+        t := newTunnel(KindTCP, protocol, conn)
+        t.Owner = owner
+        t.RemotePort = port
+        t.Listener = listener
+        m.mutex.Lock()
+        if m.closing {
+            m.mutex.Unlock()
+            listener.Close()
+            m.tcpPorts.release(port)
+            return nil, registrationError(wire.CodeUnavailable, "server is shutting down")
+        }
+        m.tcpTunnels[port] = t
+        m.mutex.Unlock()
+        return t, nil
+    
+    case KindUDP:
+        var packetConn net.PacketConn
+        port, err := m.udpPorts.claim(msg.RemotePort, func(port int) (err error) {
+            packetConn, err = net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+            return err
+        })
+        if err != nil {
+            return nil, registrationError(wire.CodeUnavailable, "%v", err)
+        }
+        
+        t := newTunnel(KindUDP, protocol, conn)
+        t.Owner = owner
+        t.RemotePort = port
+        t.PacketConn = packetConn
+        m.mutex.Lock()
+        if m.closing {
+            m.mutex.Unlock()
+            packetConn.Close()
+            m.udpPorts.release(port)
+            return nil, registrationError(wire.CodeUnavailable, "server is shutting down")
+        }
+        m.udpTunnels[port] = t
+        m.mutex.Unlock()
+        return t, nil
+    
+    default:
+        return nil, registrationError(wire.CodeInvalidRequest, "unsupported tunnel kind: %s", msg.Kind)
+    }
+}
+
+// CloseListeners stops raw tunnels from taking new public connections when
+// shutdown begins. tcp connections already accepted carry on until the
+// tunnels close, udp has no connections and its sessions end here.
+func (m *Manager) CloseListeners() {
+    m.mutex.Lock()
+    m.closing = true
+    tunnels := make([]*Tunnel, 0, len(m.tcpTunnels)+len(m.udpTunnels))
+    for _, t := range m.tcpTunnels {
+        tunnels = append(tunnels, t)
+    }
+    for _, t := range m.udpTunnels {
+        tunnels = append(tunnels, t)
+    }
+    m.mutex.Unlock()
+    
+    for _, t := range tunnels {
+        if t.Listener != nil {
+            t.Listener.Close()
+        }
+        if t.PacketConn != nil {
+            t.PacketConn.Close()
+        }
+    }
+    if len(tunnels) > 0 {
+        log.Printf("stopped accepting on %d tcp and udp ports", len(tunnels))
+    }
+}
+
+// WaitTCP waits until the connections open on tcp tunnels are closed, or
+// ctx is done
+func (m *Manager) WaitTCP(ctx context.Context) {
+    ticker := time.NewTicker(100 * time.Millisecond)
+    defer ticker.Stop()
     
-    // keep connection alive and handle cleanup
     for {
-        if _, _, err := conn.NextReader(); err != nil {
-            break
+        open := 0
+        m.mutex.RLock()
+        for _, t := range m.tcpTunnels {
+            open += m.inFlight(t)
+        }
+        m.mutex.RUnlock()
+        if open == 0 {
+            return
+        }
+        
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// Shutdown tells every connected client the server is going away and closes
+// all tunnels, without holding them for a resume
+func (m *Manager) Shutdown() {
+    tunnels := m.registered()
+    
+    var wg sync.WaitGroup
+    for _, t := range tunnels {
+        wg.Add(1)
+        go func(t *Tunnel) {
+            defer wg.Done()
+            if t.isAttached() {
+                t.WriteControl(map[string]interface{}{
+                    "type":  wire.FrameGoingAway,
+                    "error": "server is shutting down",
+                })
+            }
+            m.retire(t)
+        }(t)
+    }
+    wg.Wait()
+    log.Printf("closed %d tunnels", len(tunnels))
+}
+
+// freeName generates a subdomain that is neither connected nor reserved.
+// callers hold the mutex.
+func (m *Manager) freeName() (string, error) {
+    for i := 0; i < 100; i++ {
+        name := randomName()
+        if m.policy.check(name) != nil {
+            continue
+        }
+        if _, reserved := m.reserved.Owner(name); reserved {
+            continue
+        }
+        if _, exists := m.tunnels[name]; !exists {
+            return name, nil
         }
     }
+    return "", registrationError(wire.CodeUnavailable, "no free subdomain available")
+}
+
+// resolveHTTPPolicy checks the policy a client asked for, falling back to
+// the server default
+func (m *Manager) resolveHTTPPolicy(policy string) (string, error) {
+    switch policy {
+    case "":
+        return m.httpPolicy, nil
+    case HTTPRedirect, HTTPBoth:
+        return policy, nil
+    case HTTPOnly:
+        if m.useHTTPS && m.httpPort == 0 {
+            return "", registrationError(wire.CodeInvalidRequest, "plain http is not served")
+        }
+        return policy, nil
+    default:
+        return "", registrationError(wire.CodeInvalidRequest, "unknown http policy: %s", policy)
+    }
+}
+
+// publicURL is the address the public reaches the tunnel at
+func (m *Manager) publicURL(t *Tunnel) string {
+    if t.Kind == KindTCP || t.Kind == KindUDP {
+        return fmt.Sprintf("%s://%s:%d", t.Kind, m.domain, t.RemotePort)
+    }
+    
+    scheme, port, defaultPort := "http", m.port, 80
+    if m.useHTTPS && t.HTTPPolicy == HTTPOnly {
+        port = m.httpPort
+    } else if m.useHTTPS {
+        scheme, defaultPort = "https", 443
+    }
+    if port == defaultPort {
+        return fmt.Sprintf("%s://%s.%s", scheme, t.Subdomain, m.domain)
+    }
+    return fmt.Sprintf("%s://%s.%s:%d", scheme, t.Subdomain, m.domain, port)
+}
+
+// drain lets a replaced tunnel finish its in-flight requests, new requests
+// already go to its successor, then tells the client it was replaced and
+// closes the connection
+func (m *Manager) drain(t *Tunnel) {
+    deadline := time.Now().Add(takeoverGrace)
+    for m.inFlight(t) > 0 && time.Now().Before(deadline) {
+        time.Sleep(100 * time.Millisecond)
+    }
     
-    // cleanup when connection closes
+    t.WriteControl(map[string]interface{}{
+        "type":  wire.FrameError,
+        "code":  wire.CodeReplaced,
+        "error": "tunnel taken over by another connection",
+    })
+    m.retire(t)
+}
+
+// inFlight counts the tunnel's open requests and connections
+func (m *Manager) inFlight(t *Tunnel) int {
+    if m.handler == nil {
+        return 0
+    }
+    return m.handler.InFlight(t)
+}
+
+func (m *Manager) unregister(t *Tunnel) {
+    // only remove the entry if a newer tunnel has not taken its place
     m.mutex.Lock()
-    delete(m.tunnels, subdomain)
+    switch t.Kind {
+    case KindTCP:
+        if m.tcpTunnels[t.RemotePort] == t {
+            delete(m.tcpTunnels, t.RemotePort)
+        }
+    case KindUDP:
+        if m.udpTunnels[t.RemotePort] == t {
+            delete(m.udpTunnels, t.RemotePort)
+        }
+    default:
+        if m.tunnels[t.Subdomain] == t {
+            delete(m.tunnels, t.Subdomain)
+        }
+    }
+    delete(m.sessions, t.ID)
     m.mutex.Unlock()
     
-    log.Printf("tunnel closed for subdomain: %s", subdomain)
+    // release the public socket of raw tunnels
+    if t.Listener != nil {
+        t.Listener.Close()
+        m.tcpPorts.release(t.RemotePort)
+    }
+    if t.PacketConn != nil {
+        t.PacketConn.Close()
+        m.udpPorts.release(t.RemotePort)
+    }
 }
 
-func (m *Manager) GetTunnel(subdomain string) *websocket.Conn {
+func (m *Manager) GetTunnel(subdomain string) *Tunnel {
     m.mutex.RLock()
     defer m.mutex.RUnlock()
     return m.tunnels[subdomain]
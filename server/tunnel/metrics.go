@@ -0,0 +1,35 @@
+package tunnel
+
+import (
+    "mole/internal/metrics"
+)
+
+var (
+    registrationsTotal = metrics.Default.Counter("mole_registrations_total", "Tunnels registered, by kind.", "kind")
+    rejectionsTotal    = metrics.Default.Counter("mole_registration_rejections_total", "Registrations turned away, by error code.", "code")
+    resumesTotal       = metrics.Default.Counter("mole_tunnel_resumes_total", "Tunnels picked back up by a reconnecting client.")
+    bytesTotal         = metrics.Default.Counter("mole_tunnel_bytes_total", "Websocket message bytes, in from clients and out to them.", "direction")
+    heartbeatTimeouts  = metrics.Default.Counter("mole_heartbeat_timeouts_total", "Tunnels evicted for missing their heartbeat.")
+)
+
+// registerMetrics adds the gauges read from the manager's tunnels
+func (m *Manager) registerMetrics() {
+    metrics.Default.GaugeFunc("mole_tunnels", "Registered tunnels, by kind.", []string{"kind"}, func() []metrics.Sample {
+        counts := map[string]float64{KindHTTP: 0, KindTCP: 0, KindUDP: 0}
+        for _, t := range m.registered() {
+            counts[t.Kind]++
+        }
+        return []metrics.Sample{
+            {Labels: []string{KindHTTP}, Value: counts[KindHTTP]},
+            {Labels: []string{KindTCP}, Value: counts[KindTCP]},
+            {Labels: []string{KindUDP}, Value: counts[KindUDP]},
+        }
+    })
+    metrics.Default.GaugeFunc("mole_tunnel_write_queue_depth", "Messages waiting to be written to a tunnel's websocket.", []string{"tunnel"}, func() []metrics.Sample {
+        var samples []metrics.Sample
+        for _, t := range m.registered() {
+            samples = append(samples, metrics.Sample{Labels: []string{t.Name()}, Value: float64(t.currentWriter().Queued())})
+        }
+        return samples
+    })
+}
\ No newline at end of file
@@ -0,0 +1,34 @@
+package tunnel
+
+import (
+    "fmt"
+    "math/rand"
+)
+
+// words for generated subdomains, kept short and unambiguous when read aloud
+var (
+    adjectives = []string{
+        "amber", "bold", "brave", "brisk", "calm", "clever", "cosmic", "crisp",
+        "dapper", "eager", "fancy", "fuzzy", "gentle", "giddy", "golden", "happy",
+        "hidden", "jolly", "keen", "lively", "lucky", "mellow", "merry", "misty",
+        "nimble", "noble", "plucky", "proud", "quick", "quiet", "rapid", "rosy",
+        "rusty", "shiny", "silent", "silver", "sleepy", "snowy", "solar", "spicy",
+        "steady", "sunny", "swift", "tidy", "tiny", "velvet", "vivid", "witty",
+    }
+    nouns = []string{
+        "badger", "beacon", "bison", "breeze", "canyon", "cedar", "comet", "coral",
+        "cricket", "dolphin", "falcon", "fern", "firefly", "fox", "garden", "glacier",
+        "harbor", "hedgehog", "heron", "island", "lagoon", "lantern", "lynx", "maple",
+        "meadow", "meteor", "moose", "nebula", "otter", "owl", "panda", "pebble",
+        "pine", "planet", "puffin", "quokka", "raven", "river", "rocket", "sparrow",
+        "summit", "thunder", "tiger", "tulip", "walrus", "willow", "wombat", "zebra",
+    }
+)
+
+// randomName returns a memorable subdomain like "brave-otter-42"
+func randomName() string {
+    return fmt.Sprintf("%s-%s-%d",
+        adjectives[rand.Intn(len(adjectives))],
+        nouns[rand.Intn(len(nouns))],
+        rand.Intn(100))
+}
\ No newline at end of file
@@ -0,0 +1,30 @@
+package tunnel
+
+import (
+    "testing"
+    
+    "mole/server/config"
+)
+
+func TestRandomNamePassesPolicy(t *testing.T) {
+    p := newSubdomainPolicy(&config.Config{})
+    for i := 0; i < 1000; i++ {
+        name := randomName()
+        if got, err := p.normalize(name); err != nil || got != name {
+            t.Fatalf("generated name %q normalized to %q: %v", name, got, err)
+        }
+    }
+}
+
+func TestNameWords(t *testing.T) {
+    seen := make(map[string]bool)
+    for _, word := range append(append([]string{}, adjectives...), nouns...) {
+        if !isDNSLabel(word) {
+            t.Errorf("word %q cannot be part of a subdomain", word)
+        }
+        if seen[word] {
+            t.Errorf("word %q listed twice", word)
+        }
+        seen[word] = true
+    }
+}
\ No newline at end of file
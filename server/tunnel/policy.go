@@ -0,0 +1,109 @@
+package tunnel
+
+import (
+    "regexp"
+    "strings"
+    
+    "mole/internal/wire"
+    "mole/server/auth"
+    "mole/server/config"
+)
+
+// names clients may not register because they collide with our own hosts
+var defaultReservedNames = []string{
+    "www", "api", "admin", "mail", "smtp", "imap", "pop", "ftp", "ns1",
+    "ns2", "dns", "mx", "vpn", "status", "dashboard", "tunnel", "static", "cdn",
+    "assets", "auth", "login", "root", "localhost",
+}
+
+// rules a requested subdomain has to pass before it is registered
+type subdomainPolicy struct {
+    reserved  map[string]bool
+    blocked   []string
+    patterns  []*regexp.Regexp
+    maxLength int
+}
+
+func newSubdomainPolicy(cfg *config.Config) *subdomainPolicy {
+    p := &subdomainPolicy{
+        reserved:  make(map[string]bool),
+        patterns:  cfg.SubdomainPatterns,
+        maxLength: cfg.SubdomainMaxLength,
+    }
+    
+    reserved := cfg.SubdomainReserved
+    if reserved == nil {
+        reserved = defaultReservedNames
+    }
+    for _, name := range reserved {
+        if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+            p.reserved[name] = true
+        }
+    }
+    for _, word := range cfg.SubdomainBlocked {
+        if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+            p.blocked = append(p.blocked, word)
+        }
+    }
+    
+    // a dns label never exceeds 63 bytes
+    if p.maxLength <= 0 || p.maxLength > 63 {
+        p.maxLength = 63
+    }
+    return p
+}
+
+// normalize converts a requested subdomain to its lowercase ascii form,
+// punycode for international names, and checks it against the policy
+func (p *subdomainPolicy) normalize(subdomain string) (string, error) {
+    name, err := auth.NormalizeSubdomain(subdomain)
+    if err != nil {
+        return "", registrationError(wire.CodeInvalidSubdomain, "subdomain %q is not a valid hostname", subdomain)
+    }
+    if err := p.check(name); err != nil {
+        return "", err
+    }
+    return name, nil
+}
+
+// check validates a normalized subdomain
+func (p *subdomainPolicy) check(name string) error {
+    if len(name) > p.maxLength {
+        return registrationError(wire.CodeSubdomainTooLong, "subdomain %s is longer than %d characters", name, p.maxLength)
+    }
+    if !isDNSLabel(name) {
+        return registrationError(wire.CodeInvalidSubdomain, "subdomain %q must be a single dns label of letters, digits and hyphens", name)
+    }
+    if p.reserved[name] {
+        return registrationError(wire.CodeReserved, "subdomain %s is reserved", name)
+    }
+    for _, word := range p.blocked {
+        if strings.Contains(name, word) {
+            return registrationError(wire.CodeBlocked, "subdomain %s is not allowed", name)
+        }
+    }
+    
+    if len(p.patterns) == 0 {
+        return nil
+    }
+    for _, pattern := range p.patterns {
+        if pattern.MatchString(name) {
+            return nil
+        }
+    }
+    return registrationError(wire.CodeNotAllowed, "subdomain %s does not match the allowed patterns", name)
+}
+
+// isDNSLabel reports whether name is a single lowercase hostname label
+func isDNSLabel(name string) bool {
+    if name == "" || name[0] == '-' || name[len(name)-1] == '-' {
+        return false
+    }
+    for i := 0; i < len(name); i++ {
+        c := name[i]
+        if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+            return false
+        }
+    }
+    return true
+}
\ No newline at end of file
@@ -0,0 +1,126 @@
+package tunnel
+
+import (
+    "errors"
+    "regexp"
+    "strings"
+    "testing"
+    
+    "mole/internal/wire"
+    "mole/server/config"
+)
+
+// errorCode returns the registration code of err, "" for no error
+func errorCode(t *testing.T, err error) string {
+    t.Helper()
+    if err == nil {
+        return ""
+    }
+    var regErr *RegistrationError
+    if !errors.As(err, &regErr) {
+        t.Fatalf("error %v is not a registration error", err)
+    }
+    return regErr.Code
+}
+
+func TestPolicyNormalize(t *testing.T) {
+    p := newSubdomainPolicy(&config.Config{})
+    
+    tests := []struct {
+        subdomain string
+        want      string
+        code      string
+    }{
+        {"myapp", "myapp", ""},
+        {"  MyApp ", "myapp", ""},
+        {"app-2", "app-2", ""},
+        {"0day", "0day", ""},
+        // international names register under their punycode form
+        {"Bücher", "xn--bcher-kva", ""},
+        {"xn--bcher-kva", "xn--bcher-kva", ""},
+        {"ＡＰＰ", "app", ""},
+        {"", "", wire.CodeInvalidSubdomain},
+        {"a.b", "", wire.CodeInvalidSubdomain},
+        {"-app", "", wire.CodeInvalidSubdomain},
+        {"app-", "", wire.CodeInvalidSubdomain},
+        {"my_app", "", wire.CodeInvalidSubdomain},
+        {"my app", "", wire.CodeInvalidSubdomain},
+        {"app/x", "", wire.CodeInvalidSubdomain},
+        {strings.Repeat("a", 63), strings.Repeat("a", 63), ""},
+        {strings.Repeat("a", 64), "", wire.CodeSubdomainTooLong},
+        {"www", "", wire.CodeReserved},
+        {"WWW", "", wire.CodeReserved},
+        {"localhost", "", wire.CodeReserved},
+    }
+    for _, tt := range tests {
+        got, err := p.normalize(tt.subdomain)
+        if code := errorCode(t, err); code != tt.code || got != tt.want {
+            t.Errorf("normalize(%q) = %q, %q, want %q, %q", tt.subdomain, got, code, tt.want, tt.code)
+        }
+    }
+}
+
+func TestPolicyRules(t *testing.T) {
+    p := newSubdomainPolicy(&config.Config{
+        SubdomainReserved:  []string{" Billing ", ""},
+        SubdomainBlocked:   []string{"Admin", " "},
+        SubdomainPatterns:  []*regexp.Regexp{regexp.MustCompile(`^team-`), regexp.MustCompile(`-dev$`)},
+        SubdomainMaxLength: 16,
+    })
+    
+    tests := []struct {
+        name string
+        code string
+    }{
+        {"team-a", ""},
+        {"shop-dev", ""},
+        // the configured list replaces the default names
+        {"team-billing", ""},
+        {"billing", wire.CodeReserved},
+        {"www", wire.CodeNotAllowed},
+        {"team-admins", wire.CodeBlocked},
+        {"sysadmin-dev", wire.CodeBlocked},
+        {"other", wire.CodeNotAllowed},
+        {"team-0123456789ab", wire.CodeSubdomainTooLong},
+        {"team-", wire.CodeInvalidSubdomain},
+    }
+    for _, tt := range tests {
+        if code := errorCode(t, p.check(tt.name)); code != tt.code {
+            t.Errorf("check(%q) = %q, want %q", tt.name, code, tt.code)
+        }
+    }
+}
+
+func TestPolicyMaxLength(t *testing.T) {
+    for _, configured := range []int{0, -1, 64, 1000} {
+        p := newSubdomainPolicy(&config.Config{SubdomainMaxLength: configured})
+        if p.maxLength != 63 {
+            t.Errorf("max length %d became %d, want the dns limit of 63", configured, p.maxLength)
+        }
+    }
+}
+
+func TestIsDNSLabel(t *testing.T) {
+    tests := []struct {
+        name  string
+        valid bool
+    }{
+        {"a", true},
+        {"a-b", true},
+        {"a--b", true},
+        {"123", true},
+        {"xn--bcher-kva", true},
+        {"", false},
+        {"-a", false},
+        {"a-", false},
+        {"A", false},
+        {"a.b", false},
+        {"a_b", false},
+        {"ü", false},
+    }
+    for _, tt := range tests {
+        if got := isDNSLabel(tt.name); got != tt.valid {
+            t.Errorf("isDNSLabel(%q) = %v, want %v", tt.name, got, tt.valid)
+        }
+    }
+}
\ No newline at end of file
@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+    "errors"
+    "fmt"
+    "math/rand"
+    "sync"
+)
+
+// pool of public ports handed out to tcp or udp tunnels
+type portPool struct {
+    network string
+    min     int
+    max     int
+    mutex   sync.Mutex
+    used    map[int]bool
+}
+
+func newPortPool(network string, min, max int) *portPool {
+    return &portPool{
+        network: network,
+        min:     min,
+        max:     max,
+        used:    make(map[int]bool),
+    }
+}
+
+// claim reserves the requested port, or any free port in the range when
+// requested is zero. bind is called with the candidate port and must open
+// the public socket, a failed bind moves on to the next free port.
+func (p *portPool) claim(requested int, bind func(port int) error) (int, error) {
+    if p.min == 0 || p.max == 0 {
+        return 0, fmt.Errorf("%s tunnels are not enabled on this server", p.network)
+    }
+    
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    
+    if requested != 0 {
+        if requested < p.min || requested > p.max {
+            return 0, fmt.Errorf("port %d is outside the allowed range %d-%d", requested, p.min, p.max)
+        }
+        if p.used[requested] {
+            return 0, fmt.Errorf("port %d is already in use", requested)
+        }
+        
+        if err := bind(requested); err != nil {
+            return 0, fmt.Errorf("port %d is not available: %v", requested, err)
+        }
+        p.used[requested] = true
+        return requested, nil
+    }
+    
+    // start at a random offset so ports are not handed out in order
+    size := p.max - p.min + 1
+    start := rand.Intn(size)
+    for i := 0; i < size; i++ {
+        port := p.min + (start+i)%size
+        if p.used[port] {
+            continue
+        }
+        
+        if err := bind(port); err != nil {
+            continue
+        }
+        p.used[port] = true
+        return port, nil
+    }
+    return 0, errors.New("no free " + p.network + " ports")
+}
+
+func (p *portPool) release(port int) {
+    p.mutex.Lock()
+    delete(p.used, port)
+    p.mutex.Unlock()
+}
\ No newline at end of file
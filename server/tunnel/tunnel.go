@@ -0,0 +1,213 @@
+package tunnel
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "net"
+    "sync"
+    "sync/atomic"
+    "time"
+    
+    "github.com/gorilla/websocket"
+    
+    "mole/internal/wire"
+)
+
+// tunnel kinds requested in the register message
+const (
+    KindHTTP = "http"
+    KindTCP  = "tcp"
+    KindUDP  = "udp"
+)
+
+// what an http tunnel does on the plain http listener while https is on
+const (
+    HTTPRedirect = "redirect"  // send plain requests to https
+    HTTPBoth     = "both"      // serve plain and https alike
+    HTTPOnly     = "http-only" // serve plain, send https requests to http
+)
+
+// a registered client connection; ID is unique per registration so state
+// scoped to a tunnel does not leak into a later tunnel on the same subdomain.
+// http tunnels are addressed by Subdomain, tcp and udp tunnels by RemotePort.
+type Tunnel struct {
+    ID         string
+    Kind       string
+    Subdomain  string
+    RemotePort int
+    Listener   net.Listener
+    PacketConn net.PacketConn
+    Protocol   string
+    Owner      string
+    HTTPPolicy string
+    
+    // the client predates frames, it takes each request in one json
+    // message and answers the same way
+    Legacy bool
+    
+    // when the tunnel was registered, it keeps it across resumes
+    ConnectedAt time.Time
+    
+    // latest heartbeat round trip, in nanoseconds
+    rtt atomic.Int64
+    
+    // websocket message bytes received from and sent to the client
+    bytesIn  atomic.Int64
+    bytesOut atomic.Int64
+    
+    // held by the read loop of the tunnel's connection, so after a resume
+    // the new loop only starts once the previous one has returned
+    reader sync.Mutex
+    
+    // stream frames, kept across the connections of a resumed tunnel
+    link *wire.Link
+    
+    // the connection changes when the client resumes the tunnel
+    mutex    sync.Mutex
+    conn     *websocket.Conn
+    writer   *wire.Writer
+    attached bool
+    retired  bool
+    draining bool
+    expiry   *time.Timer
+}
+
+func newTunnel(kind, protocol string, conn *websocket.Conn) *Tunnel {
+    bytes := make([]byte, 8)
+    rand.Read(bytes)
+    t := &Tunnel{
+        ID:          hex.EncodeToString(bytes),
+        Kind:        kind,
+        Protocol:    protocol,
+        ConnectedAt: time.Now(),
+        link:        wire.NewLink(protocol),
+        conn:        conn,
+        attached:    true,
+    }
+    t.writer = wire.NewWriter(conn, t.countWrite)
+    return t
+}
+
+// Name identifies the tunnel in logs
+func (t *Tunnel) Name() string {
+    if t.Kind == KindTCP || t.Kind == KindUDP {
+        return fmt.Sprintf("%s:%d", t.Kind, t.RemotePort)
+    }
+    return t.Subdomain
+}
+
+// WriteJSON queues a message for the tunnel's writer and waits until it is sent
+func (t *Tunnel) WriteJSON(v interface{}) error {
+    return t.currentWriter().WriteJSON(v)
+}
+
+// WriteFrame encodes a frame with the protocol negotiated at registration.
+// while the client is reconnecting it waits for the resumed connection, it
+// fails once the tunnel is closed.
+func (t *Tunnel) WriteFrame(f *wire.Frame) error {
+    return t.link.Send(f)
+}
+
+// WriteControl sends a control message ahead of any queued data messages.
+// legacy clients would take it for a request, they get none.
+func (t *Tunnel) WriteControl(v interface{}) error {
+    if t.Legacy {
+        return nil
+    }
+    return t.currentWriter().WriteControlJSON(v)
+}
+
+// Draining reports whether the client is shutting down, new requests and
+// connections should not be sent to it
+func (t *Tunnel) Draining() bool {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    return t.draining
+}
+
+func (t *Tunnel) setDraining() {
+    t.mutex.Lock()
+    t.draining = true
+    t.mutex.Unlock()
+}
+
+// RTT is the round trip time of the latest answered heartbeat, zero before
+// the first one
+func (t *Tunnel) RTT() time.Duration {
+    return time.Duration(t.rtt.Load())
+}
+
+func (t *Tunnel) setRTT(d time.Duration) {
+    t.rtt.Store(int64(d))
+}
+
+// BytesIn counts what the client has sent over the tunnel
+func (t *Tunnel) BytesIn() int64 {
+    return t.bytesIn.Load()
+}
+
+// BytesOut counts what has been sent to the client over the tunnel
+func (t *Tunnel) BytesOut() int64 {
+    return t.bytesOut.Load()
+}
+
+// countWrite is called by the connection writer for every message sent
+func (t *Tunnel) countWrite(n int) {
+    t.bytesOut.Add(int64(n))
+    bytesTotal.Add(float64(n), "out")
+}
+
+// ClientAddr is the address of the client's current connection
+func (t *Tunnel) ClientAddr() string {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    return t.conn.RemoteAddr().String()
+}
+
+func (t *Tunnel) currentWriter() *wire.Writer {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    return t.writer
+}
+
+func (t *Tunnel) isAttached() bool {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    return t.attached
+}
+
+// attach moves the tunnel onto the connection of a resuming client. the
+// previous connection, if the server had not noticed it drop, is closed.
+func (t *Tunnel) attach(conn *websocket.Conn) bool {
+    t.mutex.Lock()
+    if t.retired {
+        t.mutex.Unlock()
+        return false
+    }
+    if t.expiry != nil {
+        t.expiry.Stop()
+        t.expiry = nil
+    }
+    oldConn, oldWriter := t.conn, t.writer
+    t.conn, t.writer, t.attached = conn, wire.NewWriter(conn, t.countWrite), true
+    t.mutex.Unlock()
+    
+    t.link.Detach(oldWriter)
+    oldWriter.Close()
+    oldConn.Close()
+    return true
+}
+
+// detach is called when the tunnel's connection drops, its streams wait for
+// the client to resume. callers hold the mutex.
+func (t *Tunnel) detach() {
+    t.attached = false
+    t.link.Detach(t.writer)
+    t.writer.Close()
+}
+
+func (t *Tunnel) close() {
+    t.currentWriter().Close()
+    t.link.Close()
+}
\ No newline at end of file